@@ -2,18 +2,15 @@ package blockchain
 
 import (
 	"bytes"
-	"encoding/gob"
+	"context"
 	"log"
 	"time"
 )
 
 type Block struct {
-	Timestamp    int64
+	BlockHeader
 	Hash         []byte
 	Transactions []*Transaction
-	PrevHash     []byte
-	Nonce        int
-	Height       int
 }
 
 func (b *Block) HashTransactions() []byte {
@@ -25,33 +22,72 @@ func (b *Block) HashTransactions() []byte {
 	tree := NewMerkleTree(txHashes)
 	return tree.RootNode.Data
 }
-func CreateBlock(txs []*Transaction, prevHash []byte, height int) *Block {
-	block := &Block{time.Now().Unix(), []byte{}, txs, prevHash, 0, height}
-	pow := NewProof(block)
-	nonce, hash := pow.Run()
-	block.Hash = hash[:]
-	block.Nonce = nonce
+func CreateBlock(ctx context.Context, engine ConsensusEngine, txs []*Transaction, prevHash []byte, height, difficulty int) (*Block, error) {
+	block := &Block{
+		BlockHeader: BlockHeader{
+			PrevHash:   prevHash,
+			Timestamp:  time.Now().Unix(),
+			Height:     height,
+			Difficulty: difficulty,
+		},
+		Transactions: txs,
+	}
+	block.MerkleRoot = block.HashTransactions()
+	if err := engine.Seal(ctx, block); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// Genesis builds the first block from params. Its coinbase pays every
+// allocation and proof of work is searched by a single worker, so the same
+// params always produce the same genesis block.
+func Genesis(engine ConsensusEngine, params *ChainParams) *Block {
+	var outputs []TxOutput
+	for _, alloc := range params.Allocations {
+		outputs = append(outputs, *NewTXOutput(alloc.Amount, alloc.Address))
+	}
+	coinbase := newCoinbase(outputs, 0, params.GenesisMessage)
+
+	if pow, ok := engine.(*ProofOfWorkEngine); ok {
+		engine = &ProofOfWorkEngine{Params: pow.Params, Workers: 1}
+	}
+	block := &Block{
+		BlockHeader: BlockHeader{
+			PrevHash:   []byte{},
+			Timestamp:  params.GenesisTimestamp,
+			Difficulty: params.InitialDifficulty,
+		},
+		Transactions: []*Transaction{coinbase},
+	}
+	block.MerkleRoot = block.HashTransactions()
+	err := engine.Seal(context.Background(), block)
+	Handle(err)
 	return block
 }
 
-func Genesis(coinbase *Transaction) *Block {
-	return CreateBlock([]*Transaction{coinbase}, []byte{}, 0)
+// IncrementExtraNonce gives the miner fresh work once the nonce space is
+// exhausted by bumping the extra nonce of the coinbase and rebuilding the
+// merkle root.
+func (b *Block) IncrementExtraNonce() {
+	coinbase := b.Transactions[0]
+	b.Transactions[0] = coinbase.WithExtraNonce(coinbase.ExtraNonce() + 1)
+	b.MerkleRoot = b.HashTransactions()
+	b.Nonce = 0
 }
 
+// Serialize returns the consensus encoding of the block, see
+// EncodingVersion.
 func (b *Block) Serialize() []byte {
 	var res bytes.Buffer
-	encoder := gob.NewEncoder(&res)
-	err := encoder.Encode(b)
-	Handle(err)
+	b.encode(&res)
 	return res.Bytes()
 }
 
 func Deserialize(data []byte) *Block {
-	var block Block
-	decoder := gob.NewDecoder(bytes.NewReader(data))
-	err := decoder.Decode(&block)
+	block, err := newDecoder(data).block()
 	Handle(err)
-	return &block
+	return block
 }
 
 func Handle(err error) {
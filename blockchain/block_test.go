@@ -0,0 +1,51 @@
+package blockchain
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestIncrementExtraNonce(t *testing.T) {
+	tests := []struct {
+		name       string
+		extraNonce uint64
+		tag        string
+	}{
+		{"first", 0, "tag"},
+		{"no tag", 41, ""},
+		{"carry", 0xff, "tag"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			block := testBlock()
+			coinbase := newCoinbase([]TxOutput{{Value: 20}}, test.extraNonce, test.tag)
+			block.Transactions[0] = coinbase
+			block.MerkleRoot = block.HashTransactions()
+			root := block.MerkleRoot
+
+			block.IncrementExtraNonce()
+			got := block.Transactions[0]
+			if got.ExtraNonce() != test.extraNonce+1 {
+				t.Errorf("extra nonce %d, want %d", got.ExtraNonce(), test.extraNonce+1)
+			}
+			if got.Tag() != test.tag {
+				t.Errorf("tag %q, want %q", got.Tag(), test.tag)
+			}
+			if !bytes.Equal(got.ID, got.Hash()) || bytes.Equal(got.ID, coinbase.ID) {
+				t.Errorf("coinbase ID %x was not recomputed", got.ID)
+			}
+			if coinbase.ExtraNonce() != test.extraNonce {
+				t.Error("the original coinbase was modified")
+			}
+			if !bytes.Equal(block.MerkleRoot, block.HashTransactions()) || bytes.Equal(block.MerkleRoot, root) {
+				t.Error("merkle root was not rebuilt")
+			}
+			if block.Nonce != 0 {
+				t.Errorf("nonce %d, want 0", block.Nonce)
+			}
+			if err := checkTransactionList(block.Transactions, DefaultChainParams().MaxSupply); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -2,8 +2,8 @@ package blockchain
 
 import (
 	"bytes"
-	"crypto/ecdsa"
-	"encoding/gob"
+	"context"
+	"crypto"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -12,91 +12,167 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/dgraph-io/badger"
 )
 
-const (
-	dbPath      = "./tmp/blocks_%s"
-	genesisData = "First Trransaction from Genesis"
-)
+const dbPath = "./tmp/blocks_%s"
+
+// ErrStaleTip is returned by MineBlock when another block became the tip
+// before mining finished.
+var ErrStaleTip = errors.New("Chain tip changed while mining")
 
 type BlockChain struct {
 	LastHash []byte
 	Database *badger.DB
+	Engine   ConsensusEngine
+	Params   *ChainParams
+
+	tipMu      sync.Mutex
+	tipChanged chan struct{}
 }
 
-func (chain *BlockChain) MineBlock(transactions []*Transaction) *Block {
-	var lastHash []byte
-	var lastHeight int
-	for _, tx := range transactions {
-		if chain.VerifyTransaction(tx) != true {
-			log.Panic("Invalid Transaction")
-		}
+// TipChanged returns a channel that is closed the next time LastHash moves.
+func (chain *BlockChain) TipChanged() <-chan struct{} {
+	chain.tipMu.Lock()
+	defer chain.tipMu.Unlock()
+	if chain.tipChanged == nil {
+		chain.tipChanged = make(chan struct{})
 	}
+	return chain.tipChanged
+}
 
+func (chain *BlockChain) setTip(hash []byte) {
+	chain.tipMu.Lock()
+	defer chain.tipMu.Unlock()
+	chain.LastHash = hash
+	if chain.tipChanged != nil {
+		close(chain.tipChanged)
+		chain.tipChanged = nil
+	}
+}
+
+// MineBlock mines the transactions on top of the current tip. Mining is
+// abandoned with ErrStaleTip as soon as the tip changes, and with the context
+// error when ctx is done.
+func (chain *BlockChain) MineBlock(ctx context.Context, transactions []*Transaction) (*Block, error) {
+	var lastHash []byte
+	var lastBlock *Block
+
+	tipChanged := chain.TipChanged()
 	err := chain.Database.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("lh"))
-		Handle(err)
-		err = item.Value(func(val []byte) error {
-			lastHash = val
-			return nil
-		})
-		item, err = txn.Get(lastHash)
-		var lastBlockData []byte
-		_ = item.Value(func(val []byte) error {
-			lastBlockData = val
-			return nil
-		})
-		lastBlock := Deserialize(lastBlockData)
-		lastHeight = lastBlock.Height
+		var err error
+		if lastHash, err = getTip(txn); err != nil {
+			return err
+		}
+		if lastBlock, err = getBlock(txn, lastHash); err != nil {
+			return err
+		}
 
+		if err := checkTransactionList(transactions, chain.Params.MaxSupply); err != nil {
+			return err
+		}
+		fees, err := chain.checkTransactions(txn, lastBlock, transactions)
+		if err != nil {
+			return err
+		}
+		header := &BlockHeader{PrevHash: lastHash, Height: lastBlock.Height + 1}
+		_, _, err = chain.checkSubsidy(txn, header, transactions[0], fees)
 		return err
 	})
-	Handle(err)
-	newBlock := CreateBlock(transactions, lastHash, lastHeight+1)
+	if err != nil {
+		return nil, err
+	}
+	difficulty, err := chain.NextDifficulty(lastBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	mineCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-tipChanged:
+			cancel()
+		case <-mineCtx.Done():
+		}
+	}()
+	newBlock, err := CreateBlock(mineCtx, chain.Engine, transactions, lastHash, lastBlock.Height+1, difficulty)
+	if err != nil {
+		if mineCtx.Err() != nil && ctx.Err() == nil {
+			return nil, ErrStaleTip
+		}
+		return nil, err
+	}
 
 	err = chain.Database.Update(func(txn *badger.Txn) error {
-		err := txn.Set(newBlock.Hash, newBlock.Serialize())
-		Handle(err)
-		err = txn.Set([]byte("lh"), newBlock.Hash)
-		chain.LastHash = newBlock.Hash
-		return err
+		tipHash, err := getTip(txn)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(tipHash, lastHash) {
+			return ErrStaleTip
+		}
+		if _, err := chain.storeBlock(txn, newBlock); err != nil {
+			return err
+		}
+		if err := chain.connectBlock(txn, newBlock); err != nil {
+			return err
+		}
+		return txn.Set([]byte("lh"), newBlock.Hash)
 	})
-	Handle(err)
-	return newBlock
+	if err != nil {
+		return nil, err
+	}
+	chain.setTip(newBlock.Hash)
+	return newBlock, nil
 }
-func (chain *BlockChain) AddBlock(block *Block) {
-	var lastHash []byte
+
+// AddBlock stores a block received from a peer. The block becomes the new
+// tip when its branch carries more cumulative work than the active chain,
+// which may reorganize the chain. The returned Reorg lists the blocks that
+// were disconnected and connected, so that the mempool can follow.
+func (chain *BlockChain) AddBlock(block *Block) (*Reorg, error) {
+	var newTip []byte
+	reorg := &Reorg{}
 	err := chain.Database.Update(func(txn *badger.Txn) error {
 		if _, err := txn.Get(block.Hash); err == nil {
 			return nil
 		}
-		blockData := block.Serialize()
-		err := txn.Set(block.Hash, blockData)
-		Handle(err)
-		item, err := txn.Get([]byte("lh"))
-		Handle(err)
-		_ = item.Value(func(val []byte) error {
-			lastHash = val
-			return nil
-		})
-		item, err = txn.Get(lastHash)
-		Handle(err)
-		var lastBlockData []byte
-		_ = item.Value(func(val []byte) error {
-			lastBlockData = val
+		if err := chain.checkBlock(txn, block); err != nil {
+			return err
+		}
+		work, err := chain.storeBlock(txn, block)
+		if err != nil {
+			return err
+		}
+		tipHash, err := getTip(txn)
+		if err != nil {
+			return err
+		}
+		tipMeta, err := getBlockMeta(txn, tipHash)
+		if err != nil {
+			return err
+		}
+		if work.Cmp(tipMeta.Work) <= 0 {
 			return nil
-		})
-		lastBlock := Deserialize(lastBlockData)
-		if block.Height > lastBlock.Height {
-			err = txn.Set([]byte("lh"), block.Hash)
-			Handle(err)
-			chain.LastHash = block.Hash
 		}
+		reorg, err = chain.reorganize(txn, tipHash, block)
+		if err != nil {
+			return err
+		}
+		newTip = block.Hash
 		return nil
 	})
-	Handle(err)
+	if err != nil {
+		return nil, err
+	}
+	if newTip != nil {
+		chain.setTip(newTip)
+	}
+	return reorg, nil
 }
 
 func (chain *BlockChain) GetBlock(blockHash []byte) (Block, error) {
@@ -120,6 +196,12 @@ func (chain *BlockChain) GetBlock(blockHash []byte) (Block, error) {
 	return block, nil
 }
 
+// NextDifficulty returns the difficulty the consensus engine requires of the
+// block built on top of last.
+func (chain *BlockChain) NextDifficulty(last *Block) (int, error) {
+	return chain.Engine.CalcDifficulty(chain, last)
+}
+
 func (chain *BlockChain) GetBlockHashes() [][]byte {
 	var blocks [][]byte
 
@@ -189,15 +271,14 @@ func openDB(dir string, opts badger.Options) (*badger.DB, error) {
 	}
 }
 func DeserializeTransaction(data []byte) Transaction {
-	var transaction Transaction
-
-	decoder := gob.NewDecoder(bytes.NewReader(data))
-	err := decoder.Decode(&transaction)
+	transaction, err := decodeTransaction(data)
 	Handle(err)
-	return transaction
+	return *transaction
 }
-func InitBlockChain(address, nodeId string) *BlockChain {
-	var lastHash []byte
+
+// InitBlockChain creates the chain described by params. When params has no
+// genesis allocations the block reward of the genesis block goes to address.
+func InitBlockChain(address, nodeId string, params *ChainParams) *BlockChain {
 	path := fmt.Sprintf(dbPath, nodeId)
 
 	if DBexists(path) {
@@ -205,25 +286,45 @@ func InitBlockChain(address, nodeId string) *BlockChain {
 		runtime.Goexit()
 	}
 
+	if len(params.Allocations) == 0 {
+		params.Allocations = []GenesisAllocation{{Address: address, Amount: params.Subsidy(0)}}
+	}
+	if params.GenesisTimestamp == 0 {
+		params.GenesisTimestamp = time.Now().Unix()
+	}
+	blockchain := createBlockChain(path, params)
+	Handle(params.Save(nodeId))
+	return blockchain
+}
+
+// createBlockChain stores the genesis block of params in a new database at
+// path.
+func createBlockChain(path string, params *ChainParams) *BlockChain {
+	var lastHash []byte
 	opts := badger.DefaultOptions(path)
 	opts.Dir = path
 	opts.ValueDir = path
 
+	Handle(params.Validate())
+	engine, err := NewConsensusEngine(params)
+	Handle(err)
 	db, err := openDB(path, opts)
 	Handle(err)
 
+	blockchain := BlockChain{Database: db, Engine: engine, Params: params}
 	err = db.Update(func(txn *badger.Txn) error {
-		cbtx := CoinBaseTx(address, genesisData)
-		genesis := Genesis(cbtx)
+		genesis := Genesis(engine, params)
 		fmt.Println("Genesis created")
-		err = txn.Set(genesis.Hash, genesis.Serialize())
+		_, err := blockchain.storeBlock(txn, genesis)
+		Handle(err)
+		err = blockchain.connectBlock(txn, genesis)
 		Handle(err)
 		err = txn.Set([]byte("lh"), genesis.Hash)
 		lastHash = genesis.Hash
 		return err
 	})
 	Handle(err)
-	blockchain := BlockChain{lastHash, db}
+	blockchain.LastHash = lastHash
 	return &blockchain
 }
 
@@ -237,20 +338,19 @@ func ContinueBlockChain(nodeId string) *BlockChain {
 	opts := badger.DefaultOptions(path)
 	opts.Dir = path
 	opts.ValueDir = path
+	params, err := LoadChainParams(nodeId)
+	Handle(err)
 	db, err := openDB(path, opts)
 	Handle(err)
 
-	err = db.Update(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("lh"))
-		Handle(err)
-		err = item.Value(func(val []byte) error {
-			lastHash = val
-			return nil
-		})
+	err = db.View(func(txn *badger.Txn) error {
+		lastHash, err = getTip(txn)
 		return err
 	})
 	Handle(err)
-	chain := BlockChain{lastHash, db}
+	engine, err := NewConsensusEngine(params)
+	Handle(err)
+	chain := BlockChain{LastHash: lastHash, Database: db, Engine: engine, Params: params}
 
 	return &chain
 }
@@ -268,6 +368,9 @@ func (chain *BlockChain) FindUTXO() map[string]TxOutputs {
 
 		Outputs:
 			for outIdx, out := range tx.Outputs {
+				if out.LockingScript.IsUnspendable() {
+					continue
+				}
 				if spentTXOs[txID] != nil {
 					for _, spentOut := range spentTXOs[txID] {
 						if spentOut == outIdx {
@@ -276,7 +379,10 @@ func (chain *BlockChain) FindUTXO() map[string]TxOutputs {
 					}
 				}
 				outs := UTXO[txID]
-				outs.Outputs = append(outs.Outputs, out)
+				if outs.Outputs == nil {
+					outs = TxOutputs{make(map[int]TxOutput), block.Height, block.Timestamp, tx.IsCoinbase()}
+				}
+				outs.Outputs[outIdx] = out
 				UTXO[txID] = outs
 			}
 			if tx.IsCoinbase() == false {
@@ -312,28 +418,63 @@ func (bc *BlockChain) FindTransaction(ID []byte) (Transaction, error) {
 	return Transaction{}, errors.New("Transaction does not exist")
 }
 
-func (bc *BlockChain) SignTransaction(tx *Transaction, priKey ecdsa.PrivateKey) {
-	prevTXs := make(map[string]Transaction)
-
-	for _, in := range tx.Inputs {
-		prevTX, err := bc.FindTransaction(in.ID)
-		Handle(err)
-		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
-	}
+// prevOutputs looks up the outputs spent by tx in the UTXO set.
+func (bc *BlockChain) prevOutputs(tx *Transaction) (map[string]TxOutputs, error) {
+	prevTXs := make(map[string]TxOutputs)
+	UTXO := UTXOSet{bc}
+	err := bc.Database.View(func(txn *badger.Txn) error {
+		for _, in := range tx.Inputs {
+			outs, err := UTXO.getOutputs(txn, in.ID)
+			if err != nil {
+				return err
+			}
+			if _, ok := outs.Outputs[in.Out]; !ok {
+				return fmt.Errorf("Output %x:%d is not in the UTXO set", in.ID, in.Out)
+			}
+			prevTXs[hex.EncodeToString(in.ID)] = outs
+		}
+		return nil
+	})
+	return prevTXs, err
+}
 
-	tx.Sign(priKey, prevTXs)
+func (bc *BlockChain) SignTransaction(tx *Transaction, priKey crypto.Signer) {
+	prevTXs, err := bc.prevOutputs(tx)
+	Handle(err)
+	tx.Sign(priKey, prevTXs, SigHashAll)
 }
 
+// VerifyTransaction reports whether tx could be included in the next block:
+// it must be well formed, spend only unspent outputs and carry valid
+// signatures.
 func (bc *BlockChain) VerifyTransaction(tx *Transaction) bool {
 	if tx.IsCoinbase() {
 		return true
 	}
-	prevTXs := make(map[string]Transaction)
+	prevTXs, err := bc.prevOutputs(tx)
+	if err != nil {
+		return false
+	}
+	return bc.verifyTransaction(tx, prevTXs) == nil
+}
 
-	for _, in := range tx.Inputs {
-		prevTX, err := bc.FindTransaction(in.ID)
-		Handle(err)
-		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
+// verifyTransaction checks that tx could be included in the next block,
+// given the outputs it spends in prevTXs.
+func (bc *BlockChain) verifyTransaction(tx *Transaction, prevTXs map[string]TxOutputs) error {
+	if err := checkTransaction(tx, bc.Params.MaxSupply); err != nil {
+		return err
+	}
+	if err := bc.checkDataOutputs(tx); err != nil {
+		return err
+	}
+	if err := bc.CheckFinal(tx); err != nil {
+		return err
+	}
+	if err := bc.checkCoinbaseMaturity(tx, prevTXs, bc.GetBestHeight()+1); err != nil {
+		return err
+	}
+	if err := tx.VerifyScripts(prevTXs); err != nil {
+		return fmt.Errorf("%w: %x: %s", ErrInvalidTransaction, tx.ID, err)
 	}
-	return tx.Verify(prevTXs)
+	return nil
 }
@@ -0,0 +1,181 @@
+package blockchain
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// bnbMaxTries bounds the number of branches BranchAndBound explores.
+const bnbMaxTries = 100000
+
+// SpendableOutput is an unspent output a transaction may spend.
+type SpendableOutput struct {
+	TxID  []byte
+	Out   int
+	Value int
+}
+
+// CoinSelector picks the outputs a transaction spends to pay target.
+type CoinSelector interface {
+	// SelectCoins returns outputs of utxos holding at least target
+	// together, or ErrNotEnoughFunds when all of them hold less.
+	SelectCoins(utxos []SpendableOutput, target int) ([]SpendableOutput, error)
+}
+
+// ParseCoinSelector returns the strategy called name: largest, smallest,
+// bnb or random.
+func ParseCoinSelector(name string) (CoinSelector, error) {
+	switch name {
+	case "largest":
+		return LargestFirst{}, nil
+	case "smallest":
+		return SmallestFirst{}, nil
+	case "bnb":
+		return BranchAndBound{}, nil
+	case "random":
+		return RandomImprove{}, nil
+	}
+	return nil, fmt.Errorf("Unknown coin selection %q, want largest, smallest, bnb or random", name)
+}
+
+// sortedByValue returns a copy of utxos sorted by value, largest first when
+// descending. Equal values keep their order.
+func sortedByValue(utxos []SpendableOutput, descending bool) []SpendableOutput {
+	sorted := append([]SpendableOutput{}, utxos...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if descending {
+			return sorted[i].Value > sorted[j].Value
+		}
+		return sorted[i].Value < sorted[j].Value
+	})
+	return sorted
+}
+
+// accumulate takes outputs from utxos in order until they hold target.
+func accumulate(utxos []SpendableOutput, target int) ([]SpendableOutput, error) {
+	var selected []SpendableOutput
+	sum := 0
+	for _, u := range utxos {
+		if sum >= target {
+			break
+		}
+		selected = append(selected, u)
+		sum += u.Value
+	}
+	if sum < target {
+		return nil, ErrNotEnoughFunds
+	}
+	return selected, nil
+}
+
+// LargestFirst spends the largest outputs first, which keeps the number of
+// inputs and so the transaction small.
+type LargestFirst struct{}
+
+func (LargestFirst) SelectCoins(utxos []SpendableOutput, target int) ([]SpendableOutput, error) {
+	return accumulate(sortedByValue(utxos, true), target)
+}
+
+// SmallestFirst spends the smallest outputs first, consolidating the wallet
+// at the cost of larger transactions.
+type SmallestFirst struct{}
+
+func (SmallestFirst) SelectCoins(utxos []SpendableOutput, target int) ([]SpendableOutput, error) {
+	return accumulate(sortedByValue(utxos, false), target)
+}
+
+// BranchAndBound searches for outputs holding exactly target, so that the
+// transaction needs no change output. When there are none, or the search
+// gives up, it uses Fallback, LargestFirst when nil.
+type BranchAndBound struct {
+	Fallback CoinSelector
+}
+
+func (s BranchAndBound) SelectCoins(utxos []SpendableOutput, target int) ([]SpendableOutput, error) {
+	sorted := sortedByValue(utxos, true)
+	// remaining[i] is what the outputs from i on hold together
+	remaining := make([]int, len(sorted)+1)
+	for i := len(sorted) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + sorted[i].Value
+	}
+	if remaining[0] < target {
+		return nil, ErrNotEnoughFunds
+	}
+
+	tries := 0
+	var picked []int
+	var search func(i, sum int) bool
+	search = func(i, sum int) bool {
+		tries++
+		switch {
+		case sum == target:
+			return true
+		case sum > target || sum+remaining[i] < target || i == len(sorted) || tries > bnbMaxTries:
+			return false
+		}
+		picked = append(picked, i)
+		if search(i+1, sum+sorted[i].Value) {
+			return true
+		}
+		picked = picked[:len(picked)-1]
+		return search(i+1, sum)
+	}
+	if search(0, 0) {
+		selected := make([]SpendableOutput, len(picked))
+		for n, i := range picked {
+			selected[n] = sorted[i]
+		}
+		return selected, nil
+	}
+
+	fallback := s.Fallback
+	if fallback == nil {
+		fallback = LargestFirst{}
+	}
+	return fallback.SelectCoins(utxos, target)
+}
+
+// RandomImprove picks outputs at random until they hold target, then keeps
+// adding random outputs while that brings the change closer to target
+// without the selection exceeding three times target. Change of about the
+// size of the payment leaves the wallet with outputs fit for similar
+// payments later. Rand is seeded from the clock when nil.
+type RandomImprove struct {
+	Rand *rand.Rand
+}
+
+func (s RandomImprove) SelectCoins(utxos []SpendableOutput, target int) ([]SpendableOutput, error) {
+	r := s.Rand
+	if r == nil {
+		r = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	order := r.Perm(len(utxos))
+	var selected []SpendableOutput
+	sum := 0
+	i := 0
+	for ; i < len(order) && sum < target; i++ {
+		selected = append(selected, utxos[order[i]])
+		sum += utxos[order[i]].Value
+	}
+	if sum < target {
+		return nil, ErrNotEnoughFunds
+	}
+
+	ideal := 2 * target
+	distance := func(n int) int {
+		if n > ideal {
+			return n - ideal
+		}
+		return ideal - n
+	}
+	for ; i < len(order); i++ {
+		u := utxos[order[i]]
+		if next := sum + u.Value; next <= 3*target && distance(next) < distance(sum) {
+			selected = append(selected, u)
+			sum = next
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,118 @@
+package blockchain
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func testOutputs(values ...int) []SpendableOutput {
+	var utxos []SpendableOutput
+	for i, value := range values {
+		utxos = append(utxos, SpendableOutput{TxID: []byte{byte(i)}, Out: i, Value: value})
+	}
+	return utxos
+}
+
+func values(utxos []SpendableOutput) []int {
+	var v []int
+	for _, u := range utxos {
+		v = append(v, u.Value)
+	}
+	return v
+}
+
+func TestSelectCoins(t *testing.T) {
+	utxos := testOutputs(5, 1, 8, 3, 2)
+	tests := []struct {
+		name     string
+		selector CoinSelector
+		utxos    []SpendableOutput
+		target   int
+		want     []int
+		err      error
+	}{
+		{"largest", LargestFirst{}, utxos, 9, []int{8, 5}, nil},
+		{"largest exact", LargestFirst{}, utxos, 8, []int{8}, nil},
+		{"largest all", LargestFirst{}, utxos, 19, []int{8, 5, 3, 2, 1}, nil},
+		{"largest short", LargestFirst{}, utxos, 20, nil, ErrNotEnoughFunds},
+		{"largest none", LargestFirst{}, nil, 1, nil, ErrNotEnoughFunds},
+		{"smallest", SmallestFirst{}, utxos, 4, []int{1, 2, 3}, nil},
+		{"smallest all", SmallestFirst{}, utxos, 19, []int{1, 2, 3, 5, 8}, nil},
+		{"smallest short", SmallestFirst{}, utxos, 20, nil, ErrNotEnoughFunds},
+		{"bnb exact pair", BranchAndBound{}, utxos, 11, []int{8, 3}, nil},
+		{"bnb exact three", BranchAndBound{}, utxos, 14, []int{8, 5, 1}, nil},
+		{"bnb skips the largest", BranchAndBound{}, utxos, 4, []int{3, 1}, nil},
+		{"bnb fallback", BranchAndBound{}, testOutputs(10, 7), 9, []int{10}, nil},
+		{"bnb custom fallback", BranchAndBound{Fallback: SmallestFirst{}}, testOutputs(10, 7), 9, []int{7, 10}, nil},
+		{"bnb short", BranchAndBound{}, utxos, 20, nil, ErrNotEnoughFunds},
+		{"random short", RandomImprove{Rand: rand.New(rand.NewSource(1))}, utxos, 20, nil, ErrNotEnoughFunds},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			selected, err := test.selector.SelectCoins(test.utxos, test.target)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("SelectCoins(%d) error %v, want %v", test.target, err, test.err)
+			}
+			if got := values(selected); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("SelectCoins(%d) = %v, want %v", test.target, got, test.want)
+			}
+		})
+	}
+}
+
+func TestRandomImprove(t *testing.T) {
+	utxos := testOutputs(1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 20, 40)
+	largest := 40
+	for seed := int64(0); seed < 50; seed++ {
+		for _, target := range []int{1, 7, 15, 30} {
+			s := RandomImprove{Rand: rand.New(rand.NewSource(seed))}
+			selected, err := s.SelectCoins(utxos, target)
+			if err != nil {
+				t.Fatalf("seed %d target %d: %v", seed, target, err)
+			}
+			sum := 0
+			seen := make(map[int]bool)
+			for _, u := range selected {
+				if seen[u.Out] {
+					t.Fatalf("seed %d target %d: output %d selected twice", seed, target, u.Out)
+				}
+				seen[u.Out] = true
+				sum += u.Value
+			}
+			if sum < target {
+				t.Errorf("seed %d target %d: selected %d", seed, target, sum)
+			}
+			// the random first pass overshoots the target by less than the
+			// largest output and improving never goes past three times it
+			if sum > 3*target && sum-target >= largest {
+				t.Errorf("seed %d target %d: selected %d", seed, target, sum)
+			}
+
+			again, _ := RandomImprove{Rand: rand.New(rand.NewSource(seed))}.SelectCoins(utxos, target)
+			if !reflect.DeepEqual(again, selected) {
+				t.Errorf("seed %d target %d: selection is not reproducible", seed, target)
+			}
+		}
+	}
+}
+
+func TestParseCoinSelector(t *testing.T) {
+	tests := []struct {
+		name string
+		want CoinSelector
+	}{
+		{"largest", LargestFirst{}},
+		{"smallest", SmallestFirst{}},
+		{"bnb", BranchAndBound{}},
+		{"random", RandomImprove{}},
+		{"oldest", nil},
+	}
+	for _, test := range tests {
+		got, err := ParseCoinSelector(test.name)
+		if (err != nil) != (test.want == nil) || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseCoinSelector(%q) = %#v, %v", test.name, got, err)
+		}
+	}
+}
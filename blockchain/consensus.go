@@ -0,0 +1,110 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ConsensusEngine decides how blocks are sealed and what makes a header
+// valid. The chain stores which engine it was created with.
+type ConsensusEngine interface {
+	// Seal finalizes the block header, setting Block.Hash on success.
+	Seal(ctx context.Context, block *Block) error
+	// VerifyHeader checks the seal of a header that follows the rules of
+	// CalcDifficulty.
+	VerifyHeader(header *BlockHeader) error
+	// CalcDifficulty returns the difficulty required of the block built on
+	// top of parent.
+	CalcDifficulty(chain *BlockChain, parent *Block) (int, error)
+}
+
+// ConsensusConfig selects the engine of a chain. Engine is either "pow" or
+// "poa"; Validators lists the addresses allowed to sign blocks under "poa".
+type ConsensusConfig struct {
+	Engine     string   `json:"engine"`
+	Validators []string `json:"validators,omitempty"`
+}
+
+func NewConsensusEngine(params *ChainParams) (ConsensusEngine, error) {
+	switch params.Consensus.Engine {
+	case "", "pow":
+		return &ProofOfWorkEngine{Params: params}, nil
+	case "poa":
+		return NewProofOfAuthority(params.Consensus.Validators)
+	}
+	return nil, fmt.Errorf("Unknown consensus engine %q", params.Consensus.Engine)
+}
+
+// ProofOfWorkEngine seals blocks by searching for a nonce with Workers
+// goroutines, MinerWorkers when zero, and retargets the difficulty every
+// Params.RetargetInterval blocks. It may seal several blocks at once.
+type ProofOfWorkEngine struct {
+	Params  *ChainParams
+	Workers int
+
+	mu   sync.Mutex
+	last ProofOfWork
+}
+
+func (e *ProofOfWorkEngine) Seal(ctx context.Context, block *Block) error {
+	workers := e.Workers
+	if workers == 0 {
+		workers = MinerWorkers
+	}
+	var last ProofOfWork
+	defer func() {
+		e.mu.Lock()
+		e.last = last
+		e.mu.Unlock()
+	}()
+	for {
+		pow := NewProof(&block.BlockHeader)
+		nonce, hash, err := pow.Run(ctx, workers)
+		last.Header, last.Target = pow.Header, pow.Target
+		last.Hashes += pow.Hashes
+		last.Elapsed += pow.Elapsed
+		if errors.Is(err, ErrNonceExhausted) {
+			block.IncrementExtraNonce()
+			continue
+		} else if err != nil {
+			return err
+		}
+		block.Nonce = nonce
+		block.Hash = hash
+		return nil
+	}
+}
+
+// LastSeal returns the search of the call to Seal that finished last, its
+// Hashes and Elapsed summed over every extra nonce tried.
+func (e *ProofOfWorkEngine) LastSeal() ProofOfWork {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.last
+}
+
+func (e *ProofOfWorkEngine) VerifyHeader(header *BlockHeader) error {
+	if !NewProof(header).Validate() {
+		return ErrInvalidPoW
+	}
+	return nil
+}
+
+func (e *ProofOfWorkEngine) CalcDifficulty(chain *BlockChain, parent *Block) (int, error) {
+	height := parent.Height + 1
+	interval := e.Params.RetargetInterval
+	if interval < 2 || height%interval != 0 {
+		return parent.Difficulty, nil
+	}
+	first := *parent
+	for first.Height > height-interval {
+		prev, err := chain.GetBlock(first.PrevHash)
+		if err != nil {
+			return 0, err
+		}
+		first = prev
+	}
+	return e.Params.RetargetDifficulty(&first, parent), nil
+}
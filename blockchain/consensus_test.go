@@ -0,0 +1,123 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/leetcode-golang-classroom/golang-blockchain/wallet"
+)
+
+// TestSealConcurrently seals several blocks with one engine at once, as
+// connections of a node may, while the stats are read.
+func TestSealConcurrently(t *testing.T) {
+	engine := &ProofOfWorkEngine{Params: testParams(), Workers: 2}
+	var wg sync.WaitGroup
+	blocks := make([]*Block, 8)
+	for i := range blocks {
+		blocks[i] = testBlock()
+		blocks[i].Difficulty = 8
+		blocks[i].Nonce = 0
+		wg.Add(1)
+		go func(block *Block) {
+			defer wg.Done()
+			if err := engine.Seal(context.Background(), block); err != nil {
+				t.Error(err)
+			}
+			engine.LastSeal()
+		}(blocks[i])
+	}
+	wg.Wait()
+	for i, block := range blocks {
+		if !bytes.Equal(block.Hash, block.BlockHeader.Hash()) {
+			t.Errorf("block %d: hash %x does not match its header", i, block.Hash)
+		}
+		if err := engine.VerifyHeader(&block.BlockHeader); err != nil {
+			t.Errorf("block %d: %v", i, err)
+		}
+	}
+	if last := engine.LastSeal(); last.Hashes == 0 || last.Target == nil {
+		t.Errorf("LastSeal() = %+v, want the stats of a finished search", last)
+	}
+}
+
+func TestRunCancel(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		want    error
+	}{
+		{"cancelled before", 0, context.DeadlineExceeded},
+		{"cancelled while running", 50 * time.Millisecond, context.DeadlineExceeded},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// no hash meets a target of 1
+			pow := NewProof(&BlockHeader{PrevHash: []byte{1}, Difficulty: 255})
+			ctx, cancel := context.WithTimeout(context.Background(), test.timeout)
+			defer cancel()
+			start := time.Now()
+			if _, _, err := pow.Run(ctx, 2); !errors.Is(err, test.want) {
+				t.Errorf("Run() error %v, want %v", err, test.want)
+			}
+			if elapsed := time.Since(start); elapsed > test.timeout+time.Second {
+				t.Errorf("Run() returned %s after the context was done", elapsed-test.timeout)
+			}
+		})
+	}
+}
+
+// unsolvable is a proof of work engine requiring a difficulty no block
+// reaches, so that mining only ends when it is stopped.
+type unsolvable struct {
+	*ProofOfWorkEngine
+}
+
+func (unsolvable) CalcDifficulty(chain *BlockChain, parent *Block) (int, error) {
+	return 255, nil
+}
+
+func TestMineBlockStops(t *testing.T) {
+	w := wallet.MakeWallet()
+	chain, _ := newTestChain(t, w, 100)
+	chain.Engine = unsolvable{&ProofOfWorkEngine{Params: chain.Params, Workers: 1}}
+	coinbase := CoinBaseTx(string(w.Address()), "tag", 0)
+
+	tests := []struct {
+		name string
+		stop func(cancel context.CancelFunc)
+		want error
+	}{
+		{"context cancelled", func(cancel context.CancelFunc) { cancel() }, context.Canceled},
+		// the tip is set again until mining has subscribed to tip changes
+		{"tip changed", func(context.CancelFunc) { chain.setTip(chain.LastHash) }, ErrStaleTip},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			done := make(chan error, 1)
+			go func() {
+				_, err := chain.MineBlock(ctx, []*Transaction{coinbase})
+				done <- err
+			}()
+			timeout := time.After(5 * time.Second)
+			for {
+				select {
+				case err := <-done:
+					if !errors.Is(err, test.want) {
+						t.Errorf("MineBlock() error %v, want %v", err, test.want)
+					}
+					return
+				case <-timeout:
+					t.Fatal("mining did not stop")
+				case <-time.After(10 * time.Millisecond):
+					test.stop(cancel)
+				}
+			}
+		})
+	}
+}
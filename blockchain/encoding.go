@@ -0,0 +1,481 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+)
+
+// EncodingVersion is written first in every encoded block, block header and
+// transaction.
+//
+// A transaction is encoded as
+//
+//	version                 uvarint
+//	input count             uvarint
+//	  previous tx ID        uvarint length, bytes
+//	  previous output       varint, -1 for the coinbase
+//	  unlocking script      uvarint length, bytes
+//	  sequence              uint32 little-endian
+//	output count            uvarint
+//	  value                 int64 little-endian
+//	  locking script        uvarint length, bytes
+//	lock time               int64 little-endian
+//
+// a block header as
+//
+//	version                 uvarint
+//	previous block hash     uvarint length, bytes
+//	merkle root             uvarint length, bytes
+//	timestamp               int64 little-endian
+//	height                  uvarint
+//	difficulty              uvarint
+//	nonce                   uvarint
+//	signer                  uvarint length, bytes
+//	signature               uvarint length, bytes
+//
+// and a block as its version, the serialized header and its transactions,
+// each one prefixed by its uvarint length. The transaction ID and the block
+// hash are derived from the data and not encoded.
+const EncodingVersion = 1
+
+var ErrEncoding = errors.New("Malformed encoding")
+
+func writeUvarint(w *bytes.Buffer, n uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	w.Write(buf[:binary.PutUvarint(buf[:], n)])
+}
+
+func writeVarint(w *bytes.Buffer, n int64) {
+	var buf [binary.MaxVarintLen64]byte
+	w.Write(buf[:binary.PutVarint(buf[:], n)])
+}
+
+func writeVarBytes(w *bytes.Buffer, data []byte) {
+	writeUvarint(w, uint64(len(data)))
+	w.Write(data)
+}
+
+func writeUint32(w *bytes.Buffer, n uint32) {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], n)
+	w.Write(buf[:])
+}
+
+func writeInt64(w *bytes.Buffer, n int64) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(n))
+	w.Write(buf[:])
+}
+
+func writeBool(w *bytes.Buffer, b bool) {
+	if b {
+		w.WriteByte(1)
+	} else {
+		w.WriteByte(0)
+	}
+}
+
+// decoder reads the fields written by the write functions. The first error
+// sticks, so a sequence of reads only needs to be checked once at the end.
+type decoder struct {
+	r   *bytes.Reader
+	err error
+}
+
+func newDecoder(data []byte) *decoder {
+	return &decoder{r: bytes.NewReader(data)}
+}
+
+func (d *decoder) fail(err error) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: %s", ErrEncoding, err)
+	}
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	n, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		d.fail(err)
+	}
+	return n
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	n, err := binary.ReadVarint(d.r)
+	if err != nil {
+		d.fail(err)
+	}
+	return n
+}
+
+// count reads a number of items or bytes that follow, each taking at least
+// one byte, so that a corrupt length cannot make us allocate more than the
+// data could hold.
+func (d *decoder) count() int {
+	n := d.uvarint()
+	if d.err == nil && n > uint64(d.r.Len()) {
+		d.fail(fmt.Errorf("length %d exceeds the remaining %d bytes", n, d.r.Len()))
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) varBytes() []byte {
+	n := d.count()
+	if d.err != nil || n == 0 {
+		return nil
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(d.r, data); err != nil {
+		d.fail(err)
+	}
+	return data
+}
+
+func (d *decoder) uint32() uint32 {
+	var buf [4]byte
+	if d.err == nil {
+		if _, err := io.ReadFull(d.r, buf[:]); err != nil {
+			d.fail(err)
+		}
+	}
+	return binary.LittleEndian.Uint32(buf[:])
+}
+
+func (d *decoder) int64() int64 {
+	var buf [8]byte
+	if d.err == nil {
+		if _, err := io.ReadFull(d.r, buf[:]); err != nil {
+			d.fail(err)
+		}
+	}
+	return int64(binary.LittleEndian.Uint64(buf[:]))
+}
+
+func (d *decoder) bool() bool {
+	if d.err != nil {
+		return false
+	}
+	b, err := d.r.ReadByte()
+	if err != nil {
+		d.fail(err)
+	} else if b > 1 {
+		d.fail(fmt.Errorf("bad boolean %d", b))
+	}
+	return b == 1
+}
+
+func (d *decoder) version() {
+	if v := d.uvarint(); d.err == nil && v != EncodingVersion {
+		d.fail(fmt.Errorf("unknown encoding version %d", v))
+	}
+}
+
+// finish returns the first error, or an error when data is left over.
+func (d *decoder) finish() error {
+	if d.err == nil && d.r.Len() != 0 {
+		d.fail(fmt.Errorf("%d trailing bytes", d.r.Len()))
+	}
+	return d.err
+}
+
+func (in *TxInput) encode(w *bytes.Buffer) {
+	writeVarBytes(w, in.ID)
+	writeVarint(w, int64(in.Out))
+	writeVarBytes(w, in.UnlockingScript)
+	writeUint32(w, in.Sequence)
+}
+
+func (d *decoder) input() TxInput {
+	var in TxInput
+	in.ID = d.varBytes()
+	in.Out = int(d.varint())
+	in.UnlockingScript = d.varBytes()
+	in.Sequence = d.uint32()
+	return in
+}
+
+func (out *TxOutput) encode(w *bytes.Buffer) {
+	writeInt64(w, int64(out.Value))
+	writeVarBytes(w, out.LockingScript)
+}
+
+func (d *decoder) output() TxOutput {
+	var out TxOutput
+	out.Value = int(d.int64())
+	out.LockingScript = d.varBytes()
+	return out
+}
+
+func (tx *Transaction) encode(w *bytes.Buffer) {
+	writeUvarint(w, EncodingVersion)
+	writeUvarint(w, uint64(len(tx.Inputs)))
+	for i := range tx.Inputs {
+		tx.Inputs[i].encode(w)
+	}
+	writeUvarint(w, uint64(len(tx.Outputs)))
+	for i := range tx.Outputs {
+		tx.Outputs[i].encode(w)
+	}
+	writeInt64(w, tx.LockTime)
+}
+
+func (d *decoder) transaction() Transaction {
+	var tx Transaction
+	d.version()
+	for n := d.count(); n > 0 && d.err == nil; n-- {
+		tx.Inputs = append(tx.Inputs, d.input())
+	}
+	for n := d.count(); n > 0 && d.err == nil; n-- {
+		tx.Outputs = append(tx.Outputs, d.output())
+	}
+	tx.LockTime = d.int64()
+	return tx
+}
+
+func (b *Block) encode(w *bytes.Buffer) {
+	writeUvarint(w, EncodingVersion)
+	writeVarBytes(w, b.BlockHeader.Serialize())
+	writeUvarint(w, uint64(len(b.Transactions)))
+	for _, tx := range b.Transactions {
+		writeVarBytes(w, tx.Serialize())
+	}
+}
+
+func (d *decoder) block() (*Block, error) {
+	var block Block
+	d.version()
+	header := d.varBytes()
+	var txs [][]byte
+	for n := d.count(); n > 0 && d.err == nil; n-- {
+		txs = append(txs, d.varBytes())
+	}
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	h, err := DeserializeHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	block.BlockHeader = *h
+	block.Hash = h.Hash()
+	for _, data := range txs {
+		tx, err := decodeTransaction(data)
+		if err != nil {
+			return nil, err
+		}
+		block.Transactions = append(block.Transactions, tx)
+	}
+	return &block, nil
+}
+
+// encode writes the version, the header of the unsealed block, the minimum
+// timestamp, the fees, the coinbase value and the transactions. Target is
+// left out since it follows from the difficulty.
+func (tmpl *BlockTemplate) encode(w *bytes.Buffer) {
+	header := BlockHeader{
+		PrevHash:   tmpl.PrevHash,
+		Timestamp:  tmpl.Timestamp,
+		Height:     tmpl.Height,
+		Difficulty: tmpl.Difficulty,
+	}
+	writeUvarint(w, EncodingVersion)
+	writeVarBytes(w, header.Serialize())
+	writeInt64(w, tmpl.MinTimestamp)
+	writeUvarint(w, uint64(tmpl.Fees))
+	writeUvarint(w, uint64(tmpl.CoinbaseValue))
+	writeUvarint(w, uint64(len(tmpl.Transactions)))
+	for _, tx := range tmpl.Transactions {
+		writeVarBytes(w, tx.Serialize())
+	}
+}
+
+func (d *decoder) template() (*BlockTemplate, error) {
+	var tmpl BlockTemplate
+	d.version()
+	header := d.varBytes()
+	tmpl.MinTimestamp = d.int64()
+	tmpl.Fees = int(d.uvarint())
+	tmpl.CoinbaseValue = int(d.uvarint())
+	var txs [][]byte
+	for n := d.count(); n > 0 && d.err == nil; n-- {
+		txs = append(txs, d.varBytes())
+	}
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	h, err := DeserializeHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	if h.Difficulty < 1 || h.Difficulty > MaxDifficulty {
+		return nil, fmt.Errorf("%w: template difficulty %d", ErrEncoding, h.Difficulty)
+	}
+	tmpl.PrevHash = h.PrevHash
+	tmpl.Timestamp = h.Timestamp
+	tmpl.Height = h.Height
+	tmpl.Difficulty = h.Difficulty
+	tmpl.Target = NewProof(h).Target
+	for _, data := range txs {
+		tx, err := decodeTransaction(data)
+		if err != nil {
+			return nil, err
+		}
+		tmpl.Transactions = append(tmpl.Transactions, tx)
+	}
+	return &tmpl, nil
+}
+
+func decodeTransaction(data []byte) (*Transaction, error) {
+	d := newDecoder(data)
+	tx := d.transaction()
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	tx.ID = tx.Hash()
+	return &tx, nil
+}
+
+func (outs *TxOutputs) encode(w *bytes.Buffer) {
+	indexes := outs.Indexes()
+	writeUvarint(w, uint64(len(indexes)))
+	for _, outIdx := range indexes {
+		out := outs.Outputs[outIdx]
+		writeUvarint(w, uint64(outIdx))
+		out.encode(w)
+	}
+	writeVarint(w, int64(outs.Height))
+	writeInt64(w, outs.Time)
+	writeBool(w, outs.Coinbase)
+}
+
+func (d *decoder) outputs() TxOutputs {
+	outs := TxOutputs{Outputs: make(map[int]TxOutput)}
+	for n := d.count(); n > 0 && d.err == nil; n-- {
+		outIdx := int(d.uvarint())
+		outs.Outputs[outIdx] = d.output()
+	}
+	outs.Height = int(d.varint())
+	outs.Time = d.int64()
+	outs.Coinbase = d.bool()
+	return outs
+}
+
+// encode writes the version, the transaction, the redeem script, the spent
+// outputs keyed by transaction ID and, for every input, the signatures keyed
+// by public key. Map keys are written in sorted order so that the same
+// MultiSigTx always gives the same file.
+func (mtx *MultiSigTx) encode(w *bytes.Buffer) {
+	writeUvarint(w, EncodingVersion)
+	writeVarBytes(w, mtx.Tx.Serialize())
+	writeVarBytes(w, mtx.RedeemScript)
+	writeUvarint(w, uint64(len(mtx.PrevTXs)))
+	for _, txid := range sortedKeys(mtx.PrevTXs) {
+		outs := mtx.PrevTXs[txid]
+		writeVarBytes(w, []byte(txid))
+		outs.encode(w)
+	}
+	writeUvarint(w, uint64(len(mtx.Signatures)))
+	for _, sigs := range mtx.Signatures {
+		writeUvarint(w, uint64(len(sigs)))
+		for _, pubKey := range sortedKeys(sigs) {
+			writeVarBytes(w, []byte(pubKey))
+			writeVarBytes(w, sigs[pubKey])
+		}
+	}
+}
+
+func (d *decoder) multiSigTx() *MultiSigTx {
+	mtx := MultiSigTx{PrevTXs: make(map[string]TxOutputs)}
+	d.version()
+	txData := d.varBytes()
+	mtx.RedeemScript = d.varBytes()
+	for n := d.count(); n > 0 && d.err == nil; n-- {
+		txid := string(d.varBytes())
+		mtx.PrevTXs[txid] = d.outputs()
+	}
+	for n := d.count(); n > 0 && d.err == nil; n-- {
+		sigs := make(map[string][]byte)
+		for m := d.count(); m > 0 && d.err == nil; m-- {
+			pubKey := string(d.varBytes())
+			sigs[pubKey] = d.varBytes()
+		}
+		mtx.Signatures = append(mtx.Signatures, sigs)
+	}
+	if d.err == nil {
+		tx, err := decodeTransaction(txData)
+		if err != nil {
+			d.err = err
+		} else {
+			mtx.Tx = *tx
+		}
+	}
+	if d.err == nil && len(mtx.Signatures) != len(mtx.Tx.Inputs) {
+		d.fail(fmt.Errorf("%d signature sets for %d inputs", len(mtx.Signatures), len(mtx.Tx.Inputs)))
+	}
+	return &mtx
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func encodeSpentOutputs(spent []SpentOutput) []byte {
+	var buff bytes.Buffer
+	writeUvarint(&buff, uint64(len(spent)))
+	for _, s := range spent {
+		writeVarBytes(&buff, s.ID)
+		writeVarint(&buff, int64(s.Out))
+		s.Output.encode(&buff)
+		writeVarint(&buff, int64(s.Height))
+		writeInt64(&buff, s.Time)
+		writeBool(&buff, s.Coinbase)
+	}
+	return buff.Bytes()
+}
+
+func decodeSpentOutputs(data []byte) ([]SpentOutput, error) {
+	var spent []SpentOutput
+	d := newDecoder(data)
+	for n := d.count(); n > 0 && d.err == nil; n-- {
+		var s SpentOutput
+		s.ID = d.varBytes()
+		s.Out = int(d.varint())
+		s.Output = d.output()
+		s.Height = int(d.varint())
+		s.Time = d.int64()
+		s.Coinbase = d.bool()
+		spent = append(spent, s)
+	}
+	return spent, d.finish()
+}
+
+func (meta *blockMeta) encode() []byte {
+	var buff bytes.Buffer
+	writeVarBytes(&buff, meta.Work.Bytes())
+	writeVarint(&buff, int64(meta.Supply))
+	return buff.Bytes()
+}
+
+func decodeBlockMeta(data []byte) (*blockMeta, error) {
+	d := newDecoder(data)
+	meta := &blockMeta{Work: new(big.Int).SetBytes(d.varBytes())}
+	meta.Supply = int(d.varint())
+	return meta, d.finish()
+}
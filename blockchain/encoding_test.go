@@ -0,0 +1,224 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/leetcode-golang-classroom/golang-blockchain/script"
+)
+
+func testTransaction() *Transaction {
+	tx := &Transaction{
+		Inputs: []TxInput{
+			{ID: bytes.Repeat([]byte{1}, 32), Out: 0, UnlockingScript: []byte{2, 3}, Sequence: 0xdeadbeef},
+			{ID: bytes.Repeat([]byte{4}, 32), Out: 300, Sequence: SequenceReplaceable},
+		},
+		Outputs: []TxOutput{
+			{Value: 5, LockingScript: script.PayToPubKeyHash(bytes.Repeat([]byte{5}, 20))},
+			{Value: 0, LockingScript: script.NullData([]byte("data"))},
+		},
+		LockTime: 1 << 40,
+	}
+	tx.ID = tx.Hash()
+	return tx
+}
+
+func testBlock() *Block {
+	block := &Block{
+		BlockHeader: BlockHeader{
+			PrevHash:   bytes.Repeat([]byte{6}, 32),
+			Timestamp:  1700000000,
+			Height:     1000,
+			Difficulty: 20,
+			Nonce:      123456789,
+			Signer:     bytes.Repeat([]byte{7}, 33),
+			Signature:  bytes.Repeat([]byte{8}, 64),
+		},
+		Transactions: []*Transaction{newCoinbase([]TxOutput{{Value: 20}}, 7, "tag"), testTransaction()},
+	}
+	block.MerkleRoot = block.HashTransactions()
+	block.Hash = block.BlockHeader.Hash()
+	return block
+}
+
+func TestEncodingRoundTrip(t *testing.T) {
+	tx := testTransaction()
+	block := testBlock()
+	outs := TxOutputs{map[int]TxOutput{0: tx.Outputs[0], 7: {Value: 9}}, 12, 1700000000, true}
+	spent := []SpentOutput{
+		{tx.ID, 0, tx.Outputs[0], 3, 1700000000, false},
+		{block.Transactions[0].ID, 1, TxOutput{Value: 20}, 4, 1700000600, true},
+	}
+	meta := &blockMeta{Work: new(big.Int).Lsh(big.NewInt(1), 100), Supply: 42}
+	tmpl := &BlockTemplate{
+		PrevHash:      block.PrevHash,
+		Height:        block.Height,
+		Difficulty:    block.Difficulty,
+		MinTimestamp:  1699999000,
+		Timestamp:     block.Timestamp,
+		Transactions:  []*Transaction{tx},
+		Fees:          3,
+		CoinbaseValue: 23,
+	}
+	mtx := &MultiSigTx{
+		Tx:           *tx,
+		RedeemScript: []byte{0x51, 0xae},
+		PrevTXs:      map[string]TxOutputs{"ab": outs, "cd": {Outputs: map[int]TxOutput{}}},
+		Signatures:   []map[string][]byte{{"k1": {1}, "k2": {2}}, {}},
+	}
+
+	tests := []struct {
+		name   string
+		encode func() []byte
+		decode func([]byte) ([]byte, error)
+	}{
+		{"transaction", tx.Serialize, func(data []byte) ([]byte, error) {
+			back, err := decodeTransaction(data)
+			if err != nil {
+				return nil, err
+			}
+			if !bytes.Equal(back.ID, tx.ID) {
+				t.Errorf("transaction ID %x, want %x", back.ID, tx.ID)
+			}
+			return back.Serialize(), nil
+		}},
+		{"header", block.BlockHeader.Serialize, func(data []byte) ([]byte, error) {
+			back, err := DeserializeHeader(data)
+			if err != nil {
+				return nil, err
+			}
+			return back.Serialize(), nil
+		}},
+		{"block", block.Serialize, func(data []byte) ([]byte, error) {
+			back, err := newDecoder(data).block()
+			if err != nil {
+				return nil, err
+			}
+			if !bytes.Equal(back.Hash, block.Hash) {
+				t.Errorf("block hash %x, want %x", back.Hash, block.Hash)
+			}
+			return back.Serialize(), nil
+		}},
+		{"outputs", outs.Serialize, func(data []byte) ([]byte, error) {
+			d := newDecoder(data)
+			back := d.outputs()
+			return back.Serialize(), d.finish()
+		}},
+		{"spent outputs", func() []byte { return encodeSpentOutputs(spent) }, func(data []byte) ([]byte, error) {
+			back, err := decodeSpentOutputs(data)
+			return encodeSpentOutputs(back), err
+		}},
+		{"block meta", meta.encode, func(data []byte) ([]byte, error) {
+			back, err := decodeBlockMeta(data)
+			if err != nil {
+				return nil, err
+			}
+			return back.encode(), nil
+		}},
+		{"template", tmpl.Serialize, func(data []byte) ([]byte, error) {
+			back, err := DeserializeBlockTemplate(data)
+			if err != nil {
+				return nil, err
+			}
+			if back.Target.Cmp(NewProof(&block.BlockHeader).Target) != 0 {
+				t.Errorf("template target %x is not derived from the difficulty", back.Target)
+			}
+			return back.Serialize(), nil
+		}},
+		{"multisig", mtx.Serialize, func(data []byte) ([]byte, error) {
+			back, err := DeserializeMultiSigTx(data)
+			if err != nil {
+				return nil, err
+			}
+			return back.Serialize(), nil
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := test.encode()
+			if again := test.encode(); !bytes.Equal(again, data) {
+				t.Fatalf("encoding is not deterministic:\n%x\n%x", data, again)
+			}
+			back, err := test.decode(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(back, data) {
+				t.Errorf("round trip changed the encoding:\n%x\n%x", data, back)
+			}
+		})
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	tx := testTransaction().Serialize()
+	block := testBlock().Serialize()
+	header := testBlock().BlockHeader.Serialize()
+	outs := TxOutputs{map[int]TxOutput{0: {Value: 1}}, 1, 1, true}.Serialize()
+	badBool := append(append([]byte{}, outs[:len(outs)-1]...), 2)
+	long := testBlock().BlockHeader
+	long.Signature = make([]byte, MaxHeaderFieldSize+1)
+	fewSigs := (&MultiSigTx{Tx: *testTransaction(), Signatures: []map[string][]byte{{}}}).Serialize()
+	manySigs := (&MultiSigTx{Tx: *testTransaction(), Signatures: []map[string][]byte{{}, {}, {}}}).Serialize()
+	hardTemplate := (&BlockTemplate{PrevHash: []byte{1}, Height: 1, Difficulty: MaxDifficulty + 1}).Serialize()
+
+	decodeTx := func(data []byte) error {
+		_, err := decodeTransaction(data)
+		return err
+	}
+	decodeBlock := func(data []byte) error {
+		_, err := newDecoder(data).block()
+		return err
+	}
+	decodeHeader := func(data []byte) error {
+		_, err := DeserializeHeader(data)
+		return err
+	}
+	decodeTemplate := func(data []byte) error {
+		_, err := DeserializeBlockTemplate(data)
+		return err
+	}
+	decodeMultiSig := func(data []byte) error {
+		_, err := DeserializeMultiSigTx(data)
+		return err
+	}
+	decodeOutputs := func(data []byte) error {
+		d := newDecoder(data)
+		d.outputs()
+		return d.finish()
+	}
+
+	tests := []struct {
+		name   string
+		decode func([]byte) error
+		data   []byte
+		want   error
+	}{
+		{"empty transaction", decodeTx, nil, ErrEncoding},
+		{"unknown version", decodeTx, append([]byte{EncodingVersion + 1}, tx[1:]...), ErrEncoding},
+		{"trailing byte", decodeTx, append(append([]byte{}, tx...), 0), ErrEncoding},
+		{"truncated transaction", decodeTx, tx[:len(tx)-1], ErrEncoding},
+		{"input count beyond data", decodeTx, []byte{EncodingVersion, 0xff, 0xff, 0xff, 0xff, 0x0f}, ErrEncoding},
+		{"overlong varint", decodeTx, append([]byte{EncodingVersion}, bytes.Repeat([]byte{0xff}, 11)...), ErrEncoding},
+		{"truncated block", decodeBlock, block[:len(block)/2], ErrEncoding},
+		{"block with trailing byte", decodeBlock, append(append([]byte{}, block...), 0), ErrEncoding},
+		{"empty header", decodeHeader, nil, ErrEncoding},
+		{"truncated header", decodeHeader, header[:len(header)-1], ErrEncoding},
+		{"header with trailing byte", decodeHeader, append(append([]byte{}, header...), 0), ErrEncoding},
+		{"header field too long", decodeHeader, long.Serialize(), ErrHeaderFieldTooLong},
+		{"template difficulty too high", decodeTemplate, hardTemplate, ErrEncoding},
+		{"fewer signature sets than inputs", decodeMultiSig, fewSigs, ErrEncoding},
+		{"more signature sets than inputs", decodeMultiSig, manySigs, ErrEncoding},
+		{"bad boolean", decodeOutputs, badBool, ErrEncoding},
+		{"outputs", decodeOutputs, outs, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.decode(test.data); !errors.Is(err, test.want) {
+				t.Errorf("decoding %x: got %v, want %v", test.data, err, test.want)
+			}
+		})
+	}
+}
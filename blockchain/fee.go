@@ -0,0 +1,15 @@
+package blockchain
+
+// Fee is what the sender of a transaction leaves to the miner: Absolute
+// coins plus PerByte coins for every byte of the serialized transaction.
+// The fee itself is implicit, it is whatever the inputs hold beyond the
+// outputs.
+type Fee struct {
+	Absolute int
+	PerByte  int
+}
+
+// Amount returns the fee of a transaction of the given size.
+func (f Fee) Amount(size int) int {
+	return f.Absolute + f.PerByte*size
+}
@@ -0,0 +1,130 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+
+	"github.com/leetcode-golang-classroom/golang-blockchain/wallet"
+)
+
+func TestFeeAmount(t *testing.T) {
+	tests := []struct {
+		fee  Fee
+		size int
+		want int
+	}{
+		{Fee{}, 250, 0},
+		{Fee{Absolute: 3}, 250, 3},
+		{Fee{PerByte: 2}, 250, 500},
+		{Fee{Absolute: 3, PerByte: 2}, 250, 503},
+		{Fee{Absolute: 3, PerByte: 2}, 0, 3},
+	}
+	for _, test := range tests {
+		if got := test.fee.Amount(test.size); got != test.want {
+			t.Errorf("%+v.Amount(%d) = %d, want %d", test.fee, test.size, got, test.want)
+		}
+	}
+}
+
+// TestNewTransactonFee checks that the transaction leaves exactly the fee
+// charged on its own signed size.
+func TestNewTransactonFee(t *testing.T) {
+	w := wallet.MakeWallet()
+	to := wallet.MakeWallet()
+	chain, _ := newTestChain(t, w, 1000, 1000)
+	UTXO := UTXOSet{chain}
+
+	tests := []struct {
+		name   string
+		amount int
+		fee    Fee
+	}{
+		{"no fee", 10, Fee{}},
+		{"absolute", 10, Fee{Absolute: 5}},
+		{"per byte", 10, Fee{PerByte: 1}},
+		{"both", 10, Fee{Absolute: 5, PerByte: 2}},
+		{"per byte needs a second input", 990, Fee{PerByte: 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx := NewTransacton(w, string(to.Address()), test.amount, nil, test.fee, false, LargestFirst{}, &UTXO)
+			paid, err := chain.transactionFee(tx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := test.fee.Amount(len(tx.Serialize())); paid != want {
+				t.Errorf("fee %d, want %d", paid, want)
+			}
+			if tx.Outputs[0].Value != test.amount {
+				t.Errorf("payment %d, want %d", tx.Outputs[0].Value, test.amount)
+			}
+		})
+	}
+}
+
+// TestFeeOverflow spends a 1 coin output into outputs whose sum wraps
+// around to 0, which must not pass as a fee of 1.
+func TestFeeOverflow(t *testing.T) {
+	w := wallet.MakeWallet()
+	chain, genesis := newTestChain(t, w, 1, 100)
+	address := string(w.Address())
+	prevTXs := map[string]TxOutputs{
+		hex.EncodeToString(genesis.ID): {Outputs: map[int]TxOutput{0: genesis.Outputs[0], 1: genesis.Outputs[1]}},
+	}
+	// spend pays values out of the first inputs outputs of genesis
+	spend := func(inputs int, values ...int) *Transaction {
+		tx := &Transaction{}
+		for outIdx := 0; outIdx < inputs; outIdx++ {
+			tx.Inputs = append(tx.Inputs, TxInput{genesis.ID, outIdx, nil, 0})
+		}
+		for _, value := range values {
+			tx.Outputs = append(tx.Outputs, *NewTXOutput(value, address))
+		}
+		tx.Sign(w.PrivateKey, prevTXs, SigHashAll)
+		tx.ID = tx.Hash()
+		return tx
+	}
+
+	tests := []struct {
+		name string
+		tx   *Transaction
+		fee  int
+		want error
+	}{
+		{"no fee", spend(1, 1), 0, nil},
+		{"two inputs", spend(2, 90), 11, nil},
+		{"more than the inputs", spend(1, 2), 0, ErrInvalidTransaction},
+		{"wrapping outputs", spend(1, math.MaxInt64, math.MaxInt64, 2), 0, ErrInvalidTransaction},
+		{"negative output", spend(1, 2, -1), 0, ErrInvalidTransaction},
+		{"output above max supply", spend(1, chain.Params.MaxSupply+1), 0, ErrInvalidTransaction},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fee, err := chain.fee(test.tx, prevTXs)
+			if !errors.Is(err, test.want) || fee != test.fee {
+				t.Errorf("fee() = %d, %v, want %d, %v", fee, err, test.fee, test.want)
+			}
+			err = chain.Database.View(func(txn *badger.Txn) error {
+				parent, err := getBlock(txn, chain.LastHash)
+				if err != nil {
+					return err
+				}
+				fees, err := chain.checkTransactions(txn, parent, []*Transaction{nil, test.tx})
+				if err == nil && fees != test.fee {
+					t.Errorf("checkTransactions() fees %d, want %d", fees, test.fee)
+				}
+				return err
+			})
+			if !errors.Is(err, test.want) {
+				t.Errorf("checkTransactions() error %v, want %v", err, test.want)
+			}
+			if _, err := NewMempool(chain).Add(test.tx); !errors.Is(err, test.want) {
+				t.Errorf("Mempool.Add() error %v, want %v", err, test.want)
+			}
+		})
+	}
+}
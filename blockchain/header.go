@@ -0,0 +1,98 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// BlockHeader holds every field committed to by a block's hash. The
+// transactions are only covered through MerkleRoot, so a header can be
+// validated and relayed on its own.
+type BlockHeader struct {
+	PrevHash   []byte
+	MerkleRoot []byte
+	Timestamp  int64
+	Height     int
+	Difficulty int
+	Nonce      int
+	// Signer and Signature seal the header under proof of authority and are
+	// empty under proof of work.
+	Signer    []byte
+	Signature []byte
+}
+
+// MaxHeaderFieldSize bounds each byte field of a block header.
+const MaxHeaderFieldSize = 255
+
+var ErrHeaderFieldTooLong = errors.New("Block header field is too long")
+
+// Serialize encodes the header as described at EncodingVersion.
+func (h *BlockHeader) Serialize() []byte {
+	var buff bytes.Buffer
+	writeUvarint(&buff, EncodingVersion)
+	writeVarBytes(&buff, h.PrevHash)
+	writeVarBytes(&buff, h.MerkleRoot)
+	writeInt64(&buff, h.Timestamp)
+	writeUvarint(&buff, uint64(h.Height))
+	writeUvarint(&buff, uint64(h.Difficulty))
+	writeUvarint(&buff, uint64(h.Nonce))
+	writeVarBytes(&buff, h.Signer)
+	writeVarBytes(&buff, h.Signature)
+	return buff.Bytes()
+}
+
+func DeserializeHeader(data []byte) (*BlockHeader, error) {
+	var h BlockHeader
+	d := newDecoder(data)
+	d.version()
+	h.PrevHash = d.varBytes()
+	h.MerkleRoot = d.varBytes()
+	h.Timestamp = d.int64()
+	h.Height = int(d.uvarint())
+	h.Difficulty = int(d.uvarint())
+	h.Nonce = int(d.uvarint())
+	h.Signer = d.varBytes()
+	h.Signature = d.varBytes()
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	if err := h.checkFieldSizes(); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// checkFieldSizes fails with ErrHeaderFieldTooLong when a byte field
+// exceeds MaxHeaderFieldSize.
+func (h *BlockHeader) checkFieldSizes() error {
+	fields := []struct {
+		name string
+		data []byte
+	}{
+		{"previous hash", h.PrevHash},
+		{"merkle root", h.MerkleRoot},
+		{"signer", h.Signer},
+		{"signature", h.Signature},
+	}
+	for _, f := range fields {
+		if len(f.data) > MaxHeaderFieldSize {
+			return fmt.Errorf("%w: %s has %d bytes", ErrHeaderFieldTooLong, f.name, len(f.data))
+		}
+	}
+	return nil
+}
+
+func (h *BlockHeader) Hash() []byte {
+	hash := sha256.Sum256(h.Serialize())
+	return hash[:]
+}
+
+// SealHash is the hash signed by a proof of authority validator. It covers
+// every field but the signature.
+func (h *BlockHeader) SealHash() []byte {
+	header := *h
+	header.Signature = nil
+	return header.Hash()
+}
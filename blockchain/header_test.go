@@ -0,0 +1,48 @@
+package blockchain
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestHeaderHash changes one header field at a time and checks whether the
+// hash and the seal hash change.
+func TestHeaderHash(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(h *BlockHeader)
+		sealed bool
+	}{
+		{"prev hash", func(h *BlockHeader) { h.PrevHash[0]++ }, true},
+		{"merkle root", func(h *BlockHeader) { h.MerkleRoot[0]++ }, true},
+		{"timestamp", func(h *BlockHeader) { h.Timestamp++ }, true},
+		{"height", func(h *BlockHeader) { h.Height++ }, true},
+		{"difficulty", func(h *BlockHeader) { h.Difficulty++ }, true},
+		{"nonce", func(h *BlockHeader) { h.Nonce++ }, true},
+		{"signer", func(h *BlockHeader) { h.Signer[0]++ }, true},
+		{"signature", func(h *BlockHeader) { h.Signature[0]++ }, false},
+	}
+	base := testBlock().BlockHeader
+	hash, sealHash := base.Hash(), base.SealHash()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := testBlock().BlockHeader
+			test.mutate(&h)
+			if bytes.Equal(h.Hash(), hash) {
+				t.Error("hash did not change")
+			}
+			if changed := !bytes.Equal(h.SealHash(), sealHash); changed != test.sealed {
+				t.Errorf("seal hash changed %t, want %t", changed, test.sealed)
+			}
+		})
+	}
+}
+
+func TestHeaderHashIgnoresBody(t *testing.T) {
+	block := testBlock()
+	hash := block.BlockHeader.Hash()
+	block.Transactions = block.Transactions[:1]
+	if !bytes.Equal(block.BlockHeader.Hash(), hash) {
+		t.Error("hash depends on the transactions, not only the merkle root")
+	}
+}
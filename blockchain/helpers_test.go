@@ -0,0 +1,91 @@
+package blockchain
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/leetcode-golang-classroom/golang-blockchain/wallet"
+)
+
+// testParams returns chain parameters for fast tests: difficulty 1 and
+// coinbases spendable right away.
+func testParams() *ChainParams {
+	params := DefaultChainParams()
+	params.InitialDifficulty = 1
+	params.CoinbaseMaturity = 0
+	params.GenesisTimestamp = time.Now().Unix()
+	return params
+}
+
+// newTestChain creates a chain in a temporary directory whose genesis
+// coinbase pays each of amounts to w, spendable right away.
+func newTestChain(t *testing.T, w *wallet.Wallet, amounts ...int) (*BlockChain, *Transaction) {
+	t.Helper()
+	params := testParams()
+	for _, amount := range amounts {
+		params.Allocations = append(params.Allocations, GenesisAllocation{Address: string(w.Address()), Amount: amount})
+	}
+	return newTestChainWithParams(t, params)
+}
+
+// newTestChainWithParams creates the chain of params in a temporary
+// directory and returns it with its genesis coinbase.
+func newTestChainWithParams(t *testing.T, params *ChainParams) (*BlockChain, *Transaction) {
+	t.Helper()
+	chain := createBlockChain(t.TempDir(), params)
+	t.Cleanup(func() { chain.Database.Close() })
+	genesis, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chain, genesis.Transactions[0]
+}
+
+// spendOutput returns a transaction of w spending output outIdx of prev,
+// which w owns, and paying value back to w.
+func spendOutput(w *wallet.Wallet, prev *Transaction, outIdx, value int, sequence uint32) *Transaction {
+	prevOut := prev.Outputs[outIdx]
+	prevTXs := map[string]TxOutputs{
+		hex.EncodeToString(prev.ID): {Outputs: map[int]TxOutput{outIdx: prevOut}},
+	}
+	tx := &Transaction{nil, []TxInput{{prev.ID, outIdx, nil, sequence}}, []TxOutput{*NewTXOutput(value, string(w.Address()))}, 0}
+	tx.Sign(w.PrivateKey, prevTXs, SigHashAll)
+	tx.ID = tx.Hash()
+	return tx
+}
+
+// newTestBlock seals a block on top of parent holding coinbase and txs. The
+// block is not added to the chain.
+func newTestBlock(t *testing.T, chain *BlockChain, parent *Block, coinbase *Transaction, txs ...*Transaction) *Block {
+	t.Helper()
+	difficulty, err := chain.NextDifficulty(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := &Block{
+		BlockHeader: BlockHeader{
+			PrevHash:   parent.Hash,
+			Timestamp:  time.Now().Unix(),
+			Height:     parent.Height + 1,
+			Difficulty: difficulty,
+		},
+		Transactions: append([]*Transaction{coinbase}, txs...),
+	}
+	block.MerkleRoot = block.HashTransactions()
+	if err := chain.Engine.Seal(context.Background(), block); err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+// tip returns the block at the tip of chain.
+func tip(t *testing.T, chain *BlockChain) *Block {
+	t.Helper()
+	block, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &block
+}
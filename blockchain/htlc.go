@@ -0,0 +1,136 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/leetcode-golang-classroom/golang-blockchain/script"
+	"github.com/leetcode-golang-classroom/golang-blockchain/wallet"
+)
+
+var (
+	ErrNotHTLC          = errors.New("Script is not a hash time-locked contract")
+	ErrNotHTLCKey       = errors.New("Key may not spend this contract")
+	ErrBadSecret        = errors.New("Secret does not match the contract")
+	ErrNoContractOutput = errors.New("Contract has no unspent outputs")
+)
+
+// NewHashTimeLock returns the contract paying to recipient for the preimage
+// of secretHash, and back to refund once lockTime has passed.
+func NewHashTimeLock(secretHash []byte, recipient, refund string, lockTime int64) (script.Script, error) {
+	if len(secretHash) != sha256.Size {
+		return nil, fmt.Errorf("Secret hash must be %d bytes", sha256.Size)
+	}
+	if lockTime <= 0 {
+		return nil, errors.New("Contract lock time must be positive")
+	}
+	var hashes [][]byte
+	for _, address := range []string{recipient, refund} {
+		if !wallet.ValidateAddress(address) || wallet.IsScriptAddress(address) {
+			return nil, fmt.Errorf("Address %s is not valid", address)
+		}
+		hashes = append(hashes, script.ExtractPubKeyHash(AddressScript(address)))
+	}
+	h := script.HashTimeLock{SecretHash: secretHash, RecipientHash: hashes[0], RefundHash: hashes[1], LockTime: lockTime}
+	return h.Script(), nil
+}
+
+// HTLCAddress returns the address the contract is paid to.
+func HTLCAddress(contract script.Script) (string, error) {
+	if _, ok := script.ExtractHashTimeLock(contract); !ok {
+		return "", ErrNotHTLC
+	}
+	return string(wallet.ScriptAddress(contract.Hash())), nil
+}
+
+// SpendHTLC moves every unspent output paid to contract to the address to,
+// less fee. With a secret w has to be the recipient of the contract.
+// Without one w has to be the refund key, and the transaction is locked
+// until the contract times out.
+func SpendHTLC(w *wallet.Wallet, contract script.Script, secret []byte, to string, fee Fee, UTXO *UTXOSet) (*Transaction, error) {
+	h, ok := script.ExtractHashTimeLock(contract)
+	if !ok {
+		return nil, ErrNotHTLC
+	}
+	pubKeyHash := wallet.PublicKeyHash(w.PublicKey)
+	if secret != nil {
+		if hash := sha256.Sum256(secret); len(secret) != script.SecretLength || !bytes.Equal(hash[:], h.SecretHash) {
+			return nil, ErrBadSecret
+		}
+		if !bytes.Equal(pubKeyHash, h.RecipientHash) {
+			return nil, ErrNotHTLCKey
+		}
+	} else if !bytes.Equal(pubKeyHash, h.RefundHash) {
+		return nil, ErrNotHTLCKey
+	}
+
+	utxos := UTXO.SpendableOutputs(script.PayToScriptHash(contract.Hash()))
+	if len(utxos) == 0 {
+		return nil, ErrNoContractOutput
+	}
+	acc := 0
+	var inputs []TxInput
+	for _, utxo := range utxos {
+		inputs = append(inputs, TxInput{utxo.TxID, utxo.Out, nil, 0})
+		acc += utxo.Value
+	}
+	feeAmount := fee.Absolute
+	for {
+		if acc <= feeAmount {
+			return nil, ErrNotEnoughFunds
+		}
+		tx := Transaction{nil, append([]TxInput{}, inputs...), []TxOutput{*NewTXOutput(acc-feeAmount, to)}, 0}
+		if secret == nil {
+			tx.LockTime = h.LockTime
+		}
+		prevTXs, err := UTXO.BlockChain.prevOutputs(&tx)
+		if err != nil {
+			return nil, err
+		}
+		for inIdx, in := range tx.Inputs {
+			prevOut := prevTXs[hex.EncodeToString(in.ID)].Outputs[in.Out]
+			signature, err := tx.signInput(w.PrivateKey, inIdx, prevOut.LockingScript, SigHashAll)
+			if err != nil {
+				return nil, err
+			}
+			if secret != nil {
+				tx.Inputs[inIdx].UnlockingScript = script.HashTimeLockRedeem(signature, w.PublicKey, secret, contract)
+			} else {
+				tx.Inputs[inIdx].UnlockingScript = script.HashTimeLockRefund(signature, w.PublicKey, contract)
+			}
+		}
+		tx.ID = tx.Hash()
+
+		required := fee.Amount(len(tx.Serialize()))
+		if required <= feeAmount {
+			return &tx, nil
+		}
+		feeAmount = required
+	}
+}
+
+// FindHTLCSecret looks through the chain for a transaction redeeming
+// contract and returns the secret it revealed, or nil when the contract
+// has not been redeemed.
+func (chain *BlockChain) FindHTLCSecret(contract script.Script) []byte {
+	iter := chain.Iterator()
+	for {
+		block := iter.Next()
+		for _, tx := range block.Transactions {
+			if tx.IsCoinbase() {
+				continue
+			}
+			for _, in := range tx.Inputs {
+				if secret := script.ExtractHashTimeLockSecret(in.UnlockingScript, contract); secret != nil {
+					return secret
+				}
+			}
+		}
+		if len(block.PrevHash) == 0 {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/leetcode-golang-classroom/golang-blockchain/script"
+	"github.com/leetcode-golang-classroom/golang-blockchain/wallet"
+)
+
+// TestHTLC funds two contracts from alice to bob, claims one with the secret
+// and refunds the other once it timed out.
+func TestHTLC(t *testing.T) {
+	alice, bob := wallet.MakeWallet(), wallet.MakeWallet()
+	aliceAddress, bobAddress := string(alice.Address()), string(bob.Address())
+	chain, genesis := newTestChain(t, alice, 100, 100)
+	UTXO := &UTXOSet{chain}
+	mine := func(txs ...*Transaction) {
+		t.Helper()
+		if _, err := chain.AddBlock(newTestBlock(t, chain, tip(t, chain), CoinBaseTx(aliceAddress, "tag", 0), txs...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fund := func(outIdx int, contract script.Script) *Transaction {
+		t.Helper()
+		address, err := HTLCAddress(contract)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prevTXs := map[string]TxOutputs{hex.EncodeToString(genesis.ID): {Outputs: map[int]TxOutput{outIdx: genesis.Outputs[outIdx]}}}
+		tx := &Transaction{nil, []TxInput{{genesis.ID, outIdx, nil, 0}}, []TxOutput{*NewTXOutput(90, address)}, 0}
+		tx.Sign(alice.PrivateKey, prevTXs, SigHashAll)
+		tx.ID = tx.Hash()
+		return tx
+	}
+
+	secret := bytes.Repeat([]byte{7}, script.SecretLength)
+	secretHash := sha256.Sum256(secret)
+	claimable, err := NewHashTimeLock(secretHash[:], bobAddress, aliceAddress, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherHash := sha256.Sum256([]byte("other secret"))
+	const lockTime = 3
+	refundable, err := NewHashTimeLock(otherHash[:], bobAddress, aliceAddress, lockTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unfunded, err := NewHashTimeLock(otherHash[:], bobAddress, aliceAddress, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mine(fund(0, claimable), fund(1, refundable))
+
+	fee := Fee{Absolute: 1}
+	errTests := []struct {
+		name     string
+		w        *wallet.Wallet
+		contract script.Script
+		secret   []byte
+		want     error
+	}{
+		{"wrong secret", bob, claimable, bytes.Repeat([]byte{8}, script.SecretLength), ErrBadSecret},
+		{"short secret", bob, claimable, secret[1:], ErrBadSecret},
+		{"secret with the refund key", alice, claimable, secret, ErrNotHTLCKey},
+		{"refund with the recipient key", bob, refundable, nil, ErrNotHTLCKey},
+		{"not a contract", bob, script.PayToPubKeyHash(wallet.PublicKeyHash(bob.PublicKey)), secret, ErrNotHTLC},
+		{"no outputs", alice, unfunded, nil, ErrNoContractOutput},
+	}
+	for _, test := range errTests {
+		if _, err := SpendHTLC(test.w, test.contract, test.secret, aliceAddress, fee, UTXO); !errors.Is(err, test.want) {
+			t.Errorf("%s: SpendHTLC() = %v, want %v", test.name, err, test.want)
+		}
+	}
+
+	claim, err := SpendHTLC(bob, claimable, secret, bobAddress, fee, UTXO)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claim.Outputs) != 1 || claim.Outputs[0].Value != 89 || claim.LockTime != 0 {
+		t.Errorf("claim pays %v locked until %d, want 89 at once", claim.Outputs, claim.LockTime)
+	}
+	if _, err := NewMempool(chain).Add(claim); err != nil {
+		t.Errorf("Mempool.Add(claim) = %v", err)
+	}
+	mine(claim)
+	if got := chain.FindHTLCSecret(claimable); !bytes.Equal(got, secret) {
+		t.Errorf("FindHTLCSecret(claimed) = %x, want %x", got, secret)
+	}
+	if got := chain.FindHTLCSecret(refundable); got != nil {
+		t.Errorf("FindHTLCSecret(unclaimed) = %x, want nil", got)
+	}
+
+	// the tip is at height 2, so the refund may go into block 4 onwards
+	refund, err := SpendHTLC(alice, refundable, nil, aliceAddress, fee, UTXO)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refund.LockTime != lockTime {
+		t.Errorf("refund is locked until %d, want %d", refund.LockTime, lockTime)
+	}
+	if _, err := NewMempool(chain).Add(refund); !errors.Is(err, ErrLockTime) {
+		t.Errorf("Mempool.Add(early refund) = %v, want %v", err, ErrLockTime)
+	}
+	mine()
+	if _, err := NewMempool(chain).Add(refund); err != nil {
+		t.Errorf("Mempool.Add(refund) = %v", err)
+	}
+	mine(refund)
+	if outputs := UTXO.SpendableOutputs(script.PayToScriptHash(refundable.Hash())); len(outputs) != 0 {
+		t.Errorf("refunded contract still has outputs %v", outputs)
+	}
+}
@@ -0,0 +1,125 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger"
+)
+
+const (
+	// LockTimeThreshold separates lock times given as block heights, below
+	// it, from lock times given as unix timestamps.
+	LockTimeThreshold = 500000000
+
+	// An input sequence encodes a relative lock: the spent output has to be
+	// buried by a number of blocks, or be a number of 512 second units old,
+	// before the input may be mined. A zero sequence requires nothing.
+
+	// SequenceLockTimeDisabled turns the relative lock of an input off.
+	SequenceLockTimeDisabled = 1 << 31
+	// SequenceLockTimeIsSeconds makes the relative lock count time instead
+	// of blocks.
+	SequenceLockTimeIsSeconds = 1 << 22
+	// SequenceLockTimeMask selects the length of the relative lock.
+	SequenceLockTimeMask = 0x0000ffff
+	// SequenceLockTimeGranularity is the shift converting the length of a
+	// time based relative lock to seconds.
+	SequenceLockTimeGranularity = 9
+)
+
+var (
+	ErrLockTime     = errors.New("Transaction lock time has not passed")
+	ErrSequenceLock = errors.New("Relative lock of an input has not passed")
+)
+
+// checkLockTime makes sure the transaction may be mined in a block at height
+// whose parent blocks have the given median time.
+func (tx *Transaction) checkLockTime(height int, medianTime int64) error {
+	if tx.LockTime == 0 {
+		return nil
+	}
+	limit := int64(height)
+	if tx.LockTime >= LockTimeThreshold {
+		limit = medianTime
+	}
+	if tx.LockTime >= limit {
+		return fmt.Errorf("%w: %x is locked until %d", ErrLockTime, tx.ID, tx.LockTime)
+	}
+	return nil
+}
+
+// checkSequenceLocks makes sure every input has waited out its relative lock
+// when mined in a block at height whose parent blocks have the given median
+// time. prevTXs holds the spent outputs with the height and time they were
+// created at.
+func (tx *Transaction) checkSequenceLocks(prevTXs map[string]TxOutputs, height int, medianTime int64) error {
+	if tx.IsCoinbase() {
+		return nil
+	}
+	for inIdx, in := range tx.Inputs {
+		if in.Sequence&SequenceLockTimeDisabled != 0 {
+			continue
+		}
+		prev, ok := prevTXs[hex.EncodeToString(in.ID)]
+		if !ok {
+			return fmt.Errorf("input %d spends an unknown output", inIdx)
+		}
+		length := int64(in.Sequence & SequenceLockTimeMask)
+		if in.Sequence&SequenceLockTimeIsSeconds != 0 {
+			if medianTime-prev.Time < length<<SequenceLockTimeGranularity {
+				return fmt.Errorf("%w: input %d of %x waits %d seconds", ErrSequenceLock, inIdx, tx.ID, length<<SequenceLockTimeGranularity)
+			}
+		} else if int64(height-prev.Height) < length {
+			return fmt.Errorf("%w: input %d of %x waits %d blocks", ErrSequenceLock, inIdx, tx.ID, length)
+		}
+	}
+	return nil
+}
+
+// CheckFinal returns an error when the lock time of tx or the relative lock
+// of one of its inputs keeps it out of the next block. Inputs spending
+// outputs that are not confirmed yet count them as confirmed by the next
+// block.
+func (chain *BlockChain) CheckFinal(tx *Transaction) error {
+	if tx.IsCoinbase() {
+		return nil
+	}
+	prevTXs := make(map[string]TxOutputs)
+	var height int
+	var median int64
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		tipHash, err := getTip(txn)
+		if err != nil {
+			return err
+		}
+		tip, err := getBlock(txn, tipHash)
+		if err != nil {
+			return err
+		}
+		height = tip.Height + 1
+		if median, err = medianTime(txn, tip); err != nil {
+			return err
+		}
+		UTXO := UTXOSet{chain}
+		for _, in := range tx.Inputs {
+			outs, err := UTXO.getOutputs(txn, in.ID)
+			if err != nil {
+				return err
+			}
+			if _, ok := outs.Outputs[in.Out]; !ok {
+				outs.Height, outs.Time = height, median
+			}
+			prevTXs[hex.EncodeToString(in.ID)] = outs
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := tx.checkLockTime(height, median); err != nil {
+		return err
+	}
+	return tx.checkSequenceLocks(prevTXs, height, median)
+}
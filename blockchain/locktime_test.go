@@ -0,0 +1,166 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/leetcode-golang-classroom/golang-blockchain/wallet"
+)
+
+func TestCheckLockTime(t *testing.T) {
+	tests := []struct {
+		name     string
+		lockTime int64
+		height   int
+		median   int64
+		want     error
+	}{
+		{"none", 0, 1, 0, nil},
+		{"height reached", 10, 11, 0, nil},
+		{"height not reached", 10, 10, 0, ErrLockTime},
+		{"time reached", LockTimeThreshold + 100, 1, LockTimeThreshold + 101, nil},
+		{"time not reached", LockTimeThreshold + 100, 1, LockTimeThreshold + 100, ErrLockTime},
+		{"time ignores the height", LockTimeThreshold, LockTimeThreshold + 1, 0, ErrLockTime},
+		{"height ignores the time", LockTimeThreshold - 1, 1, LockTimeThreshold + 1, ErrLockTime},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx := &Transaction{LockTime: test.lockTime}
+			if err := tx.checkLockTime(test.height, test.median); !errors.Is(err, test.want) {
+				t.Errorf("checkLockTime(%d, %d) = %v, want %v", test.height, test.median, err, test.want)
+			}
+		})
+	}
+}
+
+func TestCheckSequenceLocks(t *testing.T) {
+	prevID := []byte{1}
+	prevTXs := map[string]TxOutputs{hex.EncodeToString(prevID): {Outputs: map[int]TxOutput{0: {}}, Height: 5, Time: 10000}}
+	const seconds = 2 << SequenceLockTimeGranularity
+	tests := []struct {
+		name     string
+		sequence uint32
+		height   int
+		median   int64
+		want     error
+	}{
+		{"no lock", 0, 6, 10000, nil},
+		{"disabled", SequenceLockTimeDisabled | 100, 6, 10000, nil},
+		{"blocks passed", 3, 8, 10000, nil},
+		{"blocks not passed", 3, 7, 10000, ErrSequenceLock},
+		{"length outside the mask", 1 << 16, 6, 10000, nil},
+		{"time passed", SequenceLockTimeIsSeconds | 2, 6, 10000 + seconds, nil},
+		{"time not passed", SequenceLockTimeIsSeconds | 2, 100, 10000 + seconds - 1, ErrSequenceLock},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx := &Transaction{Inputs: []TxInput{{prevID, 0, nil, test.sequence}}}
+			if err := tx.checkSequenceLocks(prevTXs, test.height, test.median); !errors.Is(err, test.want) {
+				t.Errorf("checkSequenceLocks(%d, %d) = %v, want %v", test.height, test.median, err, test.want)
+			}
+		})
+	}
+	tx := &Transaction{Inputs: []TxInput{{[]byte{2}, 0, nil, 1}}}
+	if err := tx.checkSequenceLocks(prevTXs, 100, 100000); err == nil {
+		t.Error("checkSequenceLocks accepted an unknown output")
+	}
+}
+
+// TestLockCheckers checks the lock times and sequences that
+// OP_CHECKLOCKTIMEVERIFY and OP_CHECKSEQUENCEVERIFY see in a transaction.
+func TestLockCheckers(t *testing.T) {
+	tests := []struct {
+		name       string
+		txLockTime int64
+		txSequence uint32
+		lockTime   int64
+		sequence   int64
+		lockTimeOK bool
+		sequenceOK bool
+	}{
+		{"equal", 100, 10, 100, 10, true, true},
+		{"below", 100, 10, 99, 9, true, true},
+		{"above", 100, 10, 101, 11, false, false},
+		{"time against height", 100, 10, LockTimeThreshold, 0, false, true},
+		{"height against time", LockTimeThreshold, 10, 100, 0, false, true},
+		{"seconds against blocks", 0, 10, 0, SequenceLockTimeIsSeconds | 1, true, false},
+		{"input lock disabled", 0, SequenceLockTimeDisabled, 0, 1, true, false},
+		{"script lock disabled", 0, 0, 0, SequenceLockTimeDisabled | 1, true, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx := &Transaction{Inputs: []TxInput{{Sequence: test.txSequence}}, LockTime: test.txLockTime}
+			c := sigChecker{tx: tx, inIdx: 0}
+			if got := c.CheckLockTime(test.lockTime); got != test.lockTimeOK {
+				t.Errorf("CheckLockTime(%d) = %t, want %t", test.lockTime, got, test.lockTimeOK)
+			}
+			if got := c.CheckSequence(test.sequence); got != test.sequenceOK {
+				t.Errorf("CheckSequence(%d) = %t, want %t", test.sequence, got, test.sequenceOK)
+			}
+		})
+	}
+}
+
+// TestCheckFinal runs locked transactions through CheckFinal, the mempool
+// and a block on top of the tip at height 1.
+func TestCheckFinal(t *testing.T) {
+	w := wallet.MakeWallet()
+	address := string(w.Address())
+	chain, genesis := newTestChain(t, w, 100, 100)
+	if _, err := chain.AddBlock(newTestBlock(t, chain, tip(t, chain), CoinBaseTx(address, "tag", 0))); err != nil {
+		t.Fatal(err)
+	}
+	locked := func(lockTime int64, sequence uint32) *Transaction {
+		tx := spendOutput(w, genesis, 0, 90, sequence)
+		tx.LockTime = lockTime
+		tx.Inputs[0].UnlockingScript = nil
+		prevTXs := map[string]TxOutputs{hex.EncodeToString(genesis.ID): {Outputs: map[int]TxOutput{0: genesis.Outputs[0]}}}
+		tx.Sign(w.PrivateKey, prevTXs, SigHashAll)
+		tx.ID = tx.Hash()
+		return tx
+	}
+	parent := spendOutput(w, genesis, 1, 90, 0)
+
+	tests := []struct {
+		name   string
+		parent *Transaction
+		tx     *Transaction
+		want   error
+	}{
+		{"final", nil, locked(0, 0), nil},
+		{"height reached", nil, locked(1, 0), nil},
+		{"height not reached", nil, locked(2, 0), ErrLockTime},
+		{"time not reached", nil, locked(1<<40, 0), ErrLockTime},
+		{"relative lock passed", nil, locked(0, 2), nil},
+		{"relative lock not passed", nil, locked(0, 3), ErrSequenceLock},
+		{"relative lock disabled", nil, locked(0, SequenceLockTimeDisabled|3), nil},
+		{"no relative lock on an unconfirmed parent", parent, spendOutput(w, parent, 0, 80, 0), nil},
+		{"relative lock on an unconfirmed parent", parent, spendOutput(w, parent, 0, 80, 1), ErrSequenceLock},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := chain.CheckFinal(test.tx); !errors.Is(err, test.want) {
+				t.Errorf("CheckFinal() = %v, want %v", err, test.want)
+			}
+
+			mp := NewMempool(chain)
+			txs := []*Transaction{test.tx}
+			if test.parent != nil {
+				if _, err := mp.Add(test.parent); err != nil {
+					t.Fatal(err)
+				}
+				txs = []*Transaction{test.parent, test.tx}
+			}
+			if _, err := mp.Add(test.tx); !errors.Is(err, test.want) {
+				t.Errorf("Mempool.Add() = %v, want %v", err, test.want)
+			}
+
+			block := newTestBlock(t, chain, tip(t, chain), CoinBaseTx(address, "tag", 0), txs...)
+			err := chain.ValidateBlock(block)
+			if rejected := test.want != nil; (err != nil) != rejected || (rejected && !errors.Is(err, ErrInvalidTransaction)) {
+				t.Errorf("ValidateBlock() = %v, want rejection %t", err, rejected)
+			}
+		})
+	}
+}
@@ -0,0 +1,500 @@
+package blockchain
+
+import (
+	"container/heap"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/leetcode-golang-classroom/golang-blockchain/wallet"
+)
+
+// SequenceReplaceable set on any input of a transaction signals that the
+// transaction may be replaced in the memory pool by a conflicting one paying
+// more. The bit lies outside those read by relative locks.
+const SequenceReplaceable = 1 << 30
+
+var (
+	ErrTxInPool        = errors.New("Transaction is already in the memory pool")
+	ErrTxConflict      = errors.New("Transaction spends an output already spent by a transaction that is not replaceable")
+	ErrInsufficientFee = errors.New("Replacement must pay a higher fee and fee rate than the transactions it replaces")
+	ErrNotReplaceable  = errors.New("Transaction does not signal replaceability")
+	ErrNoChangeOutput  = errors.New("Transaction has no change output to take the fee from")
+)
+
+// MempoolEntry is a transaction waiting in the memory pool together with
+// the fee it pays and its serialized size.
+type MempoolEntry struct {
+	Tx   *Transaction
+	Fee  int
+	Size int
+	// parents and children hold the IDs of the transactions in the pool
+	// that Tx spends from and that spend from Tx.
+	parents  map[string]bool
+	children map[string]bool
+	// ancestorFee and ancestorSize add up Tx and its ancestors in the pool.
+	ancestorFee  int
+	ancestorSize int
+}
+
+// higherFeeRate reports whether e pays more per byte than other.
+func (e *MempoolEntry) higherFeeRate(other *MempoolEntry) bool {
+	return e.Fee*other.Size > other.Fee*e.Size
+}
+
+// Mempool holds the verified transactions that wait to be mined. They may
+// spend the outputs of each other. At most one of them spends any given
+// output; a transaction that signals SequenceReplaceable gives its place to
+// a conflicting one paying a higher fee and fee rate.
+type Mempool struct {
+	chain   *BlockChain
+	mu      sync.Mutex
+	entries map[string]*MempoolEntry
+	// spent maps every output spent by the pool to the spending transaction.
+	spent map[string]string
+}
+
+func NewMempool(chain *BlockChain) *Mempool {
+	return &Mempool{
+		chain:   chain,
+		entries: make(map[string]*MempoolEntry),
+		spent:   make(map[string]string),
+	}
+}
+
+// IsReplaceable reports whether tx opted in to replace-by-fee.
+func (tx *Transaction) IsReplaceable() bool {
+	for _, in := range tx.Inputs {
+		if in.Sequence&SequenceReplaceable != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func outpoint(txID []byte, out int) string {
+	return fmt.Sprintf("%x:%d", txID, out)
+}
+
+// transactionFee returns what the inputs of tx hold beyond its outputs.
+func (chain *BlockChain) transactionFee(tx *Transaction) (int, error) {
+	prevTXs, err := chain.prevOutputs(tx)
+	if err != nil {
+		return 0, err
+	}
+	return chain.fee(tx, prevTXs)
+}
+
+// prevOutputs looks up the outputs spent by tx in the UTXO set or, when not
+// confirmed yet, among the transactions of the pool, which it also returns.
+// Unconfirmed outputs carry no height or time; CheckFinal counts them as
+// confirmed by the next block.
+func (mp *Mempool) prevOutputs(tx *Transaction) (map[string]TxOutputs, map[string]bool, error) {
+	prevTXs := make(map[string]TxOutputs)
+	parents := make(map[string]bool)
+	var confirmed Transaction
+	for _, in := range tx.Inputs {
+		txID := hex.EncodeToString(in.ID)
+		parent, ok := mp.entries[txID]
+		if !ok {
+			confirmed.Inputs = append(confirmed.Inputs, in)
+			continue
+		}
+		if in.Out < 0 || in.Out >= len(parent.Tx.Outputs) || parent.Tx.Outputs[in.Out].LockingScript.IsUnspendable() {
+			return nil, nil, fmt.Errorf("%w: %x spends %s:%d which does not exist", ErrInvalidTransaction, tx.ID, txID, in.Out)
+		}
+		outs := prevTXs[txID]
+		if outs.Outputs == nil {
+			outs.Outputs = make(map[int]TxOutput)
+		}
+		outs.Outputs[in.Out] = parent.Tx.Outputs[in.Out]
+		prevTXs[txID] = outs
+		parents[txID] = true
+	}
+	confirmedTXs, err := mp.chain.prevOutputs(&confirmed)
+	if err != nil {
+		return nil, nil, err
+	}
+	for txID, outs := range confirmedTXs {
+		prevTXs[txID] = outs
+	}
+	return prevTXs, parents, nil
+}
+
+// Add verifies tx and puts it in the pool. It may spend outputs of
+// transactions in the pool. When it spends outputs already spent by
+// transactions in the pool, those are replaced together with their
+// descendants if they all signal replaceability and tx pays both more than
+// the fees of all replaced transactions and a higher fee rate than each of
+// those it conflicts with. The replaced transactions are returned.
+func (mp *Mempool) Add(tx *Transaction) ([]*Transaction, error) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	txID := hex.EncodeToString(tx.ID)
+	if _, ok := mp.entries[txID]; ok {
+		return nil, ErrTxInPool
+	}
+	if tx.IsCoinbase() {
+		return nil, fmt.Errorf("%w: %x is a coinbase", ErrInvalidTransaction, tx.ID)
+	}
+	prevTXs, parents, err := mp.prevOutputs(tx)
+	if err != nil {
+		return nil, err
+	}
+	if err := mp.chain.verifyTransaction(tx, prevTXs); err != nil {
+		return nil, err
+	}
+	fee, err := mp.chain.fee(tx, prevTXs)
+	if err != nil {
+		return nil, err
+	}
+	entry := &MempoolEntry{Tx: tx, Fee: fee, Size: len(tx.Serialize()), parents: parents, children: make(map[string]bool)}
+
+	conflicts := make(map[string]*MempoolEntry)
+	seen := make(map[string]bool)
+	for _, in := range tx.Inputs {
+		op := outpoint(in.ID, in.Out)
+		if seen[op] {
+			return nil, fmt.Errorf("%w: %x spends %s twice", ErrInvalidTransaction, tx.ID, op)
+		}
+		seen[op] = true
+		if spender, ok := mp.spent[op]; ok {
+			conflicts[spender] = mp.entries[spender]
+		}
+	}
+	evicted := make(map[string]bool)
+	for spender, old := range conflicts {
+		if !old.Tx.IsReplaceable() {
+			return nil, ErrTxConflict
+		}
+		if !entry.higherFeeRate(old) {
+			return nil, ErrInsufficientFee
+		}
+		mp.descendants(spender, evicted)
+	}
+	replacedFee := 0
+	for id := range evicted {
+		if parents[id] {
+			return nil, fmt.Errorf("%w: %x spends an output of a transaction it replaces", ErrInvalidTransaction, tx.ID)
+		}
+		replacedFee += mp.entries[id].Fee
+	}
+	if len(evicted) > 0 && fee <= replacedFee {
+		return nil, ErrInsufficientFee
+	}
+
+	var replaced []*Transaction
+	for id := range evicted {
+		replaced = append(replaced, mp.entries[id].Tx)
+		mp.remove(id)
+	}
+	mp.entries[txID] = entry
+	for op := range seen {
+		mp.spent[op] = txID
+	}
+	for parent := range parents {
+		mp.entries[parent].children[txID] = true
+	}
+	// transactions kept in the pool while tx was confirmed in a block that
+	// got disconnected may spend it already
+	for outIdx := range tx.Outputs {
+		if child, ok := mp.spent[outpoint(tx.ID, outIdx)]; ok {
+			entry.children[child] = true
+			mp.entries[child].parents[txID] = true
+		}
+	}
+	affected := make(map[string]bool)
+	mp.descendants(txID, affected)
+	for id := range affected {
+		mp.updateAncestorTotals(id)
+	}
+	return replaced, nil
+}
+
+// updateAncestorTotals recomputes the ancestor fee and size of txID.
+func (mp *Mempool) updateAncestorTotals(txID string) {
+	pkg := make(map[string]bool)
+	mp.ancestors(txID, nil, pkg)
+	entry := mp.entries[txID]
+	entry.ancestorFee, entry.ancestorSize = 0, 0
+	for id := range pkg {
+		entry.ancestorFee += mp.entries[id].Fee
+		entry.ancestorSize += mp.entries[id].Size
+	}
+}
+
+// descendants adds txID and every transaction of the pool spending from it,
+// directly or not, to set.
+func (mp *Mempool) descendants(txID string, set map[string]bool) {
+	if set[txID] {
+		return
+	}
+	set[txID] = true
+	for child := range mp.entries[txID].children {
+		mp.descendants(child, set)
+	}
+}
+
+// ancestors adds txID and every transaction of the pool it spends from,
+// directly or not, to set, leaving out those in skip.
+func (mp *Mempool) ancestors(txID string, skip, set map[string]bool) {
+	if set[txID] || skip[txID] {
+		return
+	}
+	set[txID] = true
+	for parent := range mp.entries[txID].parents {
+		mp.ancestors(parent, skip, set)
+	}
+}
+
+// remove drops txID from the pool. Its children stay, for when it left the
+// pool because it got confirmed.
+func (mp *Mempool) remove(txID string) {
+	entry, ok := mp.entries[txID]
+	if !ok {
+		return
+	}
+	affected := make(map[string]bool)
+	mp.descendants(txID, affected)
+	delete(affected, txID)
+	for _, in := range entry.Tx.Inputs {
+		delete(mp.spent, outpoint(in.ID, in.Out))
+	}
+	for parent := range entry.parents {
+		delete(mp.entries[parent].children, txID)
+	}
+	for child := range entry.children {
+		delete(mp.entries[child].parents, txID)
+	}
+	delete(mp.entries, txID)
+	for id := range affected {
+		mp.updateAncestorTotals(id)
+	}
+}
+
+// RemoveBlock drops the transactions of block from the pool, together with
+// those spending an output the block spent and their descendants.
+func (mp *Mempool) RemoveBlock(block *Block) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	for _, tx := range block.Transactions {
+		mp.remove(hex.EncodeToString(tx.ID))
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, in := range tx.Inputs {
+			if spender, ok := mp.spent[outpoint(in.ID, in.Out)]; ok {
+				conflicting := make(map[string]bool)
+				mp.descendants(spender, conflicting)
+				for id := range conflicting {
+					mp.remove(id)
+				}
+			}
+		}
+	}
+}
+
+// Get returns the transaction with the given ID.
+func (mp *Mempool) Get(txID []byte) (*Transaction, bool) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	entry, ok := mp.entries[hex.EncodeToString(txID)]
+	if !ok {
+		return nil, false
+	}
+	return entry.Tx, true
+}
+
+// BlockCandidates returns the transactions of the pool in the order a block
+// should take them. Each transaction is evaluated as a package with its
+// ancestors still in the pool, so that a child paying a high fee pulls in
+// a parent paying a low one. Packages come by descending combined fee
+// rate, parents ahead of their children.
+func (mp *Mempool) BlockCandidates() []*Transaction {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	// fee and size start from the cached ancestor totals and lose every
+	// ancestor once it is selected
+	fee := make(map[string]int, len(mp.entries))
+	size := make(map[string]int, len(mp.entries))
+	packages := make(packageHeap, 0, len(mp.entries))
+	for txID, entry := range mp.entries {
+		fee[txID], size[txID] = entry.ancestorFee, entry.ancestorSize
+		packages = append(packages, txPackage{txID, entry.ancestorFee, entry.ancestorSize})
+	}
+	heap.Init(&packages)
+
+	selected := make(map[string]bool, len(mp.entries))
+	txs := make([]*Transaction, 0, len(mp.entries))
+	for packages.Len() > 0 {
+		best := heap.Pop(&packages).(txPackage)
+		// packages whose totals changed since they were pushed are stale
+		if selected[best.txID] || best.fee != fee[best.txID] || best.size != size[best.txID] {
+			continue
+		}
+		n := len(txs)
+		txs = mp.appendPackage(txs, best.txID, selected)
+		for _, tx := range txs[n:] {
+			txID := hex.EncodeToString(tx.ID)
+			entry := mp.entries[txID]
+			descendants := make(map[string]bool)
+			mp.descendants(txID, descendants)
+			for id := range descendants {
+				if selected[id] {
+					continue
+				}
+				fee[id] -= entry.Fee
+				size[id] -= entry.Size
+				heap.Push(&packages, txPackage{id, fee[id], size[id]})
+			}
+		}
+	}
+	return txs
+}
+
+// txPackage is a transaction of the pool with the fee and size of the
+// ancestors BlockCandidates has yet to select.
+type txPackage struct {
+	txID      string
+	fee, size int
+}
+
+// packageHeap orders packages by descending fee rate. Ties go to the lowest
+// ID so that the order is stable.
+type packageHeap []txPackage
+
+func (h packageHeap) Len() int { return len(h) }
+
+func (h packageHeap) Less(i, j int) bool {
+	a, b := h[i].fee*h[j].size, h[j].fee*h[i].size
+	return a > b || a == b && h[i].txID < h[j].txID
+}
+
+func (h packageHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *packageHeap) Push(x interface{}) { *h = append(*h, x.(txPackage)) }
+
+func (h *packageHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// appendPackage appends the ancestors of txID not yet selected, parents
+// first, and then txID itself to txs.
+func (mp *Mempool) appendPackage(txs []*Transaction, txID string, selected map[string]bool) []*Transaction {
+	if selected[txID] {
+		return txs
+	}
+	selected[txID] = true
+	for parent := range mp.entries[txID].parents {
+		txs = mp.appendPackage(txs, parent, selected)
+	}
+	return append(txs, mp.entries[txID].Tx)
+}
+
+func (mp *Mempool) Len() int {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	return len(mp.entries)
+}
+
+// BumpFee returns a replacement for the unconfirmed transaction tx of w
+// that pays fee instead, taken from the change output back to w. The change
+// output is dropped when the new fee uses it up.
+func BumpFee(w *wallet.Wallet, tx *Transaction, fee Fee, UTXO *UTXOSet) (*Transaction, error) {
+	if !tx.IsReplaceable() {
+		return nil, ErrNotReplaceable
+	}
+	oldFee, err := UTXO.BlockChain.transactionFee(tx)
+	if err != nil {
+		return nil, err
+	}
+	prevTXs, err := UTXO.BlockChain.prevOutputs(tx)
+	if err != nil {
+		return nil, err
+	}
+	pubKeyHash := wallet.PublicKeyHash(w.PublicKey)
+	for _, in := range tx.Inputs {
+		prevOut := prevTXs[hex.EncodeToString(in.ID)].Outputs[in.Out]
+		if !prevOut.IsLockedWithKey(pubKeyHash) {
+			return nil, fmt.Errorf("Input %x:%d is not spent by the key of the wallet", in.ID, in.Out)
+		}
+	}
+	change := -1
+	for outIdx, out := range tx.Outputs {
+		if out.IsLockedWithKey(pubKeyHash) {
+			change = outIdx
+		}
+	}
+	if change == -1 {
+		return nil, ErrNoChangeOutput
+	}
+
+	feeAmount := fee.Absolute
+	for {
+		bumped := tx.TrimmedCopy()
+		value := tx.Outputs[change].Value - (feeAmount - oldFee)
+		switch {
+		case value < 0 || value == 0 && len(bumped.Outputs) == 1:
+			return nil, ErrNotEnoughFunds
+		case value == 0:
+			bumped.Outputs = append(bumped.Outputs[:change], bumped.Outputs[change+1:]...)
+		default:
+			bumped.Outputs[change].Value = value
+		}
+		bumped.Sign(w.PrivateKey, prevTXs, SigHashAll)
+		bumped.ID = bumped.Hash()
+
+		required := fee.Amount(len(bumped.Serialize()))
+		if required <= feeAmount {
+			if feeAmount <= oldFee {
+				return nil, fmt.Errorf("%w: new fee %d, current fee %d", ErrInsufficientFee, feeAmount, oldFee)
+			}
+			return &bumped, nil
+		}
+		feeAmount = required
+	}
+}
+
+// ChildPaysForParent returns a transaction of w spending everything the
+// unconfirmed transaction parent pays to w back to w, leaving fee to the
+// miner. Miners take the two together at their combined fee rate, which
+// speeds up a parent paying too little.
+func ChildPaysForParent(w *wallet.Wallet, parent *Transaction, fee Fee) (*Transaction, error) {
+	pubKeyHash := wallet.PublicKeyHash(w.PublicKey)
+	prevTXs := map[string]TxOutputs{
+		hex.EncodeToString(parent.ID): {Outputs: make(map[int]TxOutput)},
+	}
+	var inputs []TxInput
+	value := 0
+	for outIdx, out := range parent.Outputs {
+		if out.IsLockedWithKey(pubKeyHash) {
+			prevTXs[hex.EncodeToString(parent.ID)].Outputs[outIdx] = out
+			inputs = append(inputs, TxInput{parent.ID, outIdx, nil, 0})
+			value += out.Value
+		}
+	}
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("Transaction %x pays nothing to %s", parent.ID, w.Address())
+	}
+
+	feeAmount := fee.Absolute
+	for {
+		if value <= feeAmount {
+			return nil, ErrNotEnoughFunds
+		}
+		child := Transaction{nil, inputs, []TxOutput{*NewTXOutput(value-feeAmount, string(w.Address()))}, 0}
+		child.Sign(w.PrivateKey, prevTXs, SigHashAll)
+		child.ID = child.Hash()
+
+		required := fee.Amount(len(child.Serialize()))
+		if required <= feeAmount {
+			return &child, nil
+		}
+		feeAmount = required
+	}
+}
@@ -0,0 +1,130 @@
+package blockchain
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/leetcode-golang-classroom/golang-blockchain/wallet"
+)
+
+func TestMempoolReplaceByFee(t *testing.T) {
+	w := wallet.MakeWallet()
+	chain, genesis := newTestChain(t, w, 100, 100)
+
+	original := spendOutput(w, genesis, 0, 99, SequenceReplaceable)
+	final := spendOutput(w, genesis, 0, 99, 0)
+	sameFee := spendOutput(w, genesis, 0, 99, SequenceReplaceable|1)
+	higherFee := spendOutput(w, genesis, 0, 95, SequenceReplaceable)
+	child := spendOutput(w, original, 0, 69, 0)
+	belowPackage := spendOutput(w, genesis, 0, 90, SequenceReplaceable)
+	abovePackage := spendOutput(w, genesis, 0, 60, SequenceReplaceable)
+	other := spendOutput(w, genesis, 1, 99, 0)
+
+	type step struct {
+		tx       *Transaction
+		err      error
+		replaced int
+	}
+	tests := []struct {
+		name  string
+		steps []step
+		pool  []*Transaction
+	}{
+		{"duplicate", []step{{original, nil, 0}, {original, ErrTxInPool, 0}}, []*Transaction{original}},
+		{"coinbase", []step{{genesis, ErrInvalidTransaction, 0}}, nil},
+		{"not replaceable", []step{{final, nil, 0}, {higherFee, ErrTxConflict, 0}}, []*Transaction{final}},
+		{"equal fee rate", []step{{original, nil, 0}, {sameFee, ErrInsufficientFee, 0}}, []*Transaction{original}},
+		{"higher fee", []step{{original, nil, 0}, {higherFee, nil, 1}}, []*Transaction{higherFee}},
+		{"unrelated output", []step{{original, nil, 0}, {other, nil, 0}}, []*Transaction{original, other}},
+		{
+			"fee below the replaced package",
+			[]step{{original, nil, 0}, {child, nil, 0}, {belowPackage, ErrInsufficientFee, 0}},
+			[]*Transaction{original, child},
+		},
+		{
+			"fee above the replaced package",
+			[]step{{original, nil, 0}, {child, nil, 0}, {abovePackage, nil, 2}},
+			[]*Transaction{abovePackage},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mp := NewMempool(chain)
+			for i, s := range test.steps {
+				replaced, err := mp.Add(s.tx)
+				if !errors.Is(err, s.err) {
+					t.Fatalf("step %d: Add error %v, want %v", i, err, s.err)
+				}
+				if len(replaced) != s.replaced {
+					t.Fatalf("step %d: replaced %d transactions, want %d", i, len(replaced), s.replaced)
+				}
+			}
+			if mp.Len() != len(test.pool) {
+				t.Errorf("pool holds %d transactions, want %d", mp.Len(), len(test.pool))
+			}
+			for _, tx := range test.pool {
+				if _, ok := mp.Get(tx.ID); !ok {
+					t.Errorf("transaction %x is not in the pool", tx.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestBlockCandidates(t *testing.T) {
+	w := wallet.MakeWallet()
+	chain, genesis := newTestChain(t, w, 100, 100, 100)
+
+	// every transaction has one input and one output and so the same size
+	txs := map[string]*Transaction{}
+	txs["low"] = spendOutput(w, genesis, 0, 99, 0)
+	txs["child"] = spendOutput(w, txs["low"], 0, 50, 0)
+	txs["grandchild"] = spendOutput(w, txs["child"], 0, 45, 0)
+	txs["mid"] = spendOutput(w, genesis, 1, 90, 0)
+	txs["high"] = spendOutput(w, genesis, 2, 70, 0)
+	names := make(map[string]string)
+	for name, tx := range txs {
+		names[string(tx.ID)] = name
+	}
+
+	tests := []struct {
+		name    string
+		add     []string
+		confirm []string
+		want    []string
+	}{
+		{"fee rate", []string{"low", "mid", "high"}, nil, []string{"high", "mid", "low"}},
+		{"child pays for parent", []string{"low", "child", "mid", "high"}, nil, []string{"high", "low", "child", "mid"}},
+		{
+			"descendant paying less than its package",
+			[]string{"low", "child", "grandchild", "mid", "high"}, nil,
+			[]string{"high", "low", "child", "mid", "grandchild"},
+		},
+		{
+			"confirmed parent",
+			[]string{"low", "child", "grandchild", "mid", "high"}, []string{"low"},
+			[]string{"child", "high", "mid", "grandchild"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mp := NewMempool(chain)
+			for _, name := range test.add {
+				if _, err := mp.Add(txs[name]); err != nil {
+					t.Fatalf("adding %s: %v", name, err)
+				}
+			}
+			for _, name := range test.confirm {
+				mp.RemoveBlock(&Block{Transactions: []*Transaction{txs[name]}})
+			}
+			var got []string
+			for _, tx := range mp.BlockCandidates() {
+				got = append(got, names[string(tx.ID)])
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("BlockCandidates() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
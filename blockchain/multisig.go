@@ -0,0 +1,196 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/leetcode-golang-classroom/golang-blockchain/script"
+	"github.com/leetcode-golang-classroom/golang-blockchain/wallet"
+)
+
+var (
+	ErrNotMultiSig       = errors.New("Redeem script is not a multisig script")
+	ErrNotMultiSigSigner = errors.New("Key is not one of the multisig keys")
+	ErrNotEnoughFunds    = errors.New("Not enough funds")
+)
+
+// MultiSigTx is a transaction spending outputs of a multisig address while
+// its owners collect signatures. It carries the spent outputs too, so that
+// it can be handed from signer to signer as a file without access to the
+// chain.
+type MultiSigTx struct {
+	Tx           Transaction
+	RedeemScript script.Script
+	PrevTXs      map[string]TxOutputs
+	// Signatures holds the signatures collected so far for every input,
+	// keyed by the hex encoded public key that made them.
+	Signatures []map[string][]byte
+}
+
+// MultiSigAddress returns the address of the outputs spendable by the given
+// multisig redeem script.
+func MultiSigAddress(redeem script.Script) (string, error) {
+	if _, _, ok := script.ExtractMultiSig(redeem); !ok {
+		return "", ErrNotMultiSig
+	}
+	return string(wallet.ScriptAddress(redeem.Hash())), nil
+}
+
+// NewMultiSigTx sends amount from the multisig address of redeem to the
+// address to, paying fee to the miner and the rest back to the multisig
+// address as change. The returned transaction still has to be signed.
+func NewMultiSigTx(redeem script.Script, to string, amount int, fee Fee, UTXO *UTXOSet) (*MultiSigTx, error) {
+	m, _, ok := script.ExtractMultiSig(redeem)
+	if !ok {
+		return nil, ErrNotMultiSig
+	}
+	from, err := MultiSigAddress(redeem)
+	if err != nil {
+		return nil, err
+	}
+	feeAmount := fee.Absolute
+	for {
+		var inputs []TxInput
+		var outputs []TxOutput
+		// every input carries m signatures and the redeem script, so spend
+		// as few as possible
+		acc, validOutputs, err := UTXO.FindSpendableOutputs(AddressScript(from), amount+feeAmount, LargestFirst{})
+		if err != nil {
+			return nil, err
+		}
+		for txid, outs := range validOutputs {
+			txID, err := hex.DecodeString(txid)
+			if err != nil {
+				return nil, err
+			}
+			for _, out := range outs {
+				inputs = append(inputs, TxInput{txID, out, nil, 0})
+			}
+		}
+		outputs = append(outputs, *NewTXOutput(amount, to))
+		if change := acc - amount - feeAmount; change > 0 {
+			outputs = append(outputs, *NewTXOutput(change, from))
+		}
+		tx := Transaction{nil, inputs, outputs, 0}
+
+		// Size the fee for the finished transaction, with m signatures and
+		// the redeem script in every input.
+		placeholders := make([][]byte, m)
+		for i := range placeholders {
+			placeholders[i] = make([]byte, wallet.SignatureLength+1)
+		}
+		for i := range tx.Inputs {
+			tx.Inputs[i].UnlockingScript = script.ScriptHashUnlock(placeholders, redeem)
+		}
+		required := fee.Amount(len(tx.Serialize()))
+		if required > feeAmount {
+			feeAmount = required
+			continue
+		}
+
+		for i := range tx.Inputs {
+			tx.Inputs[i].UnlockingScript = nil
+		}
+		tx.ID = tx.Hash()
+		prevTXs, err := UTXO.BlockChain.prevOutputs(&tx)
+		if err != nil {
+			return nil, err
+		}
+		signatures := make([]map[string][]byte, len(tx.Inputs))
+		for i := range signatures {
+			signatures[i] = make(map[string][]byte)
+		}
+		return &MultiSigTx{tx, redeem, prevTXs, signatures}, nil
+	}
+}
+
+// Sign adds the signatures of priKey to every input.
+func (mtx *MultiSigTx) Sign(priKey crypto.Signer) error {
+	pubKey := wallet.SerializePublicKey(priKey.Public())
+	_, pubKeys, ok := script.ExtractMultiSig(mtx.RedeemScript)
+	if !ok {
+		return ErrNotMultiSig
+	}
+	known := false
+	for _, key := range pubKeys {
+		known = known || bytes.Equal(key, pubKey)
+	}
+	if !known {
+		return ErrNotMultiSigSigner
+	}
+
+	for inIdx, in := range mtx.Tx.Inputs {
+		prevOut, ok := mtx.PrevTXs[hex.EncodeToString(in.ID)].Outputs[in.Out]
+		if !ok {
+			return fmt.Errorf("input %d spends an unknown output", inIdx)
+		}
+		signature, err := mtx.Tx.signInput(priKey, inIdx, prevOut.LockingScript, SigHashAll)
+		if err != nil {
+			return err
+		}
+		mtx.Signatures[inIdx][hex.EncodeToString(pubKey)] = signature
+	}
+	return nil
+}
+
+// SignatureCount returns the number of signatures collected for every input
+// and the number required.
+func (mtx *MultiSigTx) SignatureCount() (int, int) {
+	m, _, _ := script.ExtractMultiSig(mtx.RedeemScript)
+	collected := -1
+	for _, sigs := range mtx.Signatures {
+		if collected == -1 || len(sigs) < collected {
+			collected = len(sigs)
+		}
+	}
+	return collected, m
+}
+
+// Finalize builds the unlocking scripts from the collected signatures and
+// returns the transaction ready to be broadcast.
+func (mtx *MultiSigTx) Finalize() (*Transaction, error) {
+	m, pubKeys, ok := script.ExtractMultiSig(mtx.RedeemScript)
+	if !ok {
+		return nil, ErrNotMultiSig
+	}
+	tx := mtx.Tx
+	tx.Inputs = append([]TxInput{}, mtx.Tx.Inputs...)
+	for inIdx := range tx.Inputs {
+		// OP_CHECKMULTISIG expects the signatures in the order of the keys.
+		var sigs [][]byte
+		for _, pubKey := range pubKeys {
+			if sig, ok := mtx.Signatures[inIdx][hex.EncodeToString(pubKey)]; ok && len(sigs) < m {
+				sigs = append(sigs, sig)
+			}
+		}
+		if len(sigs) < m {
+			return nil, fmt.Errorf("input %d has %d of %d signatures", inIdx, len(sigs), m)
+		}
+		tx.Inputs[inIdx].UnlockingScript = script.ScriptHashUnlock(sigs, mtx.RedeemScript)
+	}
+	tx.ID = tx.Hash()
+	if err := tx.VerifyScripts(mtx.PrevTXs); err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
+
+// Serialize encodes the transaction in the file format passed between the
+// signers, see MultiSigTx.encode.
+func (mtx *MultiSigTx) Serialize() []byte {
+	var buffer bytes.Buffer
+	mtx.encode(&buffer)
+	return buffer.Bytes()
+}
+
+func DeserializeMultiSigTx(data []byte) (*MultiSigTx, error) {
+	d := newDecoder(data)
+	mtx := d.multiSigTx()
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	return mtx, nil
+}
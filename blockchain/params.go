@@ -0,0 +1,151 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/leetcode-golang-classroom/golang-blockchain/script"
+	"github.com/leetcode-golang-classroom/golang-blockchain/wallet"
+)
+
+const paramsFile = "./tmp/params_%s.json"
+
+// GenesisAllocation pays Amount to Address in the genesis block.
+type GenesisAllocation struct {
+	Address string `json:"address"`
+	Amount  int    `json:"amount"`
+}
+
+// ChainParams holds everything that distinguishes one chain from another.
+// Nodes created from the same genesis file derive the same genesis block.
+type ChainParams struct {
+	// NetworkMagic prefixes every network message so that nodes of
+	// different chains ignore each other.
+	NetworkMagic uint32 `json:"networkMagic"`
+	// GenesisMessage is the coinbase data of the genesis block.
+	GenesisMessage string `json:"genesisMessage"`
+	// GenesisTimestamp is the genesis block time; 0 uses the creation time.
+	GenesisTimestamp int64               `json:"genesisTimestamp"`
+	Allocations      []GenesisAllocation `json:"allocations"`
+	// InitialDifficulty is the number of leading zero bits required of the
+	// genesis block and of every block until the first retarget.
+	InitialDifficulty int `json:"initialDifficulty"`
+	// TargetBlockTime is the desired number of seconds between blocks.
+	TargetBlockTime int64 `json:"targetBlockTime"`
+	// RetargetInterval is the number of blocks between difficulty adjustments.
+	RetargetInterval int `json:"retargetInterval"`
+	// BlockReward is the subsidy of a block before the first halving.
+	BlockReward int `json:"blockReward"`
+	// HalvingInterval is the number of blocks after which the subsidy halves.
+	HalvingInterval int `json:"halvingInterval"`
+	// MaxSupply caps the number of coins that can ever be issued.
+	MaxSupply int `json:"maxSupply"`
+	// CoinbaseMaturity is the number of blocks a coinbase output waits
+	// before it may be spent, so that a reorg cannot take away coins that
+	// were passed on already. The allocations of the genesis block are
+	// spendable at once.
+	CoinbaseMaturity int `json:"coinbaseMaturity"`
+	// MaxDataCarrierSize bounds the payload of an unspendable data output.
+	MaxDataCarrierSize int `json:"maxDataCarrierSize"`
+	// AddressVersion is the first byte of every P-256 key address.
+	AddressVersion byte `json:"addressVersion"`
+	// Ed25519AddressVersion is the first byte of every Ed25519 key address.
+	Ed25519AddressVersion byte `json:"ed25519AddressVersion"`
+	// ScriptAddressVersion is the first byte of every script hash address.
+	ScriptAddressVersion byte            `json:"scriptAddressVersion"`
+	SeedPeers            []string        `json:"seedPeers"`
+	Consensus            ConsensusConfig `json:"consensus"`
+}
+
+func DefaultChainParams() *ChainParams {
+	return &ChainParams{
+		NetworkMagic:          0x626c6b63,
+		GenesisMessage:        "First Trransaction from Genesis",
+		InitialDifficulty:     18,
+		TargetBlockTime:       10,
+		RetargetInterval:      10,
+		BlockReward:           20,
+		HalvingInterval:       210000,
+		MaxSupply:             7980000,
+		CoinbaseMaturity:      100,
+		MaxDataCarrierSize:    80,
+		AddressVersion:        0x00,
+		Ed25519AddressVersion: 0x0f,
+		ScriptAddressVersion:  0x05,
+		SeedPeers:             []string{"localhost:3000"},
+		Consensus:             ConsensusConfig{Engine: "pow"},
+	}
+}
+
+// ReadChainParams reads a genesis file. Fields missing from the file keep
+// their default values.
+func ReadChainParams(file string) (*ChainParams, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	params := DefaultChainParams()
+	if err := json.Unmarshal(data, params); err != nil {
+		return nil, fmt.Errorf("Parsing %s: %w", file, err)
+	}
+	return params, params.Validate()
+}
+
+// LoadChainParams returns the parameters the node's chain was created with,
+// or the defaults when the node has none saved.
+func LoadChainParams(nodeId string) (*ChainParams, error) {
+	file := fmt.Sprintf(paramsFile, nodeId)
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return DefaultChainParams(), nil
+	}
+	return ReadChainParams(file)
+}
+
+func (p *ChainParams) Save(nodeId string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fmt.Sprintf(paramsFile, nodeId), data, 0644)
+}
+
+func (p *ChainParams) Validate() error {
+	switch {
+	case p.InitialDifficulty < 1 || p.InitialDifficulty > MaxDifficulty:
+		return fmt.Errorf("initialDifficulty must be between 1 and %d", MaxDifficulty)
+	case p.TargetBlockTime < 1:
+		return errors.New("targetBlockTime must be positive")
+	case p.BlockReward < 0 || p.MaxSupply < 0:
+		return errors.New("blockReward and maxSupply may not be negative")
+	case p.HalvingInterval < 1:
+		return errors.New("halvingInterval must be positive")
+	case p.CoinbaseMaturity < 0:
+		return errors.New("coinbaseMaturity may not be negative")
+	case p.MaxDataCarrierSize < 0 || p.MaxDataCarrierSize > script.MaxElementSize:
+		return fmt.Errorf("maxDataCarrierSize must be between 0 and %d", script.MaxElementSize)
+	case p.AddressVersion == p.ScriptAddressVersion || p.AddressVersion == p.Ed25519AddressVersion ||
+		p.Ed25519AddressVersion == p.ScriptAddressVersion:
+		return errors.New("addressVersion, ed25519AddressVersion and scriptAddressVersion must differ")
+	case len(p.SeedPeers) == 0:
+		return errors.New("at least one seed peer is required")
+	case len(p.GenesisMessage) > MaxTagLength:
+		return fmt.Errorf("genesisMessage may not be longer than %d bytes", MaxTagLength)
+	}
+	total := 0
+	for _, alloc := range p.Allocations {
+		if !wallet.ValidateAddressVersion(alloc.Address, p.AddressVersion, p.Ed25519AddressVersion, p.ScriptAddressVersion) {
+			return fmt.Errorf("allocation address %s is not valid", alloc.Address)
+		}
+		if alloc.Amount <= 0 {
+			return fmt.Errorf("allocation to %s must be positive", alloc.Address)
+		}
+		var err error
+		if total, err = addValue(total, alloc.Amount, p.MaxSupply); err != nil {
+			return fmt.Errorf("allocations exceed maxSupply %d", p.MaxSupply)
+		}
+	}
+	return nil
+}
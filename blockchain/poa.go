@@ -0,0 +1,75 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/leetcode-golang-classroom/golang-blockchain/wallet"
+)
+
+var (
+	ErrNotInTurn        = errors.New("Signer is not the validator in turn")
+	ErrUnauthorizedSeal = errors.New("Block is not signed by the validator in turn")
+	ErrInvalidSeal      = errors.New("Block signature is invalid")
+)
+
+// ProofOfAuthority lets a fixed set of validators take turns signing blocks:
+// the block at height h must be signed by Validators[h % len(Validators)].
+// Every block has difficulty 1, so the longest chain has the most work.
+type ProofOfAuthority struct {
+	// Validators holds the public key hashes of the validators.
+	Validators [][]byte
+	// Signer is the local validator key used by Seal.
+	Signer *wallet.Wallet
+}
+
+func NewProofOfAuthority(addresses []string) (*ProofOfAuthority, error) {
+	if len(addresses) == 0 {
+		return nil, errors.New("Proof of authority needs at least one validator")
+	}
+	poa := &ProofOfAuthority{}
+	for _, address := range addresses {
+		if !wallet.ValidateAddress(address) || wallet.IsScriptAddress(address) {
+			return nil, fmt.Errorf("Validator address %s is not valid", address)
+		}
+		pubKeyHash := wallet.Base58Decode([]byte(address))
+		poa.Validators = append(poa.Validators, pubKeyHash[1:len(pubKeyHash)-4])
+	}
+	return poa, nil
+}
+
+func (poa *ProofOfAuthority) inTurn(height int) []byte {
+	return poa.Validators[height%len(poa.Validators)]
+}
+
+// Seal signs the header with the local validator key. The genesis block is
+// trusted as it is and is left unsigned.
+func (poa *ProofOfAuthority) Seal(ctx context.Context, block *Block) error {
+	if block.Height == 0 {
+		block.Hash = block.BlockHeader.Hash()
+		return nil
+	}
+	if poa.Signer == nil || !bytes.Equal(wallet.PublicKeyHash(poa.Signer.PublicKey), poa.inTurn(block.Height)) {
+		return ErrNotInTurn
+	}
+	block.Signer = poa.Signer.PublicKey
+	block.Signature = wallet.Sign(poa.Signer.PrivateKey, block.SealHash())
+	block.Hash = block.BlockHeader.Hash()
+	return nil
+}
+
+func (poa *ProofOfAuthority) VerifyHeader(header *BlockHeader) error {
+	if !bytes.Equal(wallet.PublicKeyHash(header.Signer), poa.inTurn(header.Height)) {
+		return ErrUnauthorizedSeal
+	}
+	if !wallet.VerifySignature(header.Signer, header.SealHash(), header.Signature) {
+		return ErrInvalidSeal
+	}
+	return nil
+}
+
+func (poa *ProofOfAuthority) CalcDifficulty(chain *BlockChain, parent *Block) (int, error) {
+	return 1, nil
+}
@@ -0,0 +1,106 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/leetcode-golang-classroom/golang-blockchain/wallet"
+)
+
+func TestProofOfAuthority(t *testing.T) {
+	validators := []*wallet.Wallet{wallet.MakeWallet(), wallet.MakeWallet(), wallet.MakeWallet()}
+	outsider := wallet.MakeWallet()
+	var addresses []string
+	for _, w := range validators {
+		addresses = append(addresses, string(w.Address()))
+	}
+	params := testParams()
+	params.Allocations = []GenesisAllocation{{Address: addresses[0], Amount: 100}}
+	params.Consensus = ConsensusConfig{Engine: "poa", Validators: addresses}
+	chain, _ := newTestChainWithParams(t, params)
+	poa := chain.Engine.(*ProofOfAuthority)
+
+	genesis := tip(t, chain)
+	if len(genesis.Signer) != 0 || len(genesis.Signature) != 0 {
+		t.Errorf("genesis block is signed by %x", genesis.Signer)
+	}
+
+	// every validator seals the blocks of its turn, round robin
+	for height := 1; height <= 2*len(validators); height++ {
+		parent := tip(t, chain)
+		block := &Block{
+			BlockHeader:  BlockHeader{PrevHash: parent.Hash, Timestamp: parent.Timestamp, Height: height, Difficulty: 1},
+			Transactions: []*Transaction{CoinBaseTx(addresses[0], "tag", 0)},
+		}
+		block.MerkleRoot = block.HashTransactions()
+		for i, w := range append(validators, outsider) {
+			poa.Signer = w
+			err := poa.Seal(context.Background(), block)
+			if inTurn := i == height%len(validators); inTurn && err != nil {
+				t.Fatalf("height %d: validator %d in turn: %v", height, i, err)
+			} else if !inTurn && !errors.Is(err, ErrNotInTurn) {
+				t.Errorf("height %d: signer %d out of turn: %v, want %v", height, i, err, ErrNotInTurn)
+			}
+		}
+		poa.Signer = validators[height%len(validators)]
+		if err := poa.Seal(context.Background(), block); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := chain.AddBlock(block); err != nil {
+			t.Fatalf("height %d: %v", height, err)
+		}
+	}
+
+	// headers signed by the wrong key or with a broken signature
+	signed := func(height int, signer *wallet.Wallet) *BlockHeader {
+		header := &BlockHeader{PrevHash: []byte{1}, Height: height, Difficulty: 1, Signer: signer.PublicKey}
+		header.Signature = wallet.Sign(signer.PrivateKey, header.SealHash())
+		return header
+	}
+	tests := []struct {
+		name   string
+		header *BlockHeader
+		want   error
+	}{
+		{"in turn", signed(4, validators[1]), nil},
+		{"validator out of turn", signed(4, validators[2]), ErrUnauthorizedSeal},
+		{"outsider", signed(4, outsider), ErrUnauthorizedSeal},
+		{"unsigned", &BlockHeader{PrevHash: []byte{1}, Height: 4}, ErrUnauthorizedSeal},
+		{"changed after signing", func() *BlockHeader { h := signed(4, validators[1]); h.Timestamp++; return h }(), ErrInvalidSeal},
+		{"signature of another key", func() *BlockHeader {
+			h := signed(4, validators[1])
+			h.Signature = wallet.Sign(outsider.PrivateKey, h.SealHash())
+			return h
+		}(), ErrInvalidSeal},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := poa.VerifyHeader(test.header); !errors.Is(err, test.want) {
+				t.Errorf("VerifyHeader() = %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+func TestNewProofOfAuthority(t *testing.T) {
+	address := string(wallet.MakeWallet().Address())
+	last := byte('2')
+	if address[len(address)-1] == last {
+		last = '3'
+	}
+	tests := []struct {
+		name       string
+		validators []string
+		ok         bool
+	}{
+		{"one validator", []string{address}, true},
+		{"none", nil, false},
+		{"bad checksum", []string{address[:len(address)-1] + string(last)}, false},
+	}
+	for _, test := range tests {
+		if _, err := NewProofOfAuthority(test.validators); (err == nil) != test.ok {
+			t.Errorf("%s: NewProofOfAuthority() = %v", test.name, err)
+		}
+	}
+}
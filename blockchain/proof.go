@@ -2,12 +2,17 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/binary"
-	"fmt"
+	"errors"
 	"log"
 	"math"
 	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Take the data from the block
@@ -21,60 +26,126 @@ import (
 // Requiremenets:
 // The First few bytes must containe 0s
 
-const Difficulty = 18
+// MaxRetargetStep bounds how many bits a single adjustment may move.
+var MaxRetargetStep = 2
+
+// MaxDifficulty is the highest difficulty, a target of 2 leaving a single
+// bit of the hash free.
+const MaxDifficulty = 255
+
+// MaxNonce is the largest nonce Run tries. When it is reached the miner
+// changes the extra nonce of the coinbase and searches again.
+var MaxNonce = math.MaxUint32
+
+// ErrNonceExhausted is returned by Run when no nonce up to MaxNonce meets
+// the target.
+var ErrNonceExhausted = errors.New("Nonce space exhausted")
+
+// MinerWorkers is the number of goroutines Run splits the nonce space across.
+var MinerWorkers = runtime.NumCPU()
+
+// hashBatch is how many nonces a worker tries between checks for
+// cancellation and updates of the shared hash counter.
+const hashBatch = 1024
 
 type ProofOfWork struct {
-	Block  *Block
+	Header *BlockHeader
 	Target *big.Int
+	// Hashes and Elapsed describe the last call to Run.
+	Hashes  uint64
+	Elapsed time.Duration
 }
 
 func (pow *ProofOfWork) Validate() bool {
 	var intHash big.Int
-	data := pow.InitData(pow.Block.Nonce)
+	data := pow.InitData(pow.Header.Nonce)
 	hash := sha256.Sum256(data)
 	intHash.SetBytes(hash[:])
 
 	return intHash.Cmp(pow.Target) == -1
 }
-func (pow *ProofOfWork) Run() (int, []byte) {
-	var intHash big.Int
-	var hash [32]byte
-
-	nonce := 0
-	for nonce < math.MaxInt64 {
-		data := pow.InitData(nonce)
-		hash = sha256.Sum256(data)
-		fmt.Printf("\r%x", hash)
-		intHash.SetBytes(hash[:])
-		if intHash.Cmp(pow.Target) == -1 {
-			break
-		} else {
-			nonce++
+
+// Run searches for a nonce up to MaxNonce that satisfies the target. Worker
+// i tries the nonces i, i+workers, i+2*workers, ... so the workers never
+// overlap. The search stops as soon as one of them succeeds or ctx is done,
+// in which case the context error is returned.
+func (pow *ProofOfWork) Run(ctx context.Context, workers int) (int, []byte, error) {
+	if workers < 1 {
+		workers = 1
+	}
+	type solution struct {
+		nonce int
+		hash  []byte
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	start := time.Now()
+	found := make(chan solution, workers)
+	var hashes uint64
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(nonce int) {
+			defer wg.Done()
+			var intHash big.Int
+			for tried := 0; nonce >= 0 && nonce <= MaxNonce; nonce += workers {
+				hash := sha256.Sum256(pow.InitData(nonce))
+				intHash.SetBytes(hash[:])
+				if intHash.Cmp(pow.Target) == -1 {
+					atomic.AddUint64(&hashes, uint64(tried+1))
+					found <- solution{nonce, hash[:]}
+					cancel()
+					return
+				}
+				if tried++; tried == hashBatch {
+					atomic.AddUint64(&hashes, hashBatch)
+					tried = 0
+					if ctx.Err() != nil {
+						return
+					}
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	pow.Hashes = atomic.LoadUint64(&hashes)
+	pow.Elapsed = time.Since(start)
+
+	select {
+	case sol := <-found:
+		return sol.nonce, sol.hash, nil
+	default:
+		if err := ctx.Err(); err != nil {
+			return 0, nil, err
 		}
+		return 0, nil, ErrNonceExhausted
 	}
-	fmt.Println()
-	return nonce, hash[:]
 }
-func NewProof(b *Block) *ProofOfWork {
+
+// Hashrate returns the hashes per second achieved by the last call to Run.
+func (pow *ProofOfWork) Hashrate() float64 {
+	if pow.Elapsed <= 0 {
+		return 0
+	}
+	return float64(pow.Hashes) / pow.Elapsed.Seconds()
+}
+
+func NewProof(h *BlockHeader) *ProofOfWork {
 	target := big.NewInt(1)
-	target.Lsh(target, uint(256-Difficulty))
+	target.Lsh(target, uint(256-h.Difficulty))
 
-	pow := &ProofOfWork{b, target}
+	pow := &ProofOfWork{Header: h, Target: target}
 
 	return pow
 }
 
+// InitData returns the serialized header with the given nonce, which is
+// exactly the data the block hash is computed over.
 func (pow *ProofOfWork) InitData(nonce int) []byte {
-	data := bytes.Join(
-		[][]byte{
-			pow.Block.PrevHash,
-			pow.Block.HashTransactions(),
-			ToHex(int64(nonce)),
-			ToHex(int64(Difficulty)),
-		},
-		[]byte{},
-	)
-	return data
+	header := *pow.Header
+	header.Nonce = nonce
+	return header.Serialize()
 }
 
 func ToHex(num int64) []byte {
@@ -85,3 +156,28 @@ func ToHex(num int64) []byte {
 	}
 	return buff.Bytes()
 }
+
+// RetargetDifficulty returns the difficulty for the block following last.
+// Every RetargetInterval blocks the time it took to mine the previous
+// interval, measured from first to last, is compared with TargetBlockTime
+// and the difficulty is moved by the rounded log2 of the ratio.
+func (p *ChainParams) RetargetDifficulty(first, last *Block) int {
+	expected := p.TargetBlockTime * int64(last.Height-first.Height)
+	actual := last.Timestamp - first.Timestamp
+	if actual < 1 {
+		actual = 1
+	}
+	step := int(math.Round(math.Log2(float64(expected) / float64(actual))))
+	if step > MaxRetargetStep {
+		step = MaxRetargetStep
+	} else if step < -MaxRetargetStep {
+		step = -MaxRetargetStep
+	}
+	difficulty := last.Difficulty + step
+	if difficulty < 1 {
+		difficulty = 1
+	} else if difficulty > MaxDifficulty {
+		difficulty = MaxDifficulty
+	}
+	return difficulty
+}
@@ -0,0 +1,108 @@
+package blockchain
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/leetcode-golang-classroom/golang-blockchain/wallet"
+)
+
+func TestRetargetDifficulty(t *testing.T) {
+	params := DefaultChainParams()
+	params.TargetBlockTime = 10
+	tests := []struct {
+		name       string
+		difficulty int
+		elapsed    int64
+		want       int
+	}{
+		{"on time", 20, 100, 20},
+		{"twice as fast", 20, 50, 21},
+		{"four times as fast", 20, 25, 22},
+		{"step limit up", 20, 1, 22},
+		{"no time at all", 20, 0, 22},
+		{"twice as slow", 20, 200, 19},
+		{"step limit down", 20, 100000, 18},
+		{"lowest", 2, 100000, 1},
+		{"highest", MaxDifficulty - 1, 1, MaxDifficulty},
+		{"above the highest", MaxDifficulty, 1, MaxDifficulty},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			first := &Block{BlockHeader: BlockHeader{Timestamp: 1000, Height: 10}}
+			last := &Block{BlockHeader: BlockHeader{Timestamp: 1000 + test.elapsed, Height: 20, Difficulty: test.difficulty}}
+			if got := params.RetargetDifficulty(first, last); got != test.want {
+				t.Errorf("RetargetDifficulty() = %d, want %d", got, test.want)
+			}
+			if got := NewProof(&BlockHeader{Difficulty: params.RetargetDifficulty(first, last)}).Target; got.Sign() <= 0 {
+				t.Errorf("target %v is not positive", got)
+			}
+		})
+	}
+}
+
+// TestCalcDifficulty mines the first retarget interval with blocks gap
+// seconds apart and checks the difficulty on both sides of the boundary.
+func TestCalcDifficulty(t *testing.T) {
+	const interval = 4
+	tests := []struct {
+		name    string
+		initial int
+		gap     int64
+		want    int
+	}{
+		{"on time", 4, 10, 4},
+		{"twice as fast", 4, 5, 5},
+		{"step limit up", 4, 1, 6},
+		{"twice as slow", 4, 20, 3},
+		{"step limit down", 4, 200, 2},
+		{"lowest", 1, 200, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := wallet.MakeWallet()
+			params := testParams()
+			params.InitialDifficulty = test.initial
+			params.RetargetInterval = interval
+			params.TargetBlockTime = 10
+			params.GenesisTimestamp = time.Now().Unix() - 10000
+			params.Allocations = []GenesisAllocation{{Address: string(w.Address()), Amount: 100}}
+			chain, _ := newTestChainWithParams(t, params)
+
+			for height := 1; height < interval; height++ {
+				parent := tip(t, chain)
+				difficulty, err := chain.Engine.CalcDifficulty(chain, parent)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if difficulty != test.initial {
+					t.Errorf("height %d: difficulty %d before the boundary, want %d", height, difficulty, test.initial)
+				}
+				block := &Block{
+					BlockHeader: BlockHeader{
+						PrevHash:   parent.Hash,
+						Timestamp:  params.GenesisTimestamp + int64(height)*test.gap,
+						Height:     height,
+						Difficulty: difficulty,
+					},
+					Transactions: []*Transaction{CoinBaseTx(string(w.Address()), "tag", 0)},
+				}
+				block.MerkleRoot = block.HashTransactions()
+				if err := chain.Engine.Seal(context.Background(), block); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := chain.AddBlock(block); err != nil {
+					t.Fatalf("height %d: %v", height, err)
+				}
+			}
+			difficulty, err := chain.Engine.CalcDifficulty(chain, tip(t, chain))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if difficulty != test.want {
+				t.Errorf("difficulty %d at height %d, want %d", difficulty, interval, test.want)
+			}
+		})
+	}
+}
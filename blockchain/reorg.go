@@ -0,0 +1,238 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/dgraph-io/badger"
+)
+
+var (
+	metaPrefix = []byte("meta-")
+	undoPrefix = []byte("undo-")
+)
+
+// ErrOrphanBlock is returned by AddBlock when the parent of the block is not
+// known yet.
+var ErrOrphanBlock = errors.New("Parent block is not found")
+
+// Reorg tells how adding a block changed the active chain. Disconnected
+// holds the blocks of the old branch, old tip first, and Connected those of
+// the new branch, common ancestor first. Both are empty when the block was
+// only stored on a side branch.
+type Reorg struct {
+	Disconnected []*Block
+	Connected    []*Block
+}
+
+// Resurrected returns the transactions of the disconnected blocks that are
+// not confirmed again by the connected ones, coinbases left out, in the
+// order they were mined.
+func (r *Reorg) Resurrected() []*Transaction {
+	connected := make(map[string]bool)
+	for _, b := range r.Connected {
+		for _, tx := range b.Transactions {
+			connected[string(tx.ID)] = true
+		}
+	}
+	var resurrected []*Transaction
+	for i := len(r.Disconnected) - 1; i >= 0; i-- {
+		for _, tx := range r.Disconnected[i].Transactions {
+			if !tx.IsCoinbase() && !connected[string(tx.ID)] {
+				resurrected = append(resurrected, tx)
+			}
+		}
+	}
+	return resurrected
+}
+
+// blockMeta is stored next to every block and records the total work of the
+// branch ending in that block. Supply is the number of coins issued up to and
+// including the block and is filled in when the block is connected.
+type blockMeta struct {
+	Work   *big.Int
+	Supply int
+}
+
+// Work returns the expected number of hashes needed to find the header,
+// which is 2^Difficulty for a target of 2^(256-Difficulty).
+func (h *BlockHeader) Work() *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(h.Difficulty))
+}
+
+func metaKey(hash []byte) []byte {
+	return append(append([]byte{}, metaPrefix...), hash...)
+}
+
+func undoKey(hash []byte) []byte {
+	return append(append([]byte{}, undoPrefix...), hash...)
+}
+
+func getBlockMeta(txn *badger.Txn, hash []byte) (*blockMeta, error) {
+	item, err := txn.Get(metaKey(hash))
+	if err != nil {
+		return nil, err
+	}
+	v, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodeBlockMeta(v)
+}
+
+func putBlockMeta(txn *badger.Txn, hash []byte, meta *blockMeta) error {
+	return txn.Set(metaKey(hash), meta.encode())
+}
+
+func getBlock(txn *badger.Txn, hash []byte) (*Block, error) {
+	item, err := txn.Get(hash)
+	if err != nil {
+		return nil, err
+	}
+	v, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, err
+	}
+	return Deserialize(v), nil
+}
+
+func getTip(txn *badger.Txn) ([]byte, error) {
+	item, err := txn.Get([]byte("lh"))
+	if err != nil {
+		return nil, err
+	}
+	return item.ValueCopy(nil)
+}
+
+// storeBlock saves the block together with the cumulative work of its
+// branch, which is returned.
+func (chain *BlockChain) storeBlock(txn *badger.Txn, block *Block) (*big.Int, error) {
+	work := block.Work()
+	if len(block.PrevHash) != 0 {
+		parent, err := getBlockMeta(txn, block.PrevHash)
+		if err == badger.ErrKeyNotFound {
+			return nil, ErrOrphanBlock
+		} else if err != nil {
+			return nil, err
+		}
+		work.Add(work, parent.Work)
+	}
+	if err := putBlockMeta(txn, block.Hash, &blockMeta{Work: work}); err != nil {
+		return nil, err
+	}
+	return work, txn.Set(block.Hash, block.Serialize())
+}
+
+// connectBlock validates the transactions of the block against the UTXO set,
+// applies them and keeps the spent outputs as undo data for disconnectBlock.
+func (chain *BlockChain) connectBlock(txn *badger.Txn, block *Block) error {
+	meta, err := getBlockMeta(txn, block.Hash)
+	if err != nil {
+		return err
+	}
+	if len(block.PrevHash) == 0 {
+		if meta.Supply, err = block.Transactions[0].outputValue(chain.Params.MaxSupply); err != nil {
+			return err
+		}
+	} else {
+		parent, err := getBlock(txn, block.PrevHash)
+		if err != nil {
+			return err
+		}
+		fees, err := chain.checkTransactions(txn, parent, block.Transactions)
+		if err != nil {
+			return err
+		}
+		issued, supply, err := chain.checkSubsidy(txn, &block.BlockHeader, block.Transactions[0], fees)
+		if err != nil {
+			return err
+		}
+		meta.Supply = supply + issued
+	}
+	if err := putBlockMeta(txn, block.Hash, meta); err != nil {
+		return err
+	}
+	UTXO := UTXOSet{chain}
+	spent, err := UTXO.Update(txn, block)
+	if err != nil {
+		return err
+	}
+	return txn.Set(undoKey(block.Hash), encodeSpentOutputs(spent))
+}
+
+func (chain *BlockChain) disconnectBlock(txn *badger.Txn, block *Block) error {
+	item, err := txn.Get(undoKey(block.Hash))
+	if err != nil {
+		return fmt.Errorf("Undo data of block %x: %w", block.Hash, err)
+	}
+	v, err := item.ValueCopy(nil)
+	if err != nil {
+		return err
+	}
+	spent, err := decodeSpentOutputs(v)
+	if err != nil {
+		return err
+	}
+	UTXO := UTXOSet{chain}
+	if err := UTXO.Rollback(txn, block, spent); err != nil {
+		return err
+	}
+	return txn.Delete(undoKey(block.Hash))
+}
+
+// reorganize makes block the tip of the active chain. Blocks of the old
+// branch are disconnected back to the common ancestor, then the new branch is
+// connected from the ancestor up.
+func (chain *BlockChain) reorganize(txn *badger.Txn, tipHash []byte, block *Block) (*Reorg, error) {
+	oldBlock, err := getBlock(txn, tipHash)
+	if err != nil {
+		return nil, err
+	}
+	newBlock := block
+	var detach, attach []*Block
+
+	parent := func(b *Block) (*Block, error) {
+		if len(b.PrevHash) == 0 {
+			return nil, fmt.Errorf("Block %x shares no ancestor with the active chain", block.Hash)
+		}
+		return getBlock(txn, b.PrevHash)
+	}
+	for newBlock.Height > oldBlock.Height {
+		attach = append(attach, newBlock)
+		if newBlock, err = parent(newBlock); err != nil {
+			return nil, err
+		}
+	}
+	for oldBlock.Height > newBlock.Height {
+		detach = append(detach, oldBlock)
+		if oldBlock, err = parent(oldBlock); err != nil {
+			return nil, err
+		}
+	}
+	for !bytes.Equal(oldBlock.Hash, newBlock.Hash) {
+		detach = append(detach, oldBlock)
+		attach = append(attach, newBlock)
+		if oldBlock, err = parent(oldBlock); err != nil {
+			return nil, err
+		}
+		if newBlock, err = parent(newBlock); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, b := range detach {
+		if err := chain.disconnectBlock(txn, b); err != nil {
+			return nil, err
+		}
+	}
+	reorg := &Reorg{Disconnected: detach}
+	for i := len(attach) - 1; i >= 0; i-- {
+		if err := chain.connectBlock(txn, attach[i]); err != nil {
+			return nil, fmt.Errorf("Connecting block %x: %w", attach[i].Hash, err)
+		}
+		reorg.Connected = append(reorg.Connected, attach[i])
+	}
+	return reorg, txn.Set([]byte("lh"), block.Hash)
+}
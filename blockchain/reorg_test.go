@@ -0,0 +1,173 @@
+package blockchain
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/leetcode-golang-classroom/golang-blockchain/wallet"
+)
+
+// unspent returns the indexes of the outputs of txID in the UTXO set.
+func unspent(t *testing.T, chain *BlockChain, txID []byte) []int {
+	t.Helper()
+	var outIdxs []int
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		UTXO := UTXOSet{chain}
+		outs, err := UTXO.getOutputs(txn, txID)
+		for outIdx := range outs.Outputs {
+			outIdxs = append(outIdxs, outIdx)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return outIdxs
+}
+
+func blockHashes(blocks []*Block) [][]byte {
+	var hashes [][]byte
+	for _, b := range blocks {
+		hashes = append(hashes, b.Hash)
+	}
+	return hashes
+}
+
+// TestReorganize grows two branches from the genesis block: a holds a
+// payment, b only coinbases. Each branch takes over once it carries more
+// work than the other.
+func TestReorganize(t *testing.T) {
+	w := wallet.MakeWallet()
+	address := string(w.Address())
+	chain, genesisTx := newTestChain(t, w, 100)
+	genesis := tip(t, chain)
+	spend := spendOutput(w, genesisTx, 0, 90, 0)
+	mp := NewMempool(chain)
+
+	add := func(block *Block) *Reorg {
+		t.Helper()
+		reorg, err := chain.AddBlock(block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return reorg
+	}
+	checkReorg := func(reorg *Reorg, disconnected, connected []*Block) {
+		t.Helper()
+		if got, want := blockHashes(reorg.Disconnected), blockHashes(disconnected); !reflect.DeepEqual(got, want) {
+			t.Errorf("disconnected %x, want %x", got, want)
+		}
+		if got, want := blockHashes(reorg.Connected), blockHashes(connected); !reflect.DeepEqual(got, want) {
+			t.Errorf("connected %x, want %x", got, want)
+		}
+		if !bytes.Equal(chain.LastHash, tip(t, chain).Hash) {
+			t.Error("LastHash does not match the stored tip")
+		}
+	}
+
+	a1 := newTestBlock(t, chain, genesis, CoinBaseTx(address, "a", 0), spend)
+	checkReorg(add(a1), nil, []*Block{a1})
+	if got := unspent(t, chain, genesisTx.ID); len(got) != 0 {
+		t.Errorf("genesis outputs %v are unspent after a1", got)
+	}
+
+	// b1 has as much work as a1 and is stored without becoming the tip
+	b1 := newTestBlock(t, chain, genesis, CoinBaseTx(address, "b", 0))
+	checkReorg(add(b1), nil, nil)
+	if !bytes.Equal(chain.LastHash, a1.Hash) {
+		t.Fatalf("tip %x, want a1 %x", chain.LastHash, a1.Hash)
+	}
+	if _, err := chain.GetBlock(b1.Hash); err != nil {
+		t.Errorf("b1 was not stored: %v", err)
+	}
+	if got := unspent(t, chain, b1.Transactions[0].ID); len(got) != 0 {
+		t.Errorf("side branch coinbase outputs %v are unspent", got)
+	}
+
+	// b2 makes b heavier: a1 is disconnected and its payment returns to
+	// the mempool
+	b2 := newTestBlock(t, chain, b1, CoinBaseTx(address, "b", 0))
+	reorg := add(b2)
+	checkReorg(reorg, []*Block{a1}, []*Block{b1, b2})
+	if got := unspent(t, chain, genesisTx.ID); !reflect.DeepEqual(got, []int{0}) {
+		t.Errorf("genesis outputs %v are unspent, want the one restored from undo data", got)
+	}
+	for _, tx := range []*Transaction{spend, a1.Transactions[0]} {
+		if got := unspent(t, chain, tx.ID); len(got) != 0 {
+			t.Errorf("outputs %v of disconnected %x are unspent", got, tx.ID)
+		}
+	}
+	if got := unspent(t, chain, b2.Transactions[0].ID); !reflect.DeepEqual(got, []int{0}) {
+		t.Errorf("outputs %v of the new tip coinbase are unspent, want [0]", got)
+	}
+	resurrected := reorg.Resurrected()
+	if len(resurrected) != 1 || !bytes.Equal(resurrected[0].ID, spend.ID) {
+		t.Fatalf("resurrected %d transactions, want the payment of a1", len(resurrected))
+	}
+	for _, tx := range resurrected {
+		if _, err := mp.Add(tx); err != nil {
+			t.Fatalf("adding resurrected %x to the mempool: %v", tx.ID, err)
+		}
+	}
+
+	// a branch whose block does not connect leaves the chain as it was
+	bad := newTestBlock(t, chain, b2, CoinBaseTx(address, "bad", 0), spendOutput(w, spend, 0, 80, 0))
+	if _, err := chain.AddBlock(bad); err == nil {
+		t.Error("block spending a disconnected output was added")
+	}
+	if !bytes.Equal(chain.LastHash, b2.Hash) {
+		t.Errorf("tip %x, want b2 %x", chain.LastHash, b2.Hash)
+	}
+
+	// a3 makes a heavier again and confirms the payment once more
+	a2 := newTestBlock(t, chain, a1, CoinBaseTx(address, "a", 0))
+	checkReorg(add(a2), nil, nil)
+	a3 := newTestBlock(t, chain, a2, CoinBaseTx(address, "a", 0))
+	reorg = add(a3)
+	checkReorg(reorg, []*Block{b2, b1}, []*Block{a1, a2, a3})
+	if got := reorg.Resurrected(); len(got) != 0 {
+		t.Errorf("resurrected %d transactions of coinbase-only blocks", len(got))
+	}
+	for _, b := range reorg.Connected {
+		mp.RemoveBlock(b)
+	}
+	if mp.Len() != 0 {
+		t.Errorf("mempool holds %d transactions after the payment was confirmed again", mp.Len())
+	}
+	if got := unspent(t, chain, spend.ID); !reflect.DeepEqual(got, []int{0}) {
+		t.Errorf("payment outputs %v are unspent, want [0]", got)
+	}
+	if got := unspent(t, chain, b1.Transactions[0].ID); len(got) != 0 {
+		t.Errorf("outputs %v of disconnected b1 are unspent", got)
+	}
+}
+
+func TestResurrected(t *testing.T) {
+	coinbase := newCoinbase([]TxOutput{{Value: 1}}, 0, "")
+	tx1, tx2, tx3 := testTransaction(), testTransaction(), testTransaction()
+	tx2.LockTime, tx3.LockTime = 2, 3
+	tx2.ID, tx3.ID = tx2.Hash(), tx3.Hash()
+	block := func(txs ...*Transaction) *Block {
+		return &Block{Transactions: append([]*Transaction{coinbase}, txs...)}
+	}
+
+	tests := []struct {
+		name  string
+		reorg Reorg
+		want  []*Transaction
+	}{
+		{"side branch", Reorg{}, nil},
+		{"coinbases only", Reorg{[]*Block{block()}, []*Block{block()}}, nil},
+		{"oldest block first", Reorg{[]*Block{block(tx3), block(tx1, tx2)}, nil}, []*Transaction{tx1, tx2, tx3}},
+		{"confirmed again", Reorg{[]*Block{block(tx2), block(tx1)}, []*Block{block(tx1)}}, []*Transaction{tx2}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.reorg.Resurrected(); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Resurrected() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/leetcode-golang-classroom/golang-blockchain/script"
+	"github.com/leetcode-golang-classroom/golang-blockchain/wallet"
+)
+
+// SigHashType selects the parts of a transaction a signature covers. It is
+// appended to every signature as one byte.
+type SigHashType byte
+
+const (
+	// SigHashAll covers every input and output.
+	SigHashAll SigHashType = 0x01
+	// SigHashNone covers the inputs but no output, so that anyone may
+	// decide where the coins go.
+	SigHashNone SigHashType = 0x02
+	// SigHashSingle covers the inputs and only the output with the same
+	// index as the signed input.
+	SigHashSingle SigHashType = 0x03
+	// SigHashAnyoneCanPay may be combined with the others to cover only the
+	// signed input, so that anyone may add inputs of their own.
+	SigHashAnyoneCanPay SigHashType = 0x80
+
+	sigHashMask = 0x1f
+)
+
+var (
+	ErrBadSigHashType = errors.New("Unknown signature hash type")
+	ErrSigHashSingle  = errors.New("SigHashSingle input has no output with the same index")
+)
+
+var sigHashNames = map[SigHashType]string{
+	SigHashAll:    "ALL",
+	SigHashNone:   "NONE",
+	SigHashSingle: "SINGLE",
+}
+
+func (t SigHashType) String() string {
+	name, ok := sigHashNames[t&sigHashMask]
+	if !ok || t&^(sigHashMask|SigHashAnyoneCanPay) != 0 {
+		return fmt.Sprintf("SigHashType(%#x)", byte(t))
+	}
+	if t&SigHashAnyoneCanPay != 0 {
+		name += "|ANYONECANPAY"
+	}
+	return name
+}
+
+func (t SigHashType) valid() bool {
+	_, ok := sigHashNames[t&sigHashMask]
+	return ok && t&^(sigHashMask|SigHashAnyoneCanPay) == 0
+}
+
+// SigHash returns the message signed for input inIdx: the transaction with
+// every unlocking script removed, except the one of inIdx which is replaced
+// by the locking script of the output it spends, and trimmed further as
+// hashType asks for. hashType is hashed too, so a signature cannot be
+// reused with another type.
+func (tx *Transaction) SigHash(inIdx int, lockingScript script.Script, hashType SigHashType) ([]byte, error) {
+	if !hashType.valid() {
+		return nil, ErrBadSigHashType
+	}
+	txCopy := tx.TrimmedCopy()
+	txCopy.Inputs[inIdx].UnlockingScript = lockingScript
+
+	switch hashType & sigHashMask {
+	case SigHashNone:
+		txCopy.Outputs = nil
+		txCopy.clearSequences(inIdx)
+	case SigHashSingle:
+		if inIdx >= len(txCopy.Outputs) {
+			return nil, ErrSigHashSingle
+		}
+		// the outputs before inIdx keep their place but not their content
+		txCopy.Outputs = txCopy.Outputs[:inIdx+1]
+		for i := 0; i < inIdx; i++ {
+			txCopy.Outputs[i] = TxOutput{-1, nil}
+		}
+		txCopy.clearSequences(inIdx)
+	}
+	if hashType&SigHashAnyoneCanPay != 0 {
+		txCopy.Inputs = txCopy.Inputs[inIdx : inIdx+1]
+	}
+
+	var buff bytes.Buffer
+	buff.Write(txCopy.Serialize())
+	writeUint32(&buff, uint32(hashType))
+	hash := sha256.Sum256(buff.Bytes())
+	return hash[:], nil
+}
+
+// clearSequences zeroes the sequence of every input but inIdx, so that the
+// other signers may still change them.
+func (tx *Transaction) clearSequences(inIdx int) {
+	for i := range tx.Inputs {
+		if i != inIdx {
+			tx.Inputs[i].Sequence = 0
+		}
+	}
+}
+
+// signInput returns the signature of priKey over input inIdx followed by
+// hashType.
+func (tx *Transaction) signInput(priKey crypto.Signer, inIdx int, lockingScript script.Script, hashType SigHashType) ([]byte, error) {
+	hash, err := tx.SigHash(inIdx, lockingScript, hashType)
+	if err != nil {
+		return nil, err
+	}
+	return append(wallet.Sign(priKey, hash), byte(hashType)), nil
+}
+
+// splitSignature separates a signature from its hash type.
+func splitSignature(signature []byte) ([]byte, SigHashType, bool) {
+	if len(signature) == 0 {
+		return nil, 0, false
+	}
+	return signature[:len(signature)-1], SigHashType(signature[len(signature)-1]), true
+}
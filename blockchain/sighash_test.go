@@ -0,0 +1,103 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/leetcode-golang-classroom/golang-blockchain/script"
+)
+
+func sigHashTestTx() *Transaction {
+	tx := &Transaction{LockTime: 10}
+	for i := byte(0); i < 3; i++ {
+		tx.Inputs = append(tx.Inputs, TxInput{bytes.Repeat([]byte{i + 1}, 32), int(i), []byte{0x51}, uint32(i) + 5})
+		tx.Outputs = append(tx.Outputs, TxOutput{10 * (int(i) + 1), script.PayToPubKeyHash(bytes.Repeat([]byte{i}, 20))})
+	}
+	return tx
+}
+
+// TestSigHashCoverage changes one part of a transaction at a time and checks
+// whether the digest of input 1 changes, for every hash type.
+func TestSigHashCoverage(t *testing.T) {
+	const inIdx = 1
+	lockingScript := script.PayToPubKeyHash(bytes.Repeat([]byte{9}, 20))
+	mutations := []struct {
+		name   string
+		mutate func(tx *Transaction)
+	}{
+		{"other unlocking script", func(tx *Transaction) { tx.Inputs[0].UnlockingScript = []byte{0x52} }},
+		{"other sequence", func(tx *Transaction) { tx.Inputs[0].Sequence++ }},
+		{"other previous output", func(tx *Transaction) { tx.Inputs[2].Out++ }},
+		{"added input", func(tx *Transaction) { tx.Inputs = append(tx.Inputs, tx.Inputs[0]) }},
+		{"own sequence", func(tx *Transaction) { tx.Inputs[inIdx].Sequence++ }},
+		{"earlier output", func(tx *Transaction) { tx.Outputs[0].Value++ }},
+		{"same index output", func(tx *Transaction) { tx.Outputs[inIdx].Value++ }},
+		{"later output", func(tx *Transaction) { tx.Outputs[2].Value++ }},
+		{"added output", func(tx *Transaction) { tx.Outputs = append(tx.Outputs, tx.Outputs[0]) }},
+		{"lock time", func(tx *Transaction) { tx.LockTime++ }},
+	}
+	// covered lists, per hash type, whether each mutation changes the digest
+	tests := []struct {
+		hashType SigHashType
+		covered  []bool
+	}{
+		{SigHashAll, []bool{false, true, true, true, true, true, true, true, true, true}},
+		{SigHashNone, []bool{false, false, true, true, true, false, false, false, false, true}},
+		{SigHashSingle, []bool{false, false, true, true, true, false, true, false, false, true}},
+		{SigHashAll | SigHashAnyoneCanPay, []bool{false, false, false, false, true, true, true, true, true, true}},
+		{SigHashNone | SigHashAnyoneCanPay, []bool{false, false, false, false, true, false, false, false, false, true}},
+		{SigHashSingle | SigHashAnyoneCanPay, []bool{false, false, false, false, true, false, true, false, false, true}},
+	}
+	digests := make(map[string]SigHashType)
+	for _, test := range tests {
+		t.Run(test.hashType.String(), func(t *testing.T) {
+			base, err := sigHashTestTx().SigHash(inIdx, lockingScript, test.hashType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if other, ok := digests[string(base)]; ok {
+				t.Errorf("digest equals the one of %s", other)
+			}
+			digests[string(base)] = test.hashType
+			for i, m := range mutations {
+				tx := sigHashTestTx()
+				m.mutate(tx)
+				hash, err := tx.SigHash(inIdx, lockingScript, test.hashType)
+				if err != nil {
+					t.Fatalf("%s: %v", m.name, err)
+				}
+				if changed := !bytes.Equal(hash, base); changed != test.covered[i] {
+					t.Errorf("%s: digest changed %t, want %t", m.name, changed, test.covered[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSigHashErrors(t *testing.T) {
+	tx := sigHashTestTx()
+	tx.Outputs = tx.Outputs[:1]
+	tests := []struct {
+		name     string
+		inIdx    int
+		hashType SigHashType
+		want     error
+	}{
+		{"single with an output", 0, SigHashSingle, nil},
+		{"single without output", 1, SigHashSingle, ErrSigHashSingle},
+		{"single anyonecanpay without output", 2, SigHashSingle | SigHashAnyoneCanPay, ErrSigHashSingle},
+		{"none without output", 2, SigHashNone, nil},
+		{"zero", 0, 0, ErrBadSigHashType},
+		{"unknown base type", 0, 0x04, ErrBadSigHashType},
+		{"unknown flag", 0, SigHashAll | 0x40, ErrBadSigHashType},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := tx.SigHash(test.inIdx, nil, test.hashType)
+			if !errors.Is(err, test.want) {
+				t.Errorf("SigHash(%d, %s) = %v, want %v", test.inIdx, test.hashType, err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package blockchain
+
+import "github.com/dgraph-io/badger"
+
+// Subsidy returns the coins a block at the given height may create,
+// ignoring the supply cap.
+func (p *ChainParams) Subsidy(height int) int {
+	halvings := height / p.HalvingInterval
+	if halvings >= 63 {
+		return 0
+	}
+	return p.BlockReward >> uint(halvings)
+}
+
+// AvailableSubsidy returns the subsidy of a block at the given height when
+// supply coins have already been issued, so that MaxSupply is never exceeded.
+func (p *ChainParams) AvailableSubsidy(height, supply int) int {
+	subsidy := p.Subsidy(height)
+	if remaining := p.MaxSupply - supply; subsidy > remaining {
+		subsidy = remaining
+	}
+	if subsidy < 0 {
+		return 0
+	}
+	return subsidy
+}
+
+// IsMature reports whether outs may be spent in a block at the given height.
+func (p *ChainParams) IsMature(outs TxOutputs, height int) bool {
+	return !outs.Coinbase || outs.Height == 0 || height-outs.Height >= p.CoinbaseMaturity
+}
+
+// TotalSupply returns the number of coins issued up to the current tip.
+func (chain *BlockChain) TotalSupply() (int, error) {
+	var supply int
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		tipHash, err := getTip(txn)
+		if err != nil {
+			return err
+		}
+		meta, err := getBlockMeta(txn, tipHash)
+		if err != nil {
+			return err
+		}
+		supply = meta.Supply
+		return nil
+	})
+	return supply, err
+}
+
+// NextSubsidy returns the subsidy the coinbase of the next block may claim.
+func (chain *BlockChain) NextSubsidy() (int, error) {
+	supply, err := chain.TotalSupply()
+	if err != nil {
+		return 0, err
+	}
+	return chain.Params.AvailableSubsidy(chain.GetBestHeight()+1, supply), nil
+}
@@ -0,0 +1,210 @@
+package blockchain
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/leetcode-golang-classroom/golang-blockchain/wallet"
+)
+
+func TestSubsidy(t *testing.T) {
+	params := DefaultChainParams()
+	params.BlockReward = 20
+	params.HalvingInterval = 10
+	tests := []struct {
+		height int
+		want   int
+	}{
+		{0, 20},
+		{9, 20},
+		{10, 10},
+		{19, 10},
+		{20, 5},
+		{30, 2},
+		{40, 1},
+		{50, 0},
+		{62 * 10, 0},
+		{63 * 10, 0},
+		{math.MaxInt64, 0},
+	}
+	for _, test := range tests {
+		if got := params.Subsidy(test.height); got != test.want {
+			t.Errorf("Subsidy(%d) = %d, want %d", test.height, got, test.want)
+		}
+	}
+}
+
+func TestAvailableSubsidy(t *testing.T) {
+	params := DefaultChainParams()
+	params.BlockReward = 20
+	params.MaxSupply = 100
+	tests := []struct {
+		supply int
+		want   int
+	}{
+		{0, 20},
+		{80, 20},
+		{81, 19},
+		{99, 1},
+		{100, 0},
+		{101, 0},
+	}
+	for _, test := range tests {
+		if got := params.AvailableSubsidy(1, test.supply); got != test.want {
+			t.Errorf("AvailableSubsidy(1, %d) = %d, want %d", test.supply, got, test.want)
+		}
+	}
+}
+
+// TestSupplyCap mines blocks through two halvings until MaxSupply is
+// reached, checking at every height that the coinbase may claim exactly the
+// available subsidy.
+func TestSupplyCap(t *testing.T) {
+	w := wallet.MakeWallet()
+	address := string(w.Address())
+	params := testParams()
+	params.Allocations = []GenesisAllocation{{Address: address, Amount: 100}}
+	params.BlockReward = 20
+	params.HalvingInterval = 2
+	params.MaxSupply = 150
+	chain, _ := newTestChainWithParams(t, params)
+
+	// heights 1 to 5 are paid 20, 10, 10, 5, 5 and reach the cap exactly
+	tests := []struct {
+		subsidy int
+		supply  int
+	}{
+		{20, 120},
+		{10, 130},
+		{10, 140},
+		{5, 145},
+		{5, 150},
+		{0, 150},
+		{0, 150},
+	}
+	for i, test := range tests {
+		height := i + 1
+		subsidy, err := chain.NextSubsidy()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if subsidy != test.subsidy {
+			t.Errorf("height %d: subsidy %d, want %d", height, subsidy, test.subsidy)
+		}
+		greedy := newTestBlock(t, chain, tip(t, chain), CoinBaseTx(address, "tag", subsidy+1))
+		if err := chain.ValidateBlock(greedy); !errors.Is(err, ErrCoinbaseTooLarge) {
+			t.Errorf("height %d: coinbase above the subsidy: %v, want %v", height, err, ErrCoinbaseTooLarge)
+		}
+		if _, err := chain.AddBlock(newTestBlock(t, chain, tip(t, chain), CoinBaseTx(address, "tag", subsidy))); err != nil {
+			t.Fatalf("height %d: %v", height, err)
+		}
+		supply, err := chain.TotalSupply()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if supply != test.supply {
+			t.Errorf("height %d: supply %d, want %d", height, supply, test.supply)
+		}
+	}
+}
+
+func TestValidateAllocations(t *testing.T) {
+	tests := []struct {
+		name    string
+		amounts []int
+		ok      bool
+	}{
+		{"below max supply", []int{50, 49}, true},
+		{"at max supply", []int{50, 50}, true},
+		{"above max supply", []int{50, 51}, false},
+		{"wrapping total", []int{math.MaxInt64, math.MaxInt64, 2}, false},
+		{"zero", []int{0}, false},
+	}
+	address := string(wallet.MakeWallet().Address())
+	for _, test := range tests {
+		params := DefaultChainParams()
+		params.MaxSupply = 100
+		for _, amount := range test.amounts {
+			params.Allocations = append(params.Allocations, GenesisAllocation{Address: address, Amount: amount})
+		}
+		if err := params.Validate(); (err == nil) != test.ok {
+			t.Errorf("%s: Validate() = %v", test.name, err)
+		}
+	}
+}
+
+func TestValidateAllocationAddress(t *testing.T) {
+	hash := wallet.PublicKeyHash([]byte("key"))
+	tests := []struct {
+		name    string
+		version byte
+		address string
+		ok      bool
+	}{
+		{"default version", 0x00, string(wallet.EncodeAddress(0x00, hash)), true},
+		{"chain version", 0x30, string(wallet.EncodeAddress(0x30, hash)), true},
+		{"other chain", 0x30, string(wallet.EncodeAddress(0x00, hash)), false},
+		{"not an address", 0x00, "not an address", false},
+	}
+	for _, test := range tests {
+		params := DefaultChainParams()
+		params.AddressVersion = test.version
+		params.Allocations = []GenesisAllocation{{Address: test.address, Amount: 1}}
+		if err := params.Validate(); (err == nil) != test.ok {
+			t.Errorf("%s: Validate() = %v", test.name, err)
+		}
+	}
+}
+
+// TestImmatureCoinbase mines a coinbase on a chain whose coinbases wait two
+// blocks and checks that nothing spends it before then, while the genesis
+// allocation is spendable at once.
+func TestImmatureCoinbase(t *testing.T) {
+	w, other := wallet.MakeWallet(), wallet.MakeWallet()
+	address := string(w.Address())
+	params := testParams()
+	params.CoinbaseMaturity = 2
+	params.Allocations = []GenesisAllocation{{Address: address, Amount: 100}}
+	chain, _ := newTestChainWithParams(t, params)
+	UTXO := &UTXOSet{chain}
+	lockingScript := AddressScript(address)
+	mine := func(coinbase *Transaction) {
+		t.Helper()
+		if _, err := chain.AddBlock(newTestBlock(t, chain, tip(t, chain), coinbase)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	coinbase := CoinBaseTx(address, "tag", params.BlockReward)
+	mine(coinbase)
+	spend := spendOutput(w, coinbase, 0, params.BlockReward-1, 0)
+
+	// the next block is at height 2, one block after the coinbase
+	if spendable, immature := UTXO.Balance(lockingScript); spendable != 100 || immature != params.BlockReward {
+		t.Errorf("Balance() = %d, %d, want 100, %d", spendable, immature, params.BlockReward)
+	}
+	if utxos := UTXO.SpendableOutputs(lockingScript); len(utxos) != 1 || utxos[0].Value != 100 {
+		t.Errorf("SpendableOutputs() = %v, want only the genesis allocation", utxos)
+	}
+	if _, _, err := UTXO.FindSpendableOutputs(lockingScript, 101, LargestFirst{}); !errors.Is(err, ErrNotEnoughFunds) {
+		t.Errorf("FindSpendableOutputs(101) = %v, want %v", err, ErrNotEnoughFunds)
+	}
+	if _, err := NewMempool(chain).Add(spend); !errors.Is(err, ErrImmatureCoinbase) {
+		t.Errorf("Mempool.Add() = %v, want %v", err, ErrImmatureCoinbase)
+	}
+	block := newTestBlock(t, chain, tip(t, chain), CoinBaseTx(string(other.Address()), "tag", 0), spend)
+	if err := chain.ValidateBlock(block); !errors.Is(err, ErrImmatureCoinbase) {
+		t.Errorf("ValidateBlock() = %v, want %v", err, ErrImmatureCoinbase)
+	}
+
+	mine(CoinBaseTx(string(other.Address()), "tag", 0))
+	if spendable, immature := UTXO.Balance(lockingScript); spendable != 100+params.BlockReward || immature != 0 {
+		t.Errorf("Balance() after maturity = %d, %d, want %d, 0", spendable, immature, 100+params.BlockReward)
+	}
+	if accumulated, _, err := UTXO.FindSpendableOutputs(lockingScript, 101, LargestFirst{}); err != nil || accumulated != 100+params.BlockReward {
+		t.Errorf("FindSpendableOutputs(101) = %d, %v, want %d", accumulated, err, 100+params.BlockReward)
+	}
+	if _, err := NewMempool(chain).Add(spend); err != nil {
+		t.Errorf("Mempool.Add() after maturity = %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+// MaxTemplateSize bounds the serialized size of the transactions selected
+// into a block template. It is a mining policy; blocks of others may be
+// larger.
+var MaxTemplateSize = 1 << 20
+
+// BlockTemplate is the work handed to a miner: everything needed to build
+// the next block except the coinbase, which pays CoinbaseValue to whoever
+// mines it, and the seal.
+type BlockTemplate struct {
+	PrevHash   []byte
+	Height     int
+	Difficulty int
+	Target     *big.Int
+	// MinTimestamp is the earliest timestamp the block may carry.
+	MinTimestamp int64
+	Timestamp    int64
+	// Transactions excludes the coinbase.
+	Transactions  []*Transaction
+	Fees          int
+	CoinbaseValue int
+}
+
+// GetBlockTemplate builds a template on top of the current tip from the
+// candidate transactions, taken in order until MaxTemplateSize is reached.
+// Candidates that are invalid or conflict with ones already selected are
+// left out; a candidate spending the output of another candidate is
+// selected after it.
+func (chain *BlockChain) GetBlockTemplate(candidates []*Transaction) (*BlockTemplate, error) {
+	var tmpl BlockTemplate
+	var parent *Block
+	var parentSupply int
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		tipHash, err := getTip(txn)
+		if err != nil {
+			return err
+		}
+		if parent, err = getBlock(txn, tipHash); err != nil {
+			return err
+		}
+		meta, err := getBlockMeta(txn, tipHash)
+		if err != nil {
+			return err
+		}
+		parentSupply = meta.Supply
+		state, err := chain.newBlockState(txn, parent)
+		if err != nil {
+			return err
+		}
+		tmpl.MinTimestamp = state.median
+
+		// a candidate failing because it spends another candidate not
+		// selected yet is tried again once more candidates are in
+		waiting := make(map[string]bool)
+		for _, tx := range candidates {
+			waiting[string(tx.ID)] = true
+		}
+		spent := make(map[string]bool)
+		size := 0
+		pending := candidates
+		for added := true; added; {
+			added = false
+			var rest []*Transaction
+		Candidates:
+			for _, tx := range pending {
+				txSize := len(tx.Serialize())
+				if tx.IsCoinbase() || checkTransaction(tx, chain.Params.MaxSupply) != nil || size+txSize > MaxTemplateSize {
+					continue
+				}
+				for _, in := range tx.Inputs {
+					if spent[fmt.Sprintf("%x:%d", in.ID, in.Out)] {
+						continue Candidates
+					}
+				}
+				if err := chain.connectTransaction(txn, state, tx); err != nil {
+					for _, in := range tx.Inputs {
+						if waiting[string(in.ID)] {
+							rest = append(rest, tx)
+							break
+						}
+					}
+					continue
+				}
+				for _, in := range tx.Inputs {
+					spent[fmt.Sprintf("%x:%d", in.ID, in.Out)] = true
+				}
+				delete(waiting, string(tx.ID))
+				tmpl.Transactions = append(tmpl.Transactions, tx)
+				size += txSize
+				added = true
+			}
+			pending = rest
+		}
+		tmpl.Fees = state.fees
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	difficulty, err := chain.NextDifficulty(parent)
+	if err != nil {
+		return nil, err
+	}
+	tmpl.PrevHash = parent.Hash
+	tmpl.Height = parent.Height + 1
+	tmpl.Difficulty = difficulty
+	tmpl.Target = NewProof(&BlockHeader{Difficulty: difficulty}).Target
+	tmpl.Timestamp = time.Now().Unix()
+	if tmpl.Timestamp < tmpl.MinTimestamp {
+		tmpl.Timestamp = tmpl.MinTimestamp
+	}
+	tmpl.CoinbaseValue = chain.Params.AvailableSubsidy(tmpl.Height, parentSupply) + tmpl.Fees
+	return &tmpl, nil
+}
+
+// Serialize encodes the template for miners asking a node for work, see
+// BlockTemplate.encode.
+func (tmpl *BlockTemplate) Serialize() []byte {
+	var buff bytes.Buffer
+	tmpl.encode(&buff)
+	return buff.Bytes()
+}
+
+func DeserializeBlockTemplate(data []byte) (*BlockTemplate, error) {
+	return newDecoder(data).template()
+}
+
+// Coinbase returns the coinbase transaction claiming the template's subsidy
+// and fees for address.
+func (tmpl *BlockTemplate) Coinbase(address, data string) *Transaction {
+	return CoinBaseTx(address, data, tmpl.CoinbaseValue)
+}
+
+// NewBlock assembles the unsealed block of the template with coinbase as its
+// first transaction.
+func (tmpl *BlockTemplate) NewBlock(coinbase *Transaction) *Block {
+	block := &Block{
+		BlockHeader: BlockHeader{
+			PrevHash:   tmpl.PrevHash,
+			Timestamp:  tmpl.Timestamp,
+			Height:     tmpl.Height,
+			Difficulty: tmpl.Difficulty,
+		},
+		Transactions: append([]*Transaction{coinbase}, tmpl.Transactions...),
+	}
+	block.MerkleRoot = block.HashTransactions()
+	return block
+}
+
+// SubmitBlock validates and connects a block solved from a template. It
+// returns how the active chain changed, like AddBlock, and ErrStaleTip when
+// the block is valid but was only stored on a side branch.
+func (chain *BlockChain) SubmitBlock(block *Block) (*Reorg, error) {
+	reorg, err := chain.AddBlock(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(reorg.Connected) == 0 {
+		return reorg, ErrStaleTip
+	}
+	return reorg, nil
+}
@@ -0,0 +1,122 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/leetcode-golang-classroom/golang-blockchain/wallet"
+)
+
+func TestGetBlockTemplateCandidates(t *testing.T) {
+	w := wallet.MakeWallet()
+	chain, genesis := newTestChain(t, w, 100, 100, 100)
+
+	txs := map[string]*Transaction{}
+	txs["parent"] = spendOutput(w, genesis, 0, 90, 0)
+	txs["child"] = spendOutput(w, txs["parent"], 0, 85, 0)
+	txs["grandchild"] = spendOutput(w, txs["child"], 0, 84, 0)
+	txs["other"] = spendOutput(w, genesis, 1, 99, 0)
+	txs["conflict"] = spendOutput(w, genesis, 1, 98, 0)
+	txs["too expensive"] = spendOutput(w, genesis, 2, 101, 0)
+	txs["orphan"] = spendOutput(w, txs["too expensive"], 0, 50, 0)
+	names := make(map[string]string)
+	for name, tx := range txs {
+		names[string(tx.ID)] = name
+	}
+
+	tests := []struct {
+		name       string
+		candidates []string
+		want       []string
+		fees       int
+	}{
+		{"in order", []string{"parent", "child", "other"}, []string{"parent", "child", "other"}, 16},
+		{"children first", []string{"grandchild", "child", "parent"}, []string{"parent", "child", "grandchild"}, 16},
+		{"conflict", []string{"other", "conflict"}, []string{"other"}, 1},
+		{"invalid and its child", []string{"orphan", "too expensive", "other"}, []string{"other"}, 1},
+		{"none", nil, nil, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var candidates []*Transaction
+			for _, name := range test.candidates {
+				candidates = append(candidates, txs[name])
+			}
+			tmpl, err := chain.GetBlockTemplate(candidates)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, tx := range tmpl.Transactions {
+				got = append(got, names[string(tx.ID)])
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("selected %v, want %v", got, test.want)
+			}
+			if tmpl.Fees != test.fees {
+				t.Errorf("fees %d, want %d", tmpl.Fees, test.fees)
+			}
+		})
+	}
+}
+
+func TestGetBlockTemplate(t *testing.T) {
+	w := wallet.MakeWallet()
+	chain, genesis := newTestChain(t, w, 100, 100)
+	parent := tip(t, chain)
+	tx := spendOutput(w, genesis, 0, 90, 0)
+
+	tmpl, err := chain.GetBlockTemplate([]*Transaction{tx})
+	if err != nil {
+		t.Fatal(err)
+	}
+	difficulty, err := chain.NextDifficulty(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tmpl.PrevHash, parent.Hash) || tmpl.Height != parent.Height+1 {
+		t.Errorf("template builds on %x at height %d, want %x at %d", tmpl.PrevHash, tmpl.Height, parent.Hash, parent.Height+1)
+	}
+	if tmpl.Difficulty != difficulty || tmpl.Target.Cmp(NewProof(&BlockHeader{Difficulty: difficulty}).Target) != 0 {
+		t.Errorf("template difficulty %d target %x, want difficulty %d", tmpl.Difficulty, tmpl.Target, difficulty)
+	}
+	if want := chain.Params.AvailableSubsidy(tmpl.Height, 200) + 10; tmpl.Fees != 10 || tmpl.CoinbaseValue != want {
+		t.Errorf("template fees %d coinbase value %d, want 10 and %d", tmpl.Fees, tmpl.CoinbaseValue, want)
+	}
+	if tmpl.Timestamp < tmpl.MinTimestamp {
+		t.Errorf("template timestamp %d is before %d", tmpl.Timestamp, tmpl.MinTimestamp)
+	}
+
+	solve := func(tmpl *BlockTemplate, value int) *Block {
+		coinbase := tmpl.Coinbase(string(w.Address()), "")
+		coinbase.Outputs[0].Value = value
+		coinbase.ID = coinbase.Hash()
+		block := tmpl.NewBlock(coinbase)
+		if err := chain.Engine.Seal(context.Background(), block); err != nil {
+			t.Fatal(err)
+		}
+		return block
+	}
+	stale, err := chain.GetBlockTemplate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := chain.SubmitBlock(solve(tmpl, tmpl.CoinbaseValue+1)); !errors.Is(err, ErrCoinbaseTooLarge) {
+		t.Errorf("SubmitBlock of an overpaying coinbase = %v, want %v", err, ErrCoinbaseTooLarge)
+	}
+	block := solve(tmpl, tmpl.CoinbaseValue)
+	reorg, err := chain.SubmitBlock(block)
+	if err != nil {
+		t.Fatalf("SubmitBlock = %v", err)
+	}
+	if len(reorg.Connected) != 1 || !bytes.Equal(chain.LastHash, block.Hash) {
+		t.Errorf("SubmitBlock connected %d blocks, tip %x, want the block %x", len(reorg.Connected), chain.LastHash, block.Hash)
+	}
+	if _, err := chain.SubmitBlock(solve(stale, stale.CoinbaseValue)); !errors.Is(err, ErrStaleTip) {
+		t.Errorf("SubmitBlock of a stale template = %v, want %v", err, ErrStaleTip)
+	}
+}
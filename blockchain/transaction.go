@@ -2,14 +2,15 @@ package blockchain
 
 import (
 	"bytes"
-	"crypto/ecdsa"
+	"crypto"
 	"crypto/rand"
 	"crypto/sha256"
-	"encoding/gob"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"log"
 
+	"github.com/leetcode-golang-classroom/golang-blockchain/script"
 	"github.com/leetcode-golang-classroom/golang-blockchain/wallet"
 )
 
@@ -17,109 +18,200 @@ type Transaction struct {
 	ID      []byte
 	Inputs  []TxInput
 	Outputs []TxOutput
+	// LockTime is the last block height, or unix time when at least
+	// LockTimeThreshold, at which the transaction may not be mined yet.
+	// 0 disables it.
+	LockTime int64
 }
 
 func (tx *Transaction) SetID() {
-	var encoded bytes.Buffer
-	var hash [32]byte
-	encode := gob.NewEncoder(&encoded)
-	err := encode.Encode(tx)
-	Handle(err)
-	hash = sha256.Sum256(encoded.Bytes())
-	tx.ID = hash[:]
-}
-func CoinBaseTx(to, data string) *Transaction {
-	if data == "" {
-		randData := make([]byte, 24)
-		_, err := rand.Read(randData)
-		if err != nil {
-			log.Panic(err)
-		}
-		data = fmt.Sprintf("%x", randData)
+	tx.ID = tx.Hash()
+}
+
+// The input data of a coinbase starts with an extra nonce that miners change
+// once the header nonce space is exhausted, followed by a free form tag.
+const (
+	extraNonceLength = 8
+	// MaxCoinbaseData bounds the length of the coinbase input data.
+	MaxCoinbaseData = 100
+	MaxTagLength    = MaxCoinbaseData - extraNonceLength
+)
+
+// CoinBaseTx creates the transaction paying value, the block subsidy plus
+// fees, to the miner, tagged with the given message. The extra nonce starts
+// at a random value so that coinbases with equal outputs differ.
+func CoinBaseTx(to, tag string, value int) *Transaction {
+	extraNonce := make([]byte, extraNonceLength)
+	_, err := rand.Read(extraNonce)
+	if err != nil {
+		log.Panic(err)
 	}
+	return newCoinbase([]TxOutput{*NewTXOutput(value, to)}, binary.BigEndian.Uint64(extraNonce), tag)
+}
 
-	txin := TxInput{[]byte{}, -1, nil, []byte(data)}
-	txout := NewTXOutput(20, to)
+func newCoinbase(outputs []TxOutput, extraNonce uint64, tag string) *Transaction {
+	data := make([]byte, extraNonceLength, extraNonceLength+len(tag))
+	binary.BigEndian.PutUint64(data, extraNonce)
+	txin := TxInput{[]byte{}, -1, append(data, tag...), 0}
 
-	tx := Transaction{nil, []TxInput{txin}, []TxOutput{*txout}}
+	tx := Transaction{nil, []TxInput{txin}, outputs, 0}
 	tx.ID = tx.Hash()
 
 	return &tx
 }
 
+// ExtraNonce returns the extra nonce of a coinbase.
+func (tx *Transaction) ExtraNonce() uint64 {
+	data := tx.Inputs[0].UnlockingScript
+	if len(data) < extraNonceLength {
+		return 0
+	}
+	return binary.BigEndian.Uint64(data)
+}
+
+// Tag returns the message a miner put in a coinbase.
+func (tx *Transaction) Tag() string {
+	data := tx.Inputs[0].UnlockingScript
+	if len(data) < extraNonceLength {
+		return ""
+	}
+	return string(data[extraNonceLength:])
+}
+
+// WithExtraNonce returns a copy of the coinbase with the extra nonce
+// replaced.
+func (tx *Transaction) WithExtraNonce(extraNonce uint64) *Transaction {
+	return newCoinbase(tx.Outputs, extraNonce, tx.Tag())
+}
+
 func (tx *Transaction) IsCoinbase() bool {
 	return len(tx.Inputs) == 1 && len(tx.Inputs[0].ID) == 0 && tx.Inputs[0].Out == -1
 }
 
-func NewTransacton(w *wallet.Wallet, to string, amount int, UTXO *UTXOSet) *Transaction {
-	var inputs []TxInput
-	var outputs []TxOutput
-	pubKeyHash := wallet.PublicKeyHash(w.PublicKey)
-	acc, validOutputs := UTXO.FindSpendableOutputs(pubKeyHash, amount)
-	if acc < amount {
-		log.Panic("Error: not enough funds")
+// OutputValue returns the sum of the values of all outputs. The sum is not
+// checked for overflow; validation goes through outputValue instead.
+func (tx *Transaction) OutputValue() int {
+	total := 0
+	for _, out := range tx.Outputs {
+		total += out.Value
 	}
-	for txid, outs := range validOutputs {
-		txID, err := hex.DecodeString(txid)
-		Handle(err)
-		for _, out := range outs {
-			input := TxInput{txID, out, nil, w.PublicKey}
-			inputs = append(inputs, input)
+	return total
+}
+
+// outputValue returns the sum of the values of all outputs, failing with
+// ErrValueOutOfRange when an output or the sum is negative or above
+// maxValue.
+func (tx *Transaction) outputValue(maxValue int) (int, error) {
+	total := 0
+	for outIdx, out := range tx.Outputs {
+		var err error
+		if total, err = addValue(total, out.Value, maxValue); err != nil {
+			return 0, fmt.Errorf("output %d of %x: %w", outIdx, tx.ID, err)
 		}
 	}
+	return total, nil
+}
+
+// Payment is an amount paid to an address.
+type Payment struct {
+	Address string
+	Amount  int
+}
+
+// NewTransacton sends amount to the address to, paying fee to the miner and
+// the rest of the spent outputs, chosen by coins, back to w as change. A
+// fee per byte is charged on the size of the signed transaction, so inputs
+// are selected again until they cover it. A replaceable transaction may later be bumped
+// with BumpFee while it is unconfirmed.
+func NewTransacton(w *wallet.Wallet, to string, amount int, data []byte, fee Fee, replaceable bool, coins CoinSelector, UTXO *UTXOSet) *Transaction {
+	return NewBatchTransaction(w, []Payment{{to, amount}}, data, fee, replaceable, coins, UTXO)
+}
+
+// NewBatchTransaction makes every payment in one transaction, in order,
+// with a single change output back to w, like NewTransacton.
+func NewBatchTransaction(w *wallet.Wallet, payments []Payment, data []byte, fee Fee, replaceable bool, coins CoinSelector, UTXO *UTXOSet) *Transaction {
+	pubKeyHash := wallet.PublicKeyHash(w.PublicKey)
+	amount := 0
+	for _, p := range payments {
+		amount += p.Amount
+	}
 	from := fmt.Sprintf("%s", w.Address())
-	outputs = append(outputs, *NewTXOutput(amount, to))
+	var sequence uint32
+	if replaceable {
+		sequence = SequenceReplaceable
+	}
+	feeAmount := fee.Absolute
+	for {
+		var inputs []TxInput
+		var outputs []TxOutput
+		acc, validOutputs, err := UTXO.FindSpendableOutputs(script.PayToPubKeyHash(pubKeyHash), amount+feeAmount, coins)
+		Handle(err)
+		for txid, outs := range validOutputs {
+			txID, err := hex.DecodeString(txid)
+			Handle(err)
+			for _, out := range outs {
+				input := TxInput{txID, out, nil, sequence}
+				inputs = append(inputs, input)
+			}
+		}
+		for _, p := range payments {
+			outputs = append(outputs, *NewTXOutput(p.Amount, p.Address))
+		}
+		if len(data) > 0 {
+			outputs = append(outputs, TxOutput{0, script.NullData(data)})
+		}
+
+		if change := acc - amount - feeAmount; change > 0 {
+			outputs = append(outputs, *NewTXOutput(change, from))
+		}
+		tx := Transaction{nil, inputs, outputs, 0}
+		UTXO.BlockChain.SignTransaction(&tx, w.PrivateKey)
+		tx.ID = tx.Hash()
 
-	if acc > amount {
-		outputs = append(outputs, *NewTXOutput(acc-amount, from))
+		required := fee.Amount(len(tx.Serialize()))
+		if required <= feeAmount {
+			return &tx
+		}
+		feeAmount = required
 	}
-	tx := Transaction{nil, inputs, outputs}
-	tx.ID = tx.Hash()
-	UTXO.BlockChain.SignTransaction(&tx, w.PrivateKey)
-	return &tx
 }
 
+// Serialize returns the consensus encoding of the transaction, see
+// EncodingVersion. The ID is left out since it is the hash of this encoding.
 func (tx Transaction) Serialize() []byte {
 	var encoded bytes.Buffer
-
-	enc := gob.NewEncoder(&encoded)
-	err := enc.Encode(tx)
-	if err != nil {
-		log.Panic(err)
-	}
+	tx.encode(&encoded)
 	return encoded.Bytes()
 }
 
 func (tx *Transaction) Hash() []byte {
-	var hash [32]byte
-	txCopy := *tx
-	txCopy.ID = []byte{}
-	hash = sha256.Sum256(txCopy.Serialize())
+	hash := sha256.Sum256(tx.Serialize())
 	return hash[:]
 }
 
-func (tx *Transaction) Sign(priKey ecdsa.PrivateKey, prevTXs map[string]Transaction) {
+// Sign fills in the unlocking scripts of the inputs spending
+// pay-to-pubkey-hash outputs of priKey, with signatures covering what
+// hashType selects. Inputs of other keys, or whose spent output is not in
+// prevTXs, are left for their owners to sign.
+func (tx *Transaction) Sign(priKey crypto.Signer, prevTXs map[string]TxOutputs, hashType SigHashType) {
 	if tx.IsCoinbase() {
 		return
 	}
-	for _, in := range tx.Inputs {
-		if prevTXs[hex.EncodeToString(in.ID)].ID == nil {
-			log.Panic("ERROR: Previous transaction is not corret")
+	pubKey := wallet.SerializePublicKey(priKey.Public())
+	pubKeyHash := wallet.PublicKeyHash(pubKey)
+	signed := false
+	for inId, in := range tx.Inputs {
+		prevOut, ok := prevTXs[hex.EncodeToString(in.ID)].Outputs[in.Out]
+		if !ok || !prevOut.IsLockedWithKey(pubKeyHash) {
+			continue
 		}
-	}
-	txCopy := tx.TrimmedCopy()
 
-	for inId, in := range txCopy.Inputs {
-		prevTXs := prevTXs[hex.EncodeToString(in.ID)]
-		txCopy.Inputs[inId].Signature = nil
-		txCopy.Inputs[inId].PubKey = prevTXs.Outputs[in.Out].PubKeyHash
-		txCopy.ID = txCopy.Hash()
-		txCopy.Inputs[inId].PubKey = nil
-
-		r, s, err := ecdsa.Sign(rand.Reader, &priKey, txCopy.ID)
+		signature, err := tx.signInput(priKey, inId, prevOut.LockingScript, hashType)
 		Handle(err)
-		signature := append(r.Bytes(), s.Bytes()...)
-
-		tx.Inputs[inId].Signature = signature
+		tx.Inputs[inId].UnlockingScript = script.PubKeyHashUnlock(signature, pubKey)
+		signed = true
+	}
+	if !signed {
+		log.Panic("ERROR: No input spends an output of the signing key")
 	}
 }
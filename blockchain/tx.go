@@ -2,46 +2,63 @@ package blockchain
 
 import (
 	"bytes"
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"encoding/gob"
 	"encoding/hex"
 	"fmt"
-	"log"
-	"math/big"
+	"sort"
 	"strings"
 
+	"github.com/leetcode-golang-classroom/golang-blockchain/script"
 	"github.com/leetcode-golang-classroom/golang-blockchain/wallet"
 )
 
 type TxOutput struct {
-	Value      int
-	PubKeyHash []byte
+	Value int
+	// LockingScript states the conditions under which the output may be
+	// spent.
+	LockingScript script.Script
 }
 
+// TxOutputs holds the unspent outputs of one transaction keyed by their
+// index in Transaction.Outputs, together with the height and timestamp of
+// the block that created them, which relative locks count from.
 type TxOutputs struct {
-	Outputs []TxOutput
+	Outputs map[int]TxOutput
+	Height  int
+	Time    int64
+	// Coinbase is set for the outputs of a coinbase, which have to mature
+	// before they are spent, see ChainParams.CoinbaseMaturity.
+	Coinbase bool
 }
 type TxInput struct {
-	ID        []byte
-	Out       int
-	Signature []byte
-	PubKey    []byte
+	ID  []byte
+	Out int
+	// UnlockingScript satisfies the locking script of the spent output. The
+	// coinbase uses it for free form data instead.
+	UnlockingScript script.Script
+	// Sequence holds the relative lock of the input, see
+	// SequenceLockTimeDisabled.
+	Sequence uint32
 }
 
-func (in *TxInput) UsesKey(pubKeyHash []byte) bool {
-	lockingHash := wallet.PublicKeyHash(in.PubKey)
-	return bytes.Equal(lockingHash, pubKeyHash)
+func (out *TxOutput) Lock(address []byte) {
+	out.LockingScript = AddressScript(string(address))
 }
 
-func (out *TxOutput) Lock(address []byte) {
-	pubKeyHash := wallet.Base58Decode(address)
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	out.PubKeyHash = pubKeyHash
+// AddressScript returns the locking script paying to address: a
+// PayToScriptHash script for script addresses and a PayToPubKeyHash script
+// otherwise.
+func AddressScript(address string) script.Script {
+	hash := wallet.Base58Decode([]byte(address))
+	version := hash[0]
+	hash = hash[1 : len(hash)-4]
+	if version == wallet.ScriptVersion {
+		return script.PayToScriptHash(hash)
+	}
+	return script.PayToPubKeyHash(hash)
 }
 
 func (out *TxOutput) IsLockedWithKey(pubKeyHash []byte) bool {
-	return bytes.Equal(out.PubKeyHash, pubKeyHash)
+	return bytes.Equal(script.ExtractPubKeyHash(out.LockingScript), pubKeyHash)
 }
 
 func NewTXOutput(value int, address string) *TxOutput {
@@ -56,88 +73,131 @@ func (tx *Transaction) TrimmedCopy() Transaction {
 	var outputs []TxOutput
 
 	for _, in := range tx.Inputs {
-		inputs = append(inputs, TxInput{in.ID, in.Out, nil, nil})
+		inputs = append(inputs, TxInput{in.ID, in.Out, nil, in.Sequence})
 	}
 	for _, out := range tx.Outputs {
-		outputs = append(outputs, TxOutput{out.Value, out.PubKeyHash})
+		outputs = append(outputs, TxOutput{out.Value, out.LockingScript})
 	}
-	txCopy := Transaction{tx.ID, inputs, outputs}
+	txCopy := Transaction{tx.ID, inputs, outputs, tx.LockTime}
 	return txCopy
 }
 
-func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
-	if tx.IsCoinbase() {
-		return true
+// sigChecker checks the signatures of one input for the script engine.
+type sigChecker struct {
+	tx            *Transaction
+	inIdx         int
+	lockingScript script.Script
+}
+
+// CheckSig verifies signature over the parts of the transaction selected by
+// the hash type at its end.
+func (c sigChecker) CheckSig(signature, pubKey []byte) bool {
+	signature, hashType, ok := splitSignature(signature)
+	if !ok {
+		return false
 	}
+	hash, err := c.tx.SigHash(c.inIdx, c.lockingScript, hashType)
+	if err != nil {
+		return false
+	}
+	return wallet.VerifySignature(pubKey, hash, signature)
+}
 
-	for _, in := range tx.Inputs {
-		if prevTXs[hex.EncodeToString(in.ID)].ID == nil {
-			log.Panic("Previous transaction does not exits")
-		}
+// CheckLockTime reports whether the transaction is locked at least until
+// lockTime, counted in the same unit.
+func (c sigChecker) CheckLockTime(lockTime int64) bool {
+	if (lockTime < LockTimeThreshold) != (c.tx.LockTime < LockTimeThreshold) {
+		return false
 	}
+	return lockTime <= c.tx.LockTime
+}
 
-	txCopy := tx.TrimmedCopy()
-	curve := elliptic.P256()
-
-	for inId, in := range tx.Inputs {
-		prevTx := prevTXs[hex.EncodeToString(in.ID)]
-		txCopy.Inputs[inId].Signature = nil
-		txCopy.Inputs[inId].PubKey = prevTx.Outputs[in.Out].PubKeyHash
-		txCopy.ID = txCopy.Hash()
-		txCopy.Inputs[inId].PubKey = nil
-
-		r := big.Int{}
-		s := big.Int{}
-		sigLen := len(in.Signature)
-		r.SetBytes(in.Signature[:(sigLen / 2)])
-		s.SetBytes(in.Signature[(sigLen / 2):])
-
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(in.PubKey)
-		x.SetBytes(in.PubKey[:(keyLen / 2)])
-		y.SetBytes(in.PubKey[(keyLen / 2):])
-		rawPubKey := ecdsa.PublicKey{curve, &x, &y}
-		if ecdsa.Verify(&rawPubKey, txCopy.ID, &r, &s) == false {
-			return false
+// CheckSequence reports whether the input is locked relative to the output
+// it spends at least as long as sequence asks for. A sequence with the
+// disable flag requires nothing.
+func (c sigChecker) CheckSequence(sequence int64) bool {
+	if sequence&SequenceLockTimeDisabled != 0 {
+		return true
+	}
+	inSequence := int64(c.tx.Inputs[c.inIdx].Sequence)
+	if inSequence&SequenceLockTimeDisabled != 0 ||
+		sequence&SequenceLockTimeIsSeconds != inSequence&SequenceLockTimeIsSeconds {
+		return false
+	}
+	return sequence&SequenceLockTimeMask <= inSequence&SequenceLockTimeMask
+}
+
+// VerifyScripts runs the unlocking script of every input against the
+// locking script of the output it spends.
+func (tx *Transaction) VerifyScripts(prevTXs map[string]TxOutputs) error {
+	if tx.IsCoinbase() {
+		return nil
+	}
+	for inIdx, in := range tx.Inputs {
+		prevOut, ok := prevTXs[hex.EncodeToString(in.ID)].Outputs[in.Out]
+		if !ok {
+			return fmt.Errorf("input %d spends an unknown output", inIdx)
+		}
+		checker := sigChecker{tx, inIdx, prevOut.LockingScript}
+		if err := script.Verify(in.UnlockingScript, prevOut.LockingScript, checker); err != nil {
+			return fmt.Errorf("input %d: %w", inIdx, err)
 		}
 	}
+	return nil
+}
 
-	return true
+func (tx *Transaction) Verify(prevTXs map[string]TxOutputs) bool {
+	return tx.VerifyScripts(prevTXs) == nil
 }
 
 func (tx Transaction) String() string {
 	var lines []string
 
 	lines = append(lines, fmt.Sprintf("--- Transaction %x:", tx.ID))
+	if tx.LockTime != 0 {
+		lines = append(lines, fmt.Sprintf("     LockTime:  %d", tx.LockTime))
+	}
 	for i, input := range tx.Inputs {
 		lines = append(lines, fmt.Sprintf("      Input %d:", i))
 		lines = append(lines, fmt.Sprintf("    	   TXID:    %x", input.ID))
 		lines = append(lines, fmt.Sprintf("        Out:       %d", input.Out))
-		lines = append(lines, fmt.Sprintf("        Signature: %x", input.Signature))
-		lines = append(lines, fmt.Sprintf("        PubKey:    %x", input.PubKey))
+		if tx.IsCoinbase() {
+			lines = append(lines, fmt.Sprintf("        Data:      %x", []byte(input.UnlockingScript)))
+		} else {
+			lines = append(lines, fmt.Sprintf("        Script:    %s", input.UnlockingScript))
+		}
+		if input.Sequence != 0 {
+			lines = append(lines, fmt.Sprintf("        Sequence:  %#x", input.Sequence))
+		}
 	}
 
 	for i, output := range tx.Outputs {
 		lines = append(lines, fmt.Sprintf("   Output %d:", i))
 		lines = append(lines, fmt.Sprintf("     Value:  %d", output.Value))
-		lines = append(lines, fmt.Sprintf("     Script: %x", output.PubKeyHash))
+		lines = append(lines, fmt.Sprintf("     Script: %s", output.LockingScript))
 	}
 	return strings.Join(lines, "\n")
 }
 
+// Indexes returns the output indexes in ascending order.
+func (outs TxOutputs) Indexes() []int {
+	indexes := make([]int, 0, len(outs.Outputs))
+	for outIdx := range outs.Outputs {
+		indexes = append(indexes, outIdx)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
 func (outs TxOutputs) Serialize() []byte {
 	var buffer bytes.Buffer
-	encode := gob.NewEncoder(&buffer)
-	err := encode.Encode(outs)
-	Handle(err)
+	outs.encode(&buffer)
 	return buffer.Bytes()
 }
 
 func DeserializeOutputs(data []byte) TxOutputs {
-	var outputs TxOutputs
-	decode := gob.NewDecoder(bytes.NewReader(data))
-	err := decode.Decode(&outputs)
-	Handle(err)
+	d := newDecoder(data)
+	outputs := d.outputs()
+	Handle(d.finish())
 	return outputs
 }
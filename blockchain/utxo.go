@@ -3,9 +3,11 @@ package blockchain
 import (
 	"bytes"
 	"encoding/hex"
+	"fmt"
 	"log"
 
 	"github.com/dgraph-io/badger"
+	"github.com/leetcode-golang-classroom/golang-blockchain/script"
 )
 
 var (
@@ -74,8 +76,7 @@ func (u UTXOSet) Reindex() {
 			if err != nil {
 				return err
 			}
-			key = append(utxoPrefix, key...)
-			err = txn.Set(key, outs.Serialize())
+			err = txn.Set(utxoKey(key), outs.Serialize())
 			Handle(err)
 		}
 		return nil
@@ -83,53 +84,117 @@ func (u UTXOSet) Reindex() {
 	Handle(err)
 }
 
-func (u *UTXOSet) Update(block *Block) {
-	db := u.BlockChain.Database
+// SpentOutput records an output consumed by a block so that it can be put
+// back into the UTXO set when the block is disconnected.
+type SpentOutput struct {
+	ID     []byte
+	Out    int
+	Output TxOutput
+	// Height and Time are those of the block that created the output.
+	Height   int
+	Time     int64
+	Coinbase bool
+}
 
-	err := db.Update(func(txn *badger.Txn) error {
-		for _, tx := range block.Transactions {
-			if tx.IsCoinbase() == false {
-				for _, in := range tx.Inputs {
-					updatedOuts := TxOutputs{}
-					inID := append(utxoPrefix, in.ID...)
-					item, err := txn.Get(inID)
-					Handle(err)
-					var v []byte
-					err = item.Value(func(val []byte) error {
-						v = val
-						return nil
-					})
-					Handle(err)
-					outs := DeserializeOutputs(v)
-					for outIdx, out := range outs.Outputs {
-						if outIdx != in.Out {
-							updatedOuts.Outputs = append(updatedOuts.Outputs, out)
-						}
-					}
-					if len(updatedOuts.Outputs) == 0 {
-						if err := txn.Delete(inID); err != nil {
-							log.Panic(err)
-						}
-					} else {
-						if err := txn.Set(inID, updatedOuts.Serialize()); err != nil {
-							log.Panic(err)
-						}
-					}
+func utxoKey(txID []byte) []byte {
+	return append(append([]byte{}, utxoPrefix...), txID...)
+}
+
+func (u *UTXOSet) getOutputs(txn *badger.Txn, txID []byte) (TxOutputs, error) {
+	outs := TxOutputs{Outputs: make(map[int]TxOutput)}
+	item, err := txn.Get(utxoKey(txID))
+	if err == badger.ErrKeyNotFound {
+		return outs, nil
+	} else if err != nil {
+		return outs, err
+	}
+	v, err := item.ValueCopy(nil)
+	if err != nil {
+		return outs, err
+	}
+	return DeserializeOutputs(v), nil
+}
+
+func (u *UTXOSet) putOutputs(txn *badger.Txn, txID []byte, outs TxOutputs) error {
+	if len(outs.Outputs) == 0 {
+		return txn.Delete(utxoKey(txID))
+	}
+	return txn.Set(utxoKey(txID), outs.Serialize())
+}
+
+// Update applies the block to the UTXO set inside txn and returns the
+// outputs it spent, in the order they were spent. Like BIP30 it fails with
+// ErrDuplicateTx when a transaction of the block still has unspent outputs,
+// as a duplicate coinbase would overwrite them.
+func (u *UTXOSet) Update(txn *badger.Txn, block *Block) ([]SpentOutput, error) {
+	var spent []SpentOutput
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() == false {
+			for _, in := range tx.Inputs {
+				outs, err := u.getOutputs(txn, in.ID)
+				if err != nil {
+					return nil, err
+				}
+				out, ok := outs.Outputs[in.Out]
+				if !ok {
+					return nil, fmt.Errorf("Output %x:%d is not in the UTXO set", in.ID, in.Out)
+				}
+				spent = append(spent, SpentOutput{in.ID, in.Out, out, outs.Height, outs.Time, outs.Coinbase})
+				delete(outs.Outputs, in.Out)
+				if err := u.putOutputs(txn, in.ID, outs); err != nil {
+					return nil, err
 				}
 			}
-			newOutputs := TxOutputs{}
-			for _, out := range tx.Outputs {
-				newOutputs.Outputs = append(newOutputs.Outputs, out)
-			}
-			txID := append(utxoPrefix, tx.ID...)
-			if err := txn.Set(txID, newOutputs.Serialize()); err != nil {
-				log.Panic(err)
+		}
+		existing, err := u.getOutputs(txn, tx.ID)
+		if err != nil {
+			return nil, err
+		}
+		if len(existing.Outputs) > 0 {
+			return nil, fmt.Errorf("%w: %x", ErrDuplicateTx, tx.ID)
+		}
+		newOutputs := TxOutputs{make(map[int]TxOutput), block.Height, block.Timestamp, tx.IsCoinbase()}
+		for outIdx, out := range tx.Outputs {
+			if !out.LockingScript.IsUnspendable() {
+				newOutputs.Outputs[outIdx] = out
 			}
 		}
-		return nil
-	})
-	Handle(err)
+		if err := u.putOutputs(txn, tx.ID, newOutputs); err != nil {
+			return nil, err
+		}
+	}
+	return spent, nil
+}
 
+// Rollback reverts Update: the outputs created by the block are removed and
+// the outputs it spent are restored from spent.
+func (u *UTXOSet) Rollback(txn *badger.Txn, block *Block, spent []SpentOutput) error {
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		tx := block.Transactions[i]
+		if err := txn.Delete(utxoKey(tx.ID)); err != nil {
+			return err
+		}
+		if tx.IsCoinbase() {
+			continue
+		}
+		for j := len(tx.Inputs) - 1; j >= 0; j-- {
+			if len(spent) == 0 {
+				return fmt.Errorf("Undo data of block %x is incomplete", block.Hash)
+			}
+			s := spent[len(spent)-1]
+			spent = spent[:len(spent)-1]
+			outs, err := u.getOutputs(txn, s.ID)
+			if err != nil {
+				return err
+			}
+			outs.Outputs[s.Out] = s.Output
+			outs.Height, outs.Time, outs.Coinbase = s.Height, s.Time, s.Coinbase
+			if err := u.putOutputs(txn, s.ID, outs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 func (u UTXOSet) CountTransactions() int {
@@ -150,7 +215,9 @@ func (u UTXOSet) CountTransactions() int {
 	return counter
 }
 
-func (u UTXOSet) FindUnspentTransactions(pubKeyHash []byte) []TxOutput {
+// FindUnspentTransactions returns the unspent outputs locked with
+// lockingScript.
+func (u UTXOSet) FindUnspentTransactions(lockingScript script.Script) []TxOutput {
 	var UTXOs []TxOutput
 	db := u.BlockChain.Database
 	err := db.View(func(txn *badger.Txn) error {
@@ -168,7 +235,7 @@ func (u UTXOSet) FindUnspentTransactions(pubKeyHash []byte) []TxOutput {
 			Handle(err)
 			outs := DeserializeOutputs(v)
 			for _, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubKeyHash) {
+				if bytes.Equal(out.LockingScript, lockingScript) {
 					UTXOs = append(UTXOs, out)
 				}
 			}
@@ -179,37 +246,116 @@ func (u UTXOSet) FindUnspentTransactions(pubKeyHash []byte) []TxOutput {
 	return UTXOs
 }
 
-func (u *UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
-	unspentOuts := make(map[string][]int)
-	accumulated := 0
+// nextHeight returns the height of the block that would extend the tip.
+func nextHeight(txn *badger.Txn) (int, error) {
+	tipHash, err := getTip(txn)
+	if err != nil {
+		return 0, err
+	}
+	tip, err := getBlock(txn, tipHash)
+	if err != nil {
+		return 0, err
+	}
+	return tip.Height + 1, nil
+}
+
+// Balance returns what the unspent outputs locked with lockingScript hold,
+// split into what the next block may spend and the immature coinbase
+// outputs.
+func (u *UTXOSet) Balance(lockingScript script.Script) (spendable, immature int) {
+	db := u.BlockChain.Database
+	err := db.View(func(txn *badger.Txn) error {
+		height, err := nextHeight(txn)
+		if err != nil {
+			return err
+		}
+		opts := badger.DefaultIteratorOptions
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
+			item := it.Item()
+			var v []byte
+			err := item.Value(func(val []byte) error {
+				v = val
+				return nil
+			})
+			Handle(err)
+			outs := DeserializeOutputs(v)
+			mature := u.BlockChain.Params.IsMature(outs, height)
+			for _, out := range outs.Outputs {
+				if !bytes.Equal(out.LockingScript, lockingScript) {
+					continue
+				}
+				if mature {
+					spendable += out.Value
+				} else {
+					immature += out.Value
+				}
+			}
+		}
+		return nil
+	})
+	Handle(err)
+	return spendable, immature
+}
+
+// SpendableOutputs returns the unspent outputs locked with lockingScript
+// that the next block may spend, leaving out immature coinbase outputs.
+func (u *UTXOSet) SpendableOutputs(lockingScript script.Script) []SpendableOutput {
+	var utxos []SpendableOutput
 	db := u.BlockChain.Database
 
 	err := db.View(func(txn *badger.Txn) error {
+		height, err := nextHeight(txn)
+		if err != nil {
+			return err
+		}
 		opts := badger.DefaultIteratorOptions
 		it := txn.NewIterator(opts)
 		defer it.Close()
 
 		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
 			item := it.Item()
-			k := item.Key()
+			k := item.KeyCopy(nil)
 			var v []byte
 			err := item.Value(func(val []byte) error {
 				v = val
 				return nil
 			})
 			Handle(err)
-			k = bytes.TrimPrefix(k, utxoPrefix)
-			txID := hex.EncodeToString(k)
+			txID := bytes.TrimPrefix(k, utxoPrefix)
 			outs := DeserializeOutputs(v)
-			for outIdx, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubKeyHash) && accumulated < amount {
-					accumulated += out.Value
-					unspentOuts[txID] = append(unspentOuts[txID], outIdx)
+			if !u.BlockChain.Params.IsMature(outs, height) {
+				continue
+			}
+			for _, outIdx := range outs.Indexes() {
+				out := outs.Outputs[outIdx]
+				if bytes.Equal(out.LockingScript, lockingScript) {
+					utxos = append(utxos, SpendableOutput{txID, outIdx, out.Value})
 				}
 			}
 		}
 		return nil
 	})
 	Handle(err)
-	return accumulated, unspentOuts
+	return utxos
+}
+
+// FindSpendableOutputs lets coins choose unspent outputs locked with
+// lockingScript holding at least amount. The error of coins is returned
+// when it cannot, ErrNotEnoughFunds when all of them hold less.
+func (u *UTXOSet) FindSpendableOutputs(lockingScript script.Script, amount int, coins CoinSelector) (int, map[string][]int, error) {
+	selected, err := coins.SelectCoins(u.SpendableOutputs(lockingScript), amount)
+	if err != nil {
+		return 0, nil, err
+	}
+	unspentOuts := make(map[string][]int)
+	accumulated := 0
+	for _, utxo := range selected {
+		txID := hex.EncodeToString(utxo.TxID)
+		unspentOuts[txID] = append(unspentOuts[txID], utxo.Out)
+		accumulated += utxo.Value
+	}
+	return accumulated, unspentOuts, nil
 }
@@ -0,0 +1,66 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/leetcode-golang-classroom/golang-blockchain/script"
+	"github.com/leetcode-golang-classroom/golang-blockchain/wallet"
+)
+
+// TestDataOutputsNotInUTXOSet checks that data carriers are left out of the
+// UTXO set when a block is connected and when the set is rebuilt.
+func TestDataOutputsNotInUTXOSet(t *testing.T) {
+	w := wallet.MakeWallet()
+	address := string(w.Address())
+	chain, genesis := newTestChain(t, w, 100, 100)
+	UTXO := UTXOSet{chain}
+
+	data := TxOutput{0, script.NullData([]byte("hello"))}
+	spend := func(outIdx int, outputs ...TxOutput) *Transaction {
+		prevTXs := map[string]TxOutputs{hex.EncodeToString(genesis.ID): {Outputs: map[int]TxOutput{outIdx: genesis.Outputs[outIdx]}}}
+		tx := &Transaction{nil, []TxInput{{genesis.ID, outIdx, nil, 0}}, outputs, 0}
+		tx.Sign(w.PrivateKey, prevTXs, SigHashAll)
+		tx.ID = tx.Hash()
+		return tx
+	}
+	tx := spend(0, data, *NewTXOutput(90, address))
+	burn := spend(1, data)
+	if _, err := chain.AddBlock(newTestBlock(t, chain, tip(t, chain), CoinBaseTx(address, "tag", 0), tx, burn)); err != nil {
+		t.Fatal(err)
+	}
+
+	unspent := func(step string) {
+		t.Helper()
+		err := chain.Database.View(func(txn *badger.Txn) error {
+			for _, test := range []struct {
+				tx   *Transaction
+				want map[int]TxOutput
+			}{
+				{tx, map[int]TxOutput{1: tx.Outputs[1]}},
+				{burn, map[int]TxOutput{}},
+			} {
+				outs, err := UTXO.getOutputs(txn, test.tx.ID)
+				if err != nil {
+					return err
+				}
+				if !reflect.DeepEqual(outs.Outputs, test.want) {
+					t.Errorf("after %s the UTXO set holds %v of %x, want %v", step, outs.Outputs, test.tx.ID, test.want)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	unspent("Update")
+	count := UTXO.CountTransactions()
+	UTXO.Reindex()
+	unspent("Reindex")
+	if got := UTXO.CountTransactions(); got != count {
+		t.Errorf("Reindex counts %d transactions, want %d", got, count)
+	}
+}
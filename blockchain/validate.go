@@ -0,0 +1,378 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/leetcode-golang-classroom/golang-blockchain/script"
+)
+
+const (
+	// MedianTimeSpan is the number of previous blocks whose median timestamp
+	// a new block may not precede.
+	MedianTimeSpan = 11
+	// MaxFutureBlockTime is how many seconds ahead of the local clock a block
+	// timestamp may be.
+	MaxFutureBlockTime = 2 * 60 * 60
+)
+
+var (
+	ErrBadBlockHash       = errors.New("Block hash does not match its header")
+	ErrBadHeight          = errors.New("Block height does not follow its parent")
+	ErrBadDifficulty      = errors.New("Block difficulty does not match the retarget rule")
+	ErrInvalidPoW         = errors.New("Block hash does not meet the difficulty target")
+	ErrTimeTooOld         = errors.New("Block timestamp is before the median of recent blocks")
+	ErrTimeTooNew         = errors.New("Block timestamp is too far in the future")
+	ErrBadMerkleRoot      = errors.New("Merkle root does not match the transactions")
+	ErrBadCoinbase        = errors.New("Block must start with exactly one coinbase transaction")
+	ErrBadCoinbaseData    = errors.New("Coinbase input data has a bad length")
+	ErrCoinbaseTooLarge   = errors.New("Coinbase pays more than the block subsidy plus fees")
+	ErrDoubleSpend        = errors.New("Output is spent twice within the block")
+	ErrInvalidTransaction = errors.New("Invalid transaction")
+	ErrImmatureCoinbase   = errors.New("Coinbase output is spent before it matured")
+	ErrDuplicateTx        = errors.New("Transaction outputs are already unspent")
+	ErrValueOutOfRange    = errors.New("Value is negative or exceeds the maximum supply")
+)
+
+// ValidateBlock checks the block against the consensus rules: the header
+// must follow its parent and carry a valid seal, the merkle root has
+// to match the transactions and, when the block extends the current tip,
+// every transaction must verify against the UTXO set and the coinbase may not
+// exceed the reward plus fees. Blocks on a side branch have their
+// transactions checked when the branch is connected.
+func (chain *BlockChain) ValidateBlock(block *Block) error {
+	return chain.Database.View(func(txn *badger.Txn) error {
+		if err := chain.checkBlock(txn, block); err != nil {
+			return err
+		}
+		tipHash, err := getTip(txn)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(tipHash, block.PrevHash) {
+			return nil
+		}
+		tip, err := getBlock(txn, tipHash)
+		if err != nil {
+			return err
+		}
+		fees, err := chain.checkTransactions(txn, tip, block.Transactions)
+		if err != nil {
+			return err
+		}
+		_, _, err = chain.checkSubsidy(txn, &block.BlockHeader, block.Transactions[0], fees)
+		return err
+	})
+}
+
+// checkBlock runs every check that does not depend on the UTXO set.
+func (chain *BlockChain) checkBlock(txn *badger.Txn, block *Block) error {
+	if !bytes.Equal(block.Hash, block.BlockHeader.Hash()) {
+		return ErrBadBlockHash
+	}
+	if err := chain.checkHeader(txn, &block.BlockHeader); err != nil {
+		return err
+	}
+	return checkBody(block, chain.Params.MaxSupply)
+}
+
+func (chain *BlockChain) checkHeader(txn *badger.Txn, header *BlockHeader) error {
+	if len(header.PrevHash) == 0 {
+		return ErrOrphanBlock
+	}
+	if err := header.checkFieldSizes(); err != nil {
+		return err
+	}
+	parent, err := getBlock(txn, header.PrevHash)
+	if err == badger.ErrKeyNotFound {
+		return ErrOrphanBlock
+	} else if err != nil {
+		return err
+	}
+	if header.Height != parent.Height+1 {
+		return fmt.Errorf("%w: got %d, parent is at %d", ErrBadHeight, header.Height, parent.Height)
+	}
+	difficulty, err := chain.NextDifficulty(parent)
+	if err != nil {
+		return err
+	}
+	if header.Difficulty != difficulty {
+		return fmt.Errorf("%w: got %d, want %d", ErrBadDifficulty, header.Difficulty, difficulty)
+	}
+	if err := chain.Engine.VerifyHeader(header); err != nil {
+		return err
+	}
+	if header.Timestamp > time.Now().Unix()+MaxFutureBlockTime {
+		return ErrTimeTooNew
+	}
+	median, err := medianTime(txn, parent)
+	if err != nil {
+		return err
+	}
+	if header.Timestamp < median {
+		return fmt.Errorf("%w: %d < %d", ErrTimeTooOld, header.Timestamp, median)
+	}
+	return nil
+}
+
+// medianTime returns the median timestamp of the last MedianTimeSpan blocks
+// ending in block.
+func medianTime(txn *badger.Txn, block *Block) (int64, error) {
+	var timestamps []int64
+	for {
+		timestamps = append(timestamps, block.Timestamp)
+		if len(timestamps) == MedianTimeSpan || len(block.PrevHash) == 0 {
+			break
+		}
+		var err error
+		if block, err = getBlock(txn, block.PrevHash); err != nil {
+			return 0, err
+		}
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[len(timestamps)/2], nil
+}
+
+func checkBody(block *Block, maxValue int) error {
+	if !bytes.Equal(block.MerkleRoot, block.HashTransactions()) {
+		return ErrBadMerkleRoot
+	}
+	return checkTransactionList(block.Transactions, maxValue)
+}
+
+// checkTransactionList makes sure the list starts with the only coinbase,
+// every transaction is well formed and no output is spent twice.
+func checkTransactionList(txs []*Transaction, maxValue int) error {
+	if len(txs) == 0 || !txs[0].IsCoinbase() {
+		return ErrBadCoinbase
+	}
+	if data := txs[0].Inputs[0].UnlockingScript; len(data) < extraNonceLength || len(data) > MaxCoinbaseData {
+		return fmt.Errorf("%w: %d bytes, want %d to %d", ErrBadCoinbaseData, len(data), extraNonceLength, MaxCoinbaseData)
+	}
+	spent := make(map[string]bool)
+	for i, tx := range txs {
+		if i > 0 && tx.IsCoinbase() {
+			return ErrBadCoinbase
+		}
+		if err := checkTransaction(tx, maxValue); err != nil {
+			return err
+		}
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, in := range tx.Inputs {
+			outpoint := fmt.Sprintf("%x:%d", in.ID, in.Out)
+			if spent[outpoint] {
+				return fmt.Errorf("%w: %s", ErrDoubleSpend, outpoint)
+			}
+			spent[outpoint] = true
+		}
+	}
+	return nil
+}
+
+// checkTransaction runs the checks that need no other data than the
+// transaction itself and the largest value an output may hold.
+func checkTransaction(tx *Transaction, maxValue int) error {
+	if !bytes.Equal(tx.ID, tx.Hash()) {
+		return fmt.Errorf("%w: ID %x does not match its hash", ErrInvalidTransaction, tx.ID)
+	}
+	if len(tx.Inputs) == 0 || len(tx.Outputs) == 0 {
+		return fmt.Errorf("%w: %x has no inputs or outputs", ErrInvalidTransaction, tx.ID)
+	}
+	if _, err := tx.outputValue(maxValue); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidTransaction, err)
+	}
+	return nil
+}
+
+// addValue returns sum + value. It fails when value is negative or the sum
+// exceeds maxValue, which also keeps the sum from overflowing.
+func addValue(sum, value, maxValue int) (int, error) {
+	if value < 0 || value > maxValue || sum > maxValue-value {
+		return 0, fmt.Errorf("%w: %d + %d", ErrValueOutOfRange, sum, value)
+	}
+	return sum + value, nil
+}
+
+// checkTransactions verifies the transactions of a block on top of parent,
+// coinbase first, against the UTXO set in txn and returns the total fees.
+// Outputs created by earlier transactions of the same block may be spent by
+// later ones. The coinbase itself is checked by checkSubsidy.
+func (chain *BlockChain) checkTransactions(txn *badger.Txn, parent *Block, txs []*Transaction) (int, error) {
+	state, err := chain.newBlockState(txn, parent)
+	if err != nil {
+		return 0, err
+	}
+	for _, tx := range txs[1:] {
+		if err := chain.connectTransaction(txn, state, tx); err != nil {
+			return 0, err
+		}
+	}
+	return state.fees, nil
+}
+
+// blockState is what the transactions checked so far for a block on top of
+// a parent leave to the next one.
+type blockState struct {
+	height int
+	median int64
+	// created holds the outputs of the checked transactions not spent by
+	// later ones.
+	created map[string]TxOutputs
+	fees    int
+}
+
+func (chain *BlockChain) newBlockState(txn *badger.Txn, parent *Block) (*blockState, error) {
+	median, err := medianTime(txn, parent)
+	if err != nil {
+		return nil, err
+	}
+	return &blockState{height: parent.Height + 1, median: median, created: make(map[string]TxOutputs)}, nil
+}
+
+// connectTransaction verifies the next transaction of a block against the
+// UTXO set in txn and the outputs created earlier in the block. Only when tx
+// is valid is it applied to state.
+func (chain *BlockChain) connectTransaction(txn *badger.Txn, state *blockState, tx *Transaction) error {
+	UTXO := UTXOSet{chain}
+	if err := tx.checkLockTime(state.height, state.median); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidTransaction, err)
+	}
+	if err := chain.checkDataOutputs(tx); err != nil {
+		return err
+	}
+	prevTXs := make(map[string]TxOutputs)
+	for _, in := range tx.Inputs {
+		txID := hex.EncodeToString(in.ID)
+		outs, ok := state.created[txID]
+		if !ok {
+			var err error
+			if outs, err = UTXO.getOutputs(txn, in.ID); err != nil {
+				return err
+			}
+		}
+		out, ok := outs.Outputs[in.Out]
+		if !ok {
+			return fmt.Errorf("%w: %x spends %s:%d which is not unspent", ErrInvalidTransaction, tx.ID, txID, in.Out)
+		}
+		prev := prevTXs[txID]
+		if prev.Outputs == nil {
+			prev = TxOutputs{make(map[int]TxOutput), outs.Height, outs.Time, outs.Coinbase}
+		}
+		prev.Outputs[in.Out] = out
+		prevTXs[txID] = prev
+	}
+	if err := chain.checkCoinbaseMaturity(tx, prevTXs, state.height); err != nil {
+		return err
+	}
+	if err := tx.checkSequenceLocks(prevTXs, state.height, state.median); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidTransaction, err)
+	}
+	if err := tx.VerifyScripts(prevTXs); err != nil {
+		return fmt.Errorf("%w: %x: %s", ErrInvalidTransaction, tx.ID, err)
+	}
+	fee, err := chain.fee(tx, prevTXs)
+	if err != nil {
+		return err
+	}
+	fees, err := addValue(state.fees, fee, chain.Params.MaxSupply)
+	if err != nil {
+		return fmt.Errorf("%w: fees of the block: %s", ErrInvalidTransaction, err)
+	}
+
+	state.fees = fees
+	for _, in := range tx.Inputs {
+		if outs, ok := state.created[hex.EncodeToString(in.ID)]; ok {
+			delete(outs.Outputs, in.Out)
+		}
+	}
+	// the block timestamp is not known yet, but it is no earlier than
+	// the median time, which makes relative time locks see no time
+	// passing within the block
+	outs := TxOutputs{make(map[int]TxOutput), state.height, state.median, false}
+	for outIdx, out := range tx.Outputs {
+		if !out.LockingScript.IsUnspendable() {
+			outs.Outputs[outIdx] = out
+		}
+	}
+	state.created[hex.EncodeToString(tx.ID)] = outs
+	return nil
+}
+
+// fee returns what the outputs spent by tx, found in prevTXs, hold beyond
+// its own outputs. Every sum is checked against MaxSupply, so outputs
+// wrapping around cannot make up a fee.
+func (chain *BlockChain) fee(tx *Transaction, prevTXs map[string]TxOutputs) (int, error) {
+	maxValue := chain.Params.MaxSupply
+	outputs, err := tx.outputValue(maxValue)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidTransaction, err)
+	}
+	inputs := 0
+	for _, in := range tx.Inputs {
+		value := prevTXs[hex.EncodeToString(in.ID)].Outputs[in.Out].Value
+		if inputs, err = addValue(inputs, value, maxValue); err != nil {
+			return 0, fmt.Errorf("%w: inputs of %x: %s", ErrInvalidTransaction, tx.ID, err)
+		}
+	}
+	if inputs < outputs {
+		return 0, fmt.Errorf("%w: %x spends more than its inputs", ErrInvalidTransaction, tx.ID)
+	}
+	return inputs - outputs, nil
+}
+
+// checkCoinbaseMaturity makes sure tx, mined at height, spends only mature
+// coinbase outputs. prevTXs holds the spent outputs.
+func (chain *BlockChain) checkCoinbaseMaturity(tx *Transaction, prevTXs map[string]TxOutputs, height int) error {
+	for _, in := range tx.Inputs {
+		prev := prevTXs[hex.EncodeToString(in.ID)]
+		if !chain.Params.IsMature(prev, height) {
+			return fmt.Errorf("%w: %x spends %x:%d created at height %d, %d blocks are required", ErrImmatureCoinbase, tx.ID, in.ID, in.Out, prev.Height, chain.Params.CoinbaseMaturity)
+		}
+	}
+	return nil
+}
+
+// checkDataOutputs makes sure the unspendable outputs of tx are data
+// carriers within the size allowed by the chain.
+func (chain *BlockChain) checkDataOutputs(tx *Transaction) error {
+	for outIdx, out := range tx.Outputs {
+		if !out.LockingScript.IsUnspendable() {
+			continue
+		}
+		data, ok := script.ExtractNullData(out.LockingScript)
+		if !ok || len(data) > chain.Params.MaxDataCarrierSize {
+			return fmt.Errorf("%w: output %d of %x is not a data carrier of at most %d bytes", ErrInvalidTransaction, outIdx, tx.ID, chain.Params.MaxDataCarrierSize)
+		}
+	}
+	return nil
+}
+
+// checkSubsidy makes sure the coinbase of the block described by header
+// claims no more than the subsidy still available plus fees. It returns the
+// newly issued coins and the supply issued before the block.
+func (chain *BlockChain) checkSubsidy(txn *badger.Txn, header *BlockHeader, coinbase *Transaction, fees int) (int, int, error) {
+	parent, err := getBlockMeta(txn, header.PrevHash)
+	if err != nil {
+		return 0, 0, err
+	}
+	value, err := coinbase.outputValue(chain.Params.MaxSupply)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: %s", ErrCoinbaseTooLarge, err)
+	}
+	subsidy := chain.Params.AvailableSubsidy(header.Height, parent.Supply)
+	if value > subsidy+fees {
+		return 0, 0, fmt.Errorf("%w: %d > %d", ErrCoinbaseTooLarge, value, subsidy+fees)
+	}
+	issued := value - fees
+	if issued < 0 {
+		issued = 0
+	}
+	return issued, parent.Supply, nil
+}
@@ -0,0 +1,176 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/leetcode-golang-classroom/golang-blockchain/wallet"
+)
+
+func TestValidateBlock(t *testing.T) {
+	w := wallet.MakeWallet()
+	chain, genesisTx := newTestChain(t, w, 100, 100)
+	genesis, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := string(w.Address())
+
+	spend := spendOutput(w, genesisTx, 0, 90, 0)
+	doubleSpend := spendOutput(w, genesisTx, 0, 80, 0)
+	unknown := spendOutput(w, spend, 0, 80, 0)
+	// withTxs replaces the transactions after the coinbase
+	withTxs := func(txs ...*Transaction) func(b *Block) {
+		return func(b *Block) {
+			b.Transactions = append(b.Transactions[:1], txs...)
+			b.MerkleRoot = b.HashTransactions()
+		}
+	}
+
+	tests := []struct {
+		name   string
+		before func(b *Block)
+		after  func(b *Block)
+		want   error
+	}{
+		{"valid", nil, nil, nil},
+		{"valid with a transaction", withTxs(spend), nil, nil},
+		{"bad hash", nil, func(b *Block) { b.Hash[0]++ }, ErrBadBlockHash},
+		{"orphan", func(b *Block) { b.PrevHash = bytes.Repeat([]byte{1}, 32) }, nil, ErrOrphanBlock},
+		{"bad height", func(b *Block) { b.Height++ }, nil, ErrBadHeight},
+		{"bad difficulty", func(b *Block) { b.Difficulty++ }, nil, ErrBadDifficulty},
+		{"too old", func(b *Block) { b.Timestamp = genesis.Timestamp - 1 }, nil, ErrTimeTooOld},
+		{"too new", func(b *Block) { b.Timestamp = time.Now().Unix() + MaxFutureBlockTime + 60 }, nil, ErrTimeTooNew},
+		{"bad merkle root", func(b *Block) { b.MerkleRoot = bytes.Repeat([]byte{2}, 32) }, nil, ErrBadMerkleRoot},
+		{"no coinbase", func(b *Block) {
+			b.Transactions = []*Transaction{spend}
+			b.MerkleRoot = b.HashTransactions()
+		}, nil, ErrBadCoinbase},
+		{"two coinbases", withTxs(CoinBaseTx(address, "other", 1)), nil, ErrBadCoinbase},
+		{"coinbase too large", func(b *Block) {
+			b.Transactions[0] = CoinBaseTx(address, "tag", b.Transactions[0].Outputs[0].Value+1)
+			b.MerkleRoot = b.HashTransactions()
+		}, nil, ErrCoinbaseTooLarge},
+		{"coinbase value overflow", func(b *Block) {
+			reward := b.Transactions[0].Outputs[0].Value
+			b.Transactions[0] = newCoinbase([]TxOutput{{Value: math.MaxInt64}, {Value: math.MaxInt64}, {Value: 2 + reward}}, 0, "tag")
+			b.MerkleRoot = b.HashTransactions()
+		}, nil, ErrInvalidTransaction},
+		{"double spend", withTxs(spend, doubleSpend), nil, ErrDoubleSpend},
+		{"unknown output", withTxs(unknown), nil, ErrInvalidTransaction},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tmpl, err := chain.GetBlockTemplate(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			block := tmpl.NewBlock(tmpl.Coinbase(address, "tag"))
+			if test.before != nil {
+				test.before(block)
+			}
+			if err := chain.Engine.Seal(context.Background(), block); err != nil {
+				t.Fatal(err)
+			}
+			if test.after != nil {
+				test.after(block)
+			}
+			if err := chain.ValidateBlock(block); !errors.Is(err, test.want) {
+				t.Errorf("ValidateBlock() = %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+// TestCheckSubsidy checks the coinbase value of the next block, including
+// outputs whose sum wraps around to the allowed reward.
+func TestCheckSubsidy(t *testing.T) {
+	w := wallet.MakeWallet()
+	chain, _ := newTestChain(t, w, 100)
+	header := &BlockHeader{PrevHash: chain.LastHash, Height: 1}
+	reward := chain.Params.AvailableSubsidy(1, 100)
+	const fees = 3
+
+	tests := []struct {
+		name    string
+		outputs []int
+		issued  int
+		want    error
+	}{
+		{"reward and fees", []int{reward, fees}, reward, nil},
+		{"fees only", []int{fees}, 0, nil},
+		{"one too many", []int{reward + fees + 1}, 0, ErrCoinbaseTooLarge},
+		{"wrapping sum", []int{math.MaxInt64, math.MaxInt64, 2 + reward}, 0, ErrCoinbaseTooLarge},
+		{"above max supply", []int{chain.Params.MaxSupply + 1, -chain.Params.MaxSupply}, 0, ErrCoinbaseTooLarge},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var outputs []TxOutput
+			for _, value := range test.outputs {
+				outputs = append(outputs, TxOutput{Value: value})
+			}
+			coinbase := newCoinbase(outputs, 0, "tag")
+			err := chain.Database.View(func(txn *badger.Txn) error {
+				issued, supply, err := chain.checkSubsidy(txn, header, coinbase, fees)
+				if err == nil && (issued != test.issued || supply != 100) {
+					t.Errorf("checkSubsidy() = %d, %d, want %d, 100", issued, supply, test.issued)
+				}
+				return err
+			})
+			if !errors.Is(err, test.want) {
+				t.Errorf("checkSubsidy() error %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+// TestEd25519Transactions spends outputs of Ed25519 addresses, alone and
+// together with a P-256 one, through the mempool and a block.
+func TestEd25519Transactions(t *testing.T) {
+	ed, p256 := wallet.MakeWalletOfType(wallet.KeyEd25519), wallet.MakeWalletOfType(wallet.KeyP256)
+	params := testParams()
+	params.Allocations = []GenesisAllocation{
+		{Address: string(ed.Address()), Amount: 100},
+		{Address: string(p256.Address()), Amount: 100},
+		{Address: string(ed.Address()), Amount: 100},
+	}
+	chain, genesis := newTestChainWithParams(t, params)
+
+	single := spendOutput(ed, genesis, 0, 90, 0)
+	mixed := &Transaction{nil, []TxInput{{genesis.ID, 1, nil, 0}, {genesis.ID, 2, nil, 0}}, []TxOutput{*NewTXOutput(190, string(p256.Address()))}, 0}
+	chain.SignTransaction(mixed, p256.PrivateKey)
+	chain.SignTransaction(mixed, ed.PrivateKey)
+	mixed.ID = mixed.Hash()
+	// signed as if output 1 paid to the Ed25519 key like output 0
+	wrongKey := &Transaction{nil, []TxInput{{genesis.ID, 1, nil, 0}}, []TxOutput{*NewTXOutput(90, string(ed.Address()))}, 0}
+	wrongKey.Sign(ed.PrivateKey, map[string]TxOutputs{hex.EncodeToString(genesis.ID): {Outputs: map[int]TxOutput{1: genesis.Outputs[0]}}}, SigHashAll)
+	wrongKey.ID = wrongKey.Hash()
+
+	for _, tx := range []*Transaction{single, mixed} {
+		if !chain.VerifyTransaction(tx) {
+			t.Errorf("%x does not verify", tx.ID)
+		}
+	}
+	if chain.VerifyTransaction(wrongKey) {
+		t.Error("Ed25519 key spends an output of a P-256 key")
+	}
+	mp := NewMempool(chain)
+	for _, tx := range []*Transaction{single, mixed} {
+		if _, err := mp.Add(tx); err != nil {
+			t.Errorf("Mempool.Add(%x) = %v", tx.ID, err)
+		}
+	}
+	block := newTestBlock(t, chain, tip(t, chain), CoinBaseTx(string(ed.Address()), "tag", 0), single, mixed)
+	if _, err := chain.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(chain.LastHash, block.Hash) {
+		t.Error("block spending Ed25519 outputs is not the tip")
+	}
+}
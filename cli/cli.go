@@ -1,12 +1,17 @@
 package cli
 
 import (
+	"context"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"runtime"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/leetcode-golang-classroom/golang-blockchain/blockchain"
 	"github.com/leetcode-golang-classroom/golang-blockchain/network"
@@ -17,14 +22,27 @@ type CommandLine struct{}
 
 func (cli *CommandLine) printUsage() {
 	fmt.Println("Usage:")
-	fmt.Println(" getbalance -address ADDRESS - get the balance for that address")
-	fmt.Println(" createblockchain -address ADDRESS creates a blockchain")
+	fmt.Println(" getbalance -address ADDRESS - get the balance for that address, and its mining rewards that are not spendable yet")
+	fmt.Println(" createblockchain -address ADDRESS -genesis FILE -poa VALIDATORS creates a blockchain from the genesis file, signed by the comma separated validator addresses when -poa is set")
 	fmt.Println(" printchain - Prints the blocks in the chain")
-	fmt.Println(" send -from FROM -to TO -amount AMOUNT -mine - Send amount of coins. Then -mine flag is set, mine off of")
-	fmt.Println(" createwallet - Creates a new Wallet")
+	fmt.Println(" send -from FROM -to TO -amount AMOUNT -payments FILE -fee FEE -feerate RATE -mine -workers N -tag TAG -data TEXT -rbf -coins SELECTION - Send amount of coins, to every -to with the -amount at the same position and every recipient in the CSV or JSON FILE, in one transaction paying FEE plus RATE per byte to the miner, embedding TEXT in an unspendable output when -data is set. Then -mine flag is set, mine off of. -rbf lets bumpfee replace the transaction. SELECTION picks the spent outputs: largest, smallest, bnb or random")
+	fmt.Println(" bumpfee -from FROM -txid TXID -fee FEE -feerate RATE - Replaces the unconfirmed -rbf transaction TXID of FROM by one paying FEE plus RATE per byte, taken from its change")
+	fmt.Println(" cpfp -to ADDRESS -txid TXID -fee FEE -feerate RATE - Spends what the unconfirmed transaction TXID pays to ADDRESS back to it, paying FEE plus RATE per byte so that miners take TXID along")
+	fmt.Println(" createwallet -type TYPE - Creates a new Wallet with a p256 or ed25519 key")
 	fmt.Println(" listaddresses - Lists the addresses in our wallet file")
 	fmt.Println(" reindexutxo - Rebuilds the UTXO set")
-	fmt.Println(" startnode -miner ADDRESS - Start a node with ID specified in NODE_ID env var. -miner enable mining")
+	fmt.Println(" getsupply - Prints the number of coins issued so far")
+	fmt.Println(" startnode -miner ADDRESS -workers N -tag TAG - Start a node with ID specified in NODE_ID env var. -miner enable mining with N workers")
+	fmt.Println(" getpubkey -address ADDRESS - Prints the public key of an address in our wallet file")
+	fmt.Println(" createmultisig -m M -pubkeys KEYS - Creates an address spendable with signatures of any M of the comma separated hex public keys")
+	fmt.Println(" createmultisigtx -redeemscript SCRIPT -to TO -amount AMOUNT -fee FEE -feerate RATE -file FILE - Writes an unsigned transaction spending from the multisig address of SCRIPT to FILE")
+	fmt.Println(" signmultisigtx -file FILE -address ADDRESS - Adds the signatures of ADDRESS from our wallet file to the transaction in FILE")
+	fmt.Println(" finalizemultisigtx -file FILE -miner ADDRESS -tag TAG - Sends the signed transaction in FILE, or mines it paying ADDRESS when -miner is set")
+	fmt.Println(" initiateswap -from FROM -to PARTICIPANT -amount AMOUNT -locktime LOCKTIME -secrethash HASH -fee FEE -feerate RATE -mine -tag TAG - Locks amount in a contract PARTICIPANT redeems with the secret, refundable to FROM after LOCKTIME, a height or a unix time. Without -secrethash a new secret is made")
+	fmt.Println(" redeemswap -contract CONTRACT -secret SECRET -address ADDRESS -fee FEE -feerate RATE -mine -tag TAG - Redeems the contract to ADDRESS with the secret")
+	fmt.Println(" refundswap -contract CONTRACT -address ADDRESS -fee FEE -feerate RATE -mine -tag TAG - Refunds a timed out contract to ADDRESS")
+	fmt.Println(" auditswap -contract CONTRACT - Prints the terms and state of a contract, and the secret once it was redeemed")
+	fmt.Println(" mine -node NODE -address ADDRESS -workers N -count N -tag TAG - Mine COUNT blocks, forever when 0, on templates from the node and pay the rewards to ADDRESS")
 }
 func (cli *CommandLine) validateArgs() {
 	if len(os.Args) < 2 {
@@ -51,9 +69,12 @@ func (cli *CommandLine) StartNode(nodeID, minerAddress string) {
 	}
 	network.StartServer(nodeID, minerAddress)
 }
-func (cli *CommandLine) createWallet(nodeID string) {
-	wallets, _ := wallet.CreateWallets(nodeID)
-	address := wallets.AddWallet()
+func (cli *CommandLine) createWallet(keyType wallet.KeyType, nodeID string) {
+	wallets, err := wallet.CreateWallets(nodeID)
+	if errors.Is(err, wallet.ErrWalletFileFormat) {
+		log.Panic(err)
+	}
+	address := wallets.AddWallet(keyType)
 	wallets.SaveFile(nodeID)
 	fmt.Printf("New address is: %s\n", address)
 }
@@ -65,8 +86,10 @@ func (cli *CommandLine) printChain(nodeID string) {
 		block := iter.Next()
 		fmt.Printf("Hash: %x\n", block.Hash)
 		fmt.Printf("Previos Hash: %x\n", block.PrevHash)
-		pow := blockchain.NewProof(block)
-		fmt.Printf("PoW: %s\n", strconv.FormatBool(pow.Validate()))
+		fmt.Printf("Difficulty: %d\n", block.Difficulty)
+		fmt.Printf("Tag: %q\n", block.Transactions[0].Tag())
+		seal := chain.Engine.VerifyHeader(&block.BlockHeader) == nil
+		fmt.Printf("Seal: %s\n", strconv.FormatBool(seal))
 		for _, tx := range block.Transactions {
 			fmt.Println(tx)
 		}
@@ -81,68 +104,231 @@ func (cli *CommandLine) printChain(nodeID string) {
 func (cli *CommandLine) reindexUTXO(nodeID string) {
 	chain := blockchain.ContinueBlockChain(nodeID)
 	defer chain.Database.Close()
-	UXTOSet := blockchain.UTXOSet{chain}
+	UXTOSet := blockchain.UTXOSet{BlockChain: chain}
 	UXTOSet.Reindex()
 
 	count := UXTOSet.CountTransactions()
 	fmt.Printf("Done! There are %d transactions in the UTXO set.\n", count)
 }
-func (cli *CommandLine) createBlockChain(address, nodeID string) {
-	if !wallet.ValidateAddress(address) {
+func (cli *CommandLine) createBlockChain(address, genesisFile, validators, nodeID string) {
+	params := blockchain.DefaultChainParams()
+	if genesisFile != "" {
+		var err error
+		params, err = blockchain.ReadChainParams(genesisFile)
+		blockchain.Handle(err)
+		useChainParams(params)
+	}
+	if len(params.Allocations) == 0 && address == "" {
+		log.Panic("An address is required when the genesis file has no allocations")
+	}
+	if len(params.Allocations) == 0 && !wallet.ValidateAddress(address) {
 		log.Panic("Address is not Valid")
 	}
-	chain := blockchain.InitBlockChain(address, nodeID)
+	for _, alloc := range params.Allocations {
+		if !wallet.ValidateAddress(alloc.Address) {
+			log.Panicf("Allocation address %s is not Valid", alloc.Address)
+		}
+	}
+	if validators != "" {
+		params.Consensus = blockchain.ConsensusConfig{Engine: "poa", Validators: strings.Split(validators, ",")}
+	}
+	chain := blockchain.InitBlockChain(address, nodeID, params)
 	chain.Database.Close()
-	UTXOSet := blockchain.UTXOSet{chain}
-	UTXOSet.Reindex()
 	fmt.Println("Finished!")
 }
 
+func (cli *CommandLine) getSupply(nodeID string) {
+	chain := blockchain.ContinueBlockChain(nodeID)
+	defer chain.Database.Close()
+	supply, err := chain.TotalSupply()
+	blockchain.Handle(err)
+	subsidy, err := chain.NextSubsidy()
+	blockchain.Handle(err)
+	fmt.Printf("Issued supply at height %d: %d of %d\n", chain.GetBestHeight(), supply, chain.Params.MaxSupply)
+	fmt.Printf("Next block subsidy: %d\n", subsidy)
+}
+
 func (cli *CommandLine) getBalance(address, nodeID string) {
 	if !wallet.ValidateAddress(address) {
 		log.Panic("Address is not Valid")
 	}
 	chain := blockchain.ContinueBlockChain(nodeID)
-	UTXOSet := blockchain.UTXOSet{chain}
+	UTXOSet := blockchain.UTXOSet{BlockChain: chain}
 	defer chain.Database.Close()
-	balance := 0
-	pubKeyHash := wallet.Base58Decode([]byte(address))
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	UTXOs := UTXOSet.FindUnspentTransactions(pubKeyHash)
-	for _, out := range UTXOs {
-		balance += out.Value
-	}
+	balance, immature := UTXOSet.Balance(blockchain.AddressScript(address))
 	fmt.Printf("Balance of %s: %d\n", address, balance)
+	if immature > 0 {
+		fmt.Printf("Immature mining rewards: %d, spendable after %d confirmations\n", immature, chain.Params.CoinbaseMaturity)
+	}
 }
-func (cli *CommandLine) send(from, to string, amount int, nodeID string, mineNow bool) {
-	if !wallet.ValidateAddress(to) {
-		log.Panic("Address is not Valid")
+func (cli *CommandLine) send(from string, payments []blockchain.Payment, data []byte, fee blockchain.Fee, replaceable bool, coins blockchain.CoinSelector, nodeID string, mineNow bool, tag string) {
+	for _, p := range payments {
+		if !wallet.ValidateAddress(p.Address) {
+			log.Panicf("Address %s is not Valid", p.Address)
+		}
+		if p.Amount <= 0 {
+			log.Panicf("Amount paid to %s must be positive", p.Address)
+		}
 	}
 	if !wallet.ValidateAddress(from) {
 		log.Panic("Address is not Valid")
 	}
+	if wallet.IsScriptAddress(from) {
+		log.Panic("Spend from a multisig address with createmultisigtx")
+	}
 	chain := blockchain.ContinueBlockChain(nodeID)
-	UTXOSet := blockchain.UTXOSet{chain}
+	UTXOSet := blockchain.UTXOSet{BlockChain: chain}
 	defer chain.Database.Close()
+	if len(data) > chain.Params.MaxDataCarrierSize {
+		log.Panicf("Data may not be longer than %d bytes", chain.Params.MaxDataCarrierSize)
+	}
 
 	wallets, err := wallet.CreateWallets(nodeID)
 	if err != nil {
 		log.Panic(err)
 	}
 	wallet := wallets.GetWallet(from)
-	tx := blockchain.NewTransacton(&wallet, to, amount, &UTXOSet)
+	tx := blockchain.NewBatchTransaction(&wallet, payments, data, fee, replaceable, coins, &UTXOSet)
+	miner := ""
 	if mineNow {
-		cbTx := blockchain.CoinBaseTx(from, "")
-		txs := []*blockchain.Transaction{cbTx, tx}
-		block := chain.MineBlock(txs)
-		UTXOSet.Update(block)
-	} else {
+		useSigner(chain, &wallet)
+		miner = from
+	}
+	publish(chain, tx, miner, tag)
+
+	fmt.Printf("Success! Transaction %x\n", tx.ID)
+}
+
+// bumpFee replaces the transaction txID of from, waiting in the memory pool
+// of the first known node, by one paying fee.
+func (cli *CommandLine) bumpFee(from, txID string, fee blockchain.Fee, nodeID string) {
+	id, err := hex.DecodeString(txID)
+	if err != nil {
+		log.Panic("Transaction ID is not hex")
+	}
+	w := localWallet(from, nodeID)
+	tx, err := network.GetPoolTransaction(network.KnownNodes[0], id)
+	blockchain.Handle(err)
+
+	chain := blockchain.ContinueBlockChain(nodeID)
+	defer chain.Database.Close()
+	UTXOSet := blockchain.UTXOSet{BlockChain: chain}
+	newTx, err := blockchain.BumpFee(w, tx, fee, &UTXOSet)
+	blockchain.Handle(err)
+	network.SendTx(network.KnownNodes[0], newTx)
+	fmt.Printf("Replaced %x by %x\n", tx.ID, newTx.ID)
+}
+
+// childPaysForParent speeds up the transaction txID waiting in the memory
+// pool of the first known node with a child that spends its outputs to the
+// address to and pays fee.
+func (cli *CommandLine) childPaysForParent(to, txID string, fee blockchain.Fee, nodeID string) {
+	id, err := hex.DecodeString(txID)
+	if err != nil {
+		log.Panic("Transaction ID is not hex")
+	}
+	w := localWallet(to, nodeID)
+	parent, err := network.GetPoolTransaction(network.KnownNodes[0], id)
+	blockchain.Handle(err)
+
+	child, err := blockchain.ChildPaysForParent(w, parent, fee)
+	blockchain.Handle(err)
+	network.SendTx(network.KnownNodes[0], child)
+	fmt.Printf("Sent %x spending %x\n", child.ID, parent.ID)
+}
+
+// localWallet returns the key of address from the wallet file of nodeID.
+func localWallet(address, nodeID string) *wallet.Wallet {
+	wallets, err := wallet.CreateWallets(nodeID)
+	blockchain.Handle(err)
+	w, ok := wallets.Wallets[address]
+	if !ok {
+		log.Panic("Address is not in the wallet file")
+	}
+	return w
+}
+
+// useSigner lets w seal the blocks mined locally on a proof of authority
+// chain.
+func useSigner(chain *blockchain.BlockChain, w *wallet.Wallet) {
+	if poa, ok := chain.Engine.(*blockchain.ProofOfAuthority); ok {
+		poa.Signer = w
+	}
+}
+
+// publish sends tx to the network, or mines it on the local chain paying
+// the reward to miner when set.
+func publish(chain *blockchain.BlockChain, tx *blockchain.Transaction, miner, tag string) {
+	if miner == "" {
 		network.SendTx(network.KnownNodes[0], tx)
-		fmt.Println("send tx")
+		fmt.Printf("send tx %x\n", tx.ID)
+		return
+	}
+	tmpl, err := chain.GetBlockTemplate([]*blockchain.Transaction{tx})
+	blockchain.Handle(err)
+	if len(tmpl.Transactions) == 0 {
+		log.Panic("Transaction is invalid")
+	}
+	cbTx := tmpl.Coinbase(miner, tag)
+	txs := append([]*blockchain.Transaction{cbTx}, tmpl.Transactions...)
+	block, err := chain.MineBlock(context.Background(), txs)
+	blockchain.Handle(err)
+	if engine, ok := chain.Engine.(*blockchain.ProofOfWorkEngine); ok {
+		pow := engine.LastSeal()
+		printHashrate(block, &pow)
+	}
+}
+
+func printHashrate(block *blockchain.Block, pow *blockchain.ProofOfWork) {
+	fmt.Printf("%x found after %d hashes in %s (%.2f kH/s)\n", block.Hash, pow.Hashes, pow.Elapsed.Round(time.Millisecond), pow.Hashrate()/1000)
+}
+
+// mine works as a standalone miner: it asks node for block templates, seals
+// them with proof of work and submits the solved blocks back.
+func (cli *CommandLine) mine(node, address, tag string, count int) {
+	if !wallet.ValidateAddress(address) {
+		log.Panic("Address is not Valid")
+	}
+	engine := &blockchain.ProofOfWorkEngine{}
+	for i := 0; count == 0 || i < count; i++ {
+		tmpl, err := network.GetBlockTemplate(node)
+		blockchain.Handle(err)
+		fmt.Printf("Mining %d transactions at height %d, difficulty %d\n", len(tmpl.Transactions), tmpl.Height, tmpl.Difficulty)
+		block := tmpl.NewBlock(tmpl.Coinbase(address, tag))
+		err = engine.Seal(context.Background(), block)
+		blockchain.Handle(err)
+		pow := engine.LastSeal()
+		printHashrate(block, &pow)
+		if err := network.SubmitBlock(node, block); err != nil {
+			fmt.Printf("Block %x rejected: %s\n", block.Hash, err)
+		} else {
+			fmt.Printf("Block %x accepted\n", block.Hash)
+		}
+	}
+}
+
+func newFee(absolute, perByte int) blockchain.Fee {
+	if absolute < 0 || perByte < 0 {
+		log.Panic("Fees may not be negative")
 	}
+	return blockchain.Fee{Absolute: absolute, PerByte: perByte}
+}
+
+func validateTag(tag string) {
+	if len(tag) > blockchain.MaxTagLength {
+		log.Panicf("Tag may not be longer than %d bytes", blockchain.MaxTagLength)
+	}
+}
 
-	fmt.Println("Success!")
+// useChainParams applies the parameters that live outside the blockchain
+// package.
+func useChainParams(params *blockchain.ChainParams) {
+	wallet.Version = params.AddressVersion
+	wallet.ScriptVersion = params.ScriptAddressVersion
+	wallet.Ed25519Version = params.Ed25519AddressVersion
+	network.SetChainParams(params)
 }
+
 func (cli *CommandLine) Run() {
 	cli.validateArgs()
 	nodeID := os.Getenv("NODE_ID")
@@ -150,6 +336,9 @@ func (cli *CommandLine) Run() {
 		fmt.Printf("NODE_ID env is not set!")
 		runtime.Goexit()
 	}
+	params, err := blockchain.LoadChainParams(nodeID)
+	blockchain.Handle(err)
+	useChainParams(params)
 
 	getBalanceCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
 	createBlockchainCmd := flag.NewFlagSet("createblockchain", flag.ExitOnError)
@@ -157,16 +346,93 @@ func (cli *CommandLine) Run() {
 	printChainCmd := flag.NewFlagSet("printchain", flag.ExitOnError)
 	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
 	listAddressesCmd := flag.NewFlagSet("listaddresses", flag.ExitOnError)
+	createWalletType := createWalletCmd.String("type", wallet.KeyP256.String(), "Key type of the wallet, p256 or ed25519")
 	reindexUTXICmd := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
+	getSupplyCmd := flag.NewFlagSet("getsupply", flag.ExitOnError)
 	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
+	mineCmd := flag.NewFlagSet("mine", flag.ExitOnError)
+	getPubKeyCmd := flag.NewFlagSet("getpubkey", flag.ExitOnError)
+	createMultiSigCmd := flag.NewFlagSet("createmultisig", flag.ExitOnError)
+	createMultiSigTxCmd := flag.NewFlagSet("createmultisigtx", flag.ExitOnError)
+	signMultiSigTxCmd := flag.NewFlagSet("signmultisigtx", flag.ExitOnError)
+	finalizeMultiSigTxCmd := flag.NewFlagSet("finalizemultisigtx", flag.ExitOnError)
+	initiateSwapCmd := flag.NewFlagSet("initiateswap", flag.ExitOnError)
+	redeemSwapCmd := flag.NewFlagSet("redeemswap", flag.ExitOnError)
+	refundSwapCmd := flag.NewFlagSet("refundswap", flag.ExitOnError)
+	auditSwapCmd := flag.NewFlagSet("auditswap", flag.ExitOnError)
 
 	getBalanceAddress := getBalanceCmd.String("address", "", "The address")
 	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address")
+	createBlockchainGenesis := createBlockchainCmd.String("genesis", "", "JSON file with the chain parameters")
+	createBlockchainValidators := createBlockchainCmd.String("poa", "", "Comma separated validator addresses for proof of authority")
 	sendFrom := sendCmd.String("from", "", "Source wallet address")
-	sendTo := sendCmd.String("to", "", "Destination wallet address")
-	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
+	var sendTo, sendAmount listFlag
+	sendCmd.Var(&sendTo, "to", "Destination wallet address, repeated for every recipient")
+	sendCmd.Var(&sendAmount, "amount", "Amount to send, repeated for every recipient")
+	sendPayments := sendCmd.String("payments", "", "CSV or JSON file of recipients and amounts")
 	sendMine := sendCmd.Bool("mine", false, "Mine immediately on the same node")
+	sendFee := sendCmd.Int("fee", 0, "Absolute fee paid to the miner")
+	sendFeeRate := sendCmd.Int("feerate", 0, "Fee paid to the miner per byte of the transaction")
+	sendWorkers := sendCmd.Int("workers", blockchain.MinerWorkers, "Number of mining goroutines")
+	sendTag := sendCmd.String("tag", "", "Message for the coinbase of the mined block")
+	sendData := sendCmd.String("data", "", "Payload to record on chain in an unspendable output")
+	sendRBF := sendCmd.Bool("rbf", false, "Allow the transaction to be replaced by one paying a higher fee")
+	sendCoins := sendCmd.String("coins", "bnb", "Coin selection: largest, smallest, bnb or random")
+	bumpFeeCmd := flag.NewFlagSet("bumpfee", flag.ExitOnError)
+	bumpFeeFrom := bumpFeeCmd.String("from", "", "Wallet address that sent the transaction")
+	bumpFeeTxID := bumpFeeCmd.String("txid", "", "ID of the unconfirmed transaction")
+	bumpFeeFee := bumpFeeCmd.Int("fee", 0, "Absolute fee paid to the miner")
+	bumpFeeFeeRate := bumpFeeCmd.Int("feerate", 0, "Fee paid to the miner per byte of the transaction")
+	cpfpCmd := flag.NewFlagSet("cpfp", flag.ExitOnError)
+	cpfpTo := cpfpCmd.String("to", "", "Wallet address the unconfirmed transaction pays to")
+	cpfpTxID := cpfpCmd.String("txid", "", "ID of the unconfirmed transaction")
+	cpfpFee := cpfpCmd.Int("fee", 0, "Absolute fee paid to the miner")
+	cpfpFeeRate := cpfpCmd.Int("feerate", 0, "Fee paid to the miner per byte of the transaction")
 	startNodeMiner := startNodeCmd.String("miner", "", "Enable mining mode and send reward")
+	startNodeWorkers := startNodeCmd.Int("workers", blockchain.MinerWorkers, "Number of mining goroutines")
+	startNodeTag := startNodeCmd.String("tag", "", "Message for the coinbase of mined blocks")
+	mineNode := mineCmd.String("node", network.KnownNodes[0], "Address of the node to mine for")
+	mineAddress := mineCmd.String("address", "", "Address to send rewards to")
+	mineWorkers := mineCmd.Int("workers", blockchain.MinerWorkers, "Number of mining goroutines")
+	mineCount := mineCmd.Int("count", 1, "Number of blocks to mine, 0 mines forever")
+	mineTag := mineCmd.String("tag", "", "Message for the coinbase of mined blocks")
+	getPubKeyAddress := getPubKeyCmd.String("address", "", "The address")
+	createMultiSigM := createMultiSigCmd.Int("m", 0, "Number of signatures required")
+	createMultiSigPubKeys := createMultiSigCmd.String("pubkeys", "", "Comma separated hex public keys")
+	createMultiSigTxScript := createMultiSigTxCmd.String("redeemscript", "", "Hex redeem script of the multisig address")
+	createMultiSigTxTo := createMultiSigTxCmd.String("to", "", "Destination wallet address")
+	createMultiSigTxAmount := createMultiSigTxCmd.Int("amount", 0, "Amount to send")
+	createMultiSigTxFee := createMultiSigTxCmd.Int("fee", 0, "Absolute fee paid to the miner")
+	createMultiSigTxFeeRate := createMultiSigTxCmd.Int("feerate", 0, "Fee paid to the miner per byte of the transaction")
+	createMultiSigTxFile := createMultiSigTxCmd.String("file", "", "File to write the unsigned transaction to")
+	signMultiSigTxFile := signMultiSigTxCmd.String("file", "", "File with the transaction to sign")
+	signMultiSigTxAddress := signMultiSigTxCmd.String("address", "", "Address of the signing key")
+	finalizeMultiSigTxFile := finalizeMultiSigTxCmd.String("file", "", "File with the signed transaction")
+	finalizeMultiSigTxMiner := finalizeMultiSigTxCmd.String("miner", "", "Mine the transaction on this node and send the reward here")
+	finalizeMultiSigTxTag := finalizeMultiSigTxCmd.String("tag", "", "Message for the coinbase of the mined block")
+	initiateSwapFrom := initiateSwapCmd.String("from", "", "Source wallet address, which may refund the contract")
+	initiateSwapTo := initiateSwapCmd.String("to", "", "Address of the participant, who may redeem the contract")
+	initiateSwapAmount := initiateSwapCmd.Int("amount", 0, "Amount to lock in the contract")
+	initiateSwapLockTime := initiateSwapCmd.Int64("locktime", 0, "Height or unix time after which the contract may be refunded")
+	initiateSwapSecretHash := initiateSwapCmd.String("secrethash", "", "Hex secret hash of the other side's contract")
+	redeemSwapContract := redeemSwapCmd.String("contract", "", "Hex contract script")
+	redeemSwapSecret := redeemSwapCmd.String("secret", "", "Hex secret")
+	redeemSwapAddress := redeemSwapCmd.String("address", "", "Recipient address of the contract")
+	refundSwapContract := refundSwapCmd.String("contract", "", "Hex contract script")
+	refundSwapAddress := refundSwapCmd.String("address", "", "Refund address of the contract")
+	auditSwapContract := auditSwapCmd.String("contract", "", "Hex contract script")
+	initiateSwapFee := initiateSwapCmd.Int("fee", 0, "Absolute fee paid to the miner")
+	initiateSwapFeeRate := initiateSwapCmd.Int("feerate", 0, "Fee paid to the miner per byte of the transaction")
+	initiateSwapMine := initiateSwapCmd.Bool("mine", false, "Mine immediately on the same node")
+	initiateSwapTag := initiateSwapCmd.String("tag", "", "Message for the coinbase of the mined block")
+	redeemSwapFee := redeemSwapCmd.Int("fee", 0, "Absolute fee paid to the miner")
+	redeemSwapFeeRate := redeemSwapCmd.Int("feerate", 0, "Fee paid to the miner per byte of the transaction")
+	redeemSwapMine := redeemSwapCmd.Bool("mine", false, "Mine immediately on the same node")
+	redeemSwapTag := redeemSwapCmd.String("tag", "", "Message for the coinbase of the mined block")
+	refundSwapFee := refundSwapCmd.Int("fee", 0, "Absolute fee paid to the miner")
+	refundSwapFeeRate := refundSwapCmd.Int("feerate", 0, "Fee paid to the miner per byte of the transaction")
+	refundSwapMine := refundSwapCmd.Bool("mine", false, "Mine immediately on the same node")
+	refundSwapTag := refundSwapCmd.String("tag", "", "Message for the coinbase of the mined block")
 
 	switch os.Args[1] {
 	case "reindexutxo":
@@ -174,6 +440,9 @@ func (cli *CommandLine) Run() {
 		if err != nil {
 			log.Panic(err)
 		}
+	case "getsupply":
+		err := getSupplyCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
 	case "getbalance":
 		err := getBalanceCmd.Parse(os.Args[2:])
 		blockchain.Handle(err)
@@ -186,6 +455,12 @@ func (cli *CommandLine) Run() {
 	case "send":
 		err := sendCmd.Parse(os.Args[2:])
 		blockchain.Handle(err)
+	case "bumpfee":
+		err := bumpFeeCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+	case "cpfp":
+		err := cpfpCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
 	case "listaddresses":
 		err := listAddressesCmd.Parse(os.Args[2:])
 		blockchain.Handle(err)
@@ -195,6 +470,36 @@ func (cli *CommandLine) Run() {
 	case "startnode":
 		err := startNodeCmd.Parse(os.Args[2:])
 		blockchain.Handle(err)
+	case "mine":
+		err := mineCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+	case "getpubkey":
+		err := getPubKeyCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+	case "createmultisig":
+		err := createMultiSigCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+	case "createmultisigtx":
+		err := createMultiSigTxCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+	case "signmultisigtx":
+		err := signMultiSigTxCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+	case "finalizemultisigtx":
+		err := finalizeMultiSigTxCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+	case "initiateswap":
+		err := initiateSwapCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+	case "redeemswap":
+		err := redeemSwapCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+	case "refundswap":
+		err := refundSwapCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+	case "auditswap":
+		err := auditSwapCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
 	default:
 		cli.printUsage()
 		runtime.Goexit()
@@ -202,6 +507,9 @@ func (cli *CommandLine) Run() {
 	if reindexUTXICmd.Parsed() {
 		cli.reindexUTXO(nodeID)
 	}
+	if getSupplyCmd.Parsed() {
+		cli.getSupply(nodeID)
+	}
 	if getBalanceCmd.Parsed() {
 		if *getBalanceAddress == "" {
 			getBalanceCmd.Usage()
@@ -210,24 +518,58 @@ func (cli *CommandLine) Run() {
 		cli.getBalance(*getBalanceAddress, nodeID)
 	}
 	if createBlockchainCmd.Parsed() {
-		if *createBlockchainAddress == "" {
+		if *createBlockchainAddress == "" && *createBlockchainGenesis == "" {
 			createBlockchainCmd.Usage()
 			runtime.Goexit()
 		}
-		cli.createBlockChain(*createBlockchainAddress, nodeID)
+		cli.createBlockChain(*createBlockchainAddress, *createBlockchainGenesis, *createBlockchainValidators, nodeID)
 	}
 	if createWalletCmd.Parsed() {
-		cli.createWallet(nodeID)
+		keyType, err := wallet.ParseKeyType(*createWalletType)
+		blockchain.Handle(err)
+		cli.createWallet(keyType, nodeID)
 	}
 	if listAddressesCmd.Parsed() {
 		cli.listAddresses(nodeID)
 	}
 	if sendCmd.Parsed() {
-		if *sendFrom == "" || *sendTo == "" || *sendAmount == 0 {
+		if *sendFrom == "" || len(sendTo) == 0 && *sendPayments == "" {
 			sendCmd.Usage()
 			runtime.Goexit()
 		}
-		cli.send(*sendFrom, *sendTo, *sendAmount, nodeID, *sendMine)
+		payments, err := parsePayments(sendTo, sendAmount)
+		blockchain.Handle(err)
+		if *sendPayments != "" {
+			filePayments, err := readPayments(*sendPayments)
+			blockchain.Handle(err)
+			payments = append(payments, filePayments...)
+		}
+		if len(payments) == 0 {
+			log.Panic("No recipients")
+		}
+		if *sendFee < 0 || *sendFeeRate < 0 {
+			log.Panic("Fees may not be negative")
+		}
+		validateTag(*sendTag)
+		blockchain.MinerWorkers = *sendWorkers
+		fee := blockchain.Fee{Absolute: *sendFee, PerByte: *sendFeeRate}
+		coins, err := blockchain.ParseCoinSelector(*sendCoins)
+		blockchain.Handle(err)
+		cli.send(*sendFrom, payments, []byte(*sendData), fee, *sendRBF, coins, nodeID, *sendMine, *sendTag)
+	}
+	if bumpFeeCmd.Parsed() {
+		if *bumpFeeFrom == "" || *bumpFeeTxID == "" {
+			bumpFeeCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.bumpFee(*bumpFeeFrom, *bumpFeeTxID, newFee(*bumpFeeFee, *bumpFeeFeeRate), nodeID)
+	}
+	if cpfpCmd.Parsed() {
+		if *cpfpTo == "" || *cpfpTxID == "" {
+			cpfpCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.childPaysForParent(*cpfpTo, *cpfpTxID, newFee(*cpfpFee, *cpfpFeeRate), nodeID)
 	}
 	if printChainCmd.Parsed() {
 		cli.printChain(nodeID)
@@ -238,6 +580,92 @@ func (cli *CommandLine) Run() {
 			startNodeCmd.Usage()
 			runtime.Goexit()
 		}
+		validateTag(*startNodeTag)
+		blockchain.MinerWorkers = *startNodeWorkers
+		network.MinerTag = *startNodeTag
 		cli.StartNode(nodeID, *startNodeMiner)
 	}
+	if mineCmd.Parsed() {
+		if *mineAddress == "" {
+			mineCmd.Usage()
+			runtime.Goexit()
+		}
+		validateTag(*mineTag)
+		blockchain.MinerWorkers = *mineWorkers
+		cli.mine(*mineNode, *mineAddress, *mineTag, *mineCount)
+	}
+	if getPubKeyCmd.Parsed() {
+		if *getPubKeyAddress == "" {
+			getPubKeyCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.getPubKey(*getPubKeyAddress, nodeID)
+	}
+	if createMultiSigCmd.Parsed() {
+		if *createMultiSigM == 0 || *createMultiSigPubKeys == "" {
+			createMultiSigCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.createMultiSig(*createMultiSigM, *createMultiSigPubKeys)
+	}
+	if createMultiSigTxCmd.Parsed() {
+		if *createMultiSigTxScript == "" || *createMultiSigTxTo == "" || *createMultiSigTxAmount == 0 || *createMultiSigTxFile == "" {
+			createMultiSigTxCmd.Usage()
+			runtime.Goexit()
+		}
+		if *createMultiSigTxFee < 0 || *createMultiSigTxFeeRate < 0 {
+			log.Panic("Fees may not be negative")
+		}
+		fee := blockchain.Fee{Absolute: *createMultiSigTxFee, PerByte: *createMultiSigTxFeeRate}
+		cli.createMultiSigTx(*createMultiSigTxScript, *createMultiSigTxTo, *createMultiSigTxAmount, fee, *createMultiSigTxFile, nodeID)
+	}
+	if signMultiSigTxCmd.Parsed() {
+		if *signMultiSigTxFile == "" || *signMultiSigTxAddress == "" {
+			signMultiSigTxCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.signMultiSigTx(*signMultiSigTxFile, *signMultiSigTxAddress, nodeID)
+	}
+	if finalizeMultiSigTxCmd.Parsed() {
+		if *finalizeMultiSigTxFile == "" {
+			finalizeMultiSigTxCmd.Usage()
+			runtime.Goexit()
+		}
+		validateTag(*finalizeMultiSigTxTag)
+		cli.finalizeMultiSigTx(*finalizeMultiSigTxFile, *finalizeMultiSigTxMiner, *finalizeMultiSigTxTag, nodeID)
+	}
+	if initiateSwapCmd.Parsed() {
+		if *initiateSwapFrom == "" || *initiateSwapTo == "" || *initiateSwapAmount == 0 || *initiateSwapLockTime == 0 {
+			initiateSwapCmd.Usage()
+			runtime.Goexit()
+		}
+		validateTag(*initiateSwapTag)
+		fee := newFee(*initiateSwapFee, *initiateSwapFeeRate)
+		cli.initiateSwap(*initiateSwapFrom, *initiateSwapTo, *initiateSwapSecretHash, *initiateSwapLockTime, *initiateSwapAmount, fee, *initiateSwapMine, *initiateSwapTag, nodeID)
+	}
+	if redeemSwapCmd.Parsed() {
+		if *redeemSwapContract == "" || *redeemSwapSecret == "" || *redeemSwapAddress == "" {
+			redeemSwapCmd.Usage()
+			runtime.Goexit()
+		}
+		validateTag(*redeemSwapTag)
+		fee := newFee(*redeemSwapFee, *redeemSwapFeeRate)
+		cli.redeemSwap(*redeemSwapContract, *redeemSwapSecret, *redeemSwapAddress, fee, *redeemSwapMine, *redeemSwapTag, nodeID)
+	}
+	if refundSwapCmd.Parsed() {
+		if *refundSwapContract == "" || *refundSwapAddress == "" {
+			refundSwapCmd.Usage()
+			runtime.Goexit()
+		}
+		validateTag(*refundSwapTag)
+		fee := newFee(*refundSwapFee, *refundSwapFeeRate)
+		cli.refundSwap(*refundSwapContract, *refundSwapAddress, fee, *refundSwapMine, *refundSwapTag, nodeID)
+	}
+	if auditSwapCmd.Parsed() {
+		if *auditSwapContract == "" {
+			auditSwapCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.auditSwap(*auditSwapContract, nodeID)
+	}
 }
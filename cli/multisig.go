@@ -0,0 +1,89 @@
+package cli
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/leetcode-golang-classroom/golang-blockchain/blockchain"
+	"github.com/leetcode-golang-classroom/golang-blockchain/script"
+	"github.com/leetcode-golang-classroom/golang-blockchain/wallet"
+)
+
+func (cli *CommandLine) getPubKey(address, nodeID string) {
+	w := localWallet(address, nodeID)
+	fmt.Printf("Public key of %s: %x\n", address, w.PublicKey)
+}
+
+func (cli *CommandLine) createMultiSig(m int, pubKeys string) {
+	var keys [][]byte
+	for _, pubKey := range strings.Split(pubKeys, ",") {
+		key, err := hex.DecodeString(strings.TrimSpace(pubKey))
+		blockchain.Handle(err)
+		keys = append(keys, key)
+	}
+	redeem, err := script.MultiSig(m, keys)
+	blockchain.Handle(err)
+	address, err := blockchain.MultiSigAddress(redeem)
+	blockchain.Handle(err)
+	fmt.Printf("Multisig address: %s\n", address)
+	fmt.Printf("Redeem script: %x\n", []byte(redeem))
+	fmt.Printf("Script: %s\n", redeem)
+}
+
+func (cli *CommandLine) createMultiSigTx(redeemScript, to string, amount int, fee blockchain.Fee, file, nodeID string) {
+	if !wallet.ValidateAddress(to) {
+		log.Panic("Address is not Valid")
+	}
+	redeem, err := hex.DecodeString(redeemScript)
+	blockchain.Handle(err)
+	chain := blockchain.ContinueBlockChain(nodeID)
+	UTXOSet := blockchain.UTXOSet{BlockChain: chain}
+	defer chain.Database.Close()
+
+	mtx, err := blockchain.NewMultiSigTx(redeem, to, amount, fee, &UTXOSet)
+	blockchain.Handle(err)
+	saveMultiSigTx(mtx, file)
+	_, required := mtx.SignatureCount()
+	fmt.Printf("Unsigned transaction written to %s, it needs %d signatures\n", file, required)
+}
+
+// signMultiSigTx adds the signatures of one key of the local wallet file to
+// the transaction in file.
+func (cli *CommandLine) signMultiSigTx(file, address, nodeID string) {
+	w := localWallet(address, nodeID)
+	mtx := loadMultiSigTx(file)
+	blockchain.Handle(mtx.Sign(w.PrivateKey))
+	saveMultiSigTx(mtx, file)
+	collected, required := mtx.SignatureCount()
+	fmt.Printf("Signed with %s, %d of %d signatures collected\n", address, collected, required)
+}
+
+// finalizeMultiSigTx completes the transaction in file and sends it to the
+// network, or mines it locally paying the reward to miner when set.
+func (cli *CommandLine) finalizeMultiSigTx(file, miner, tag, nodeID string) {
+	tx, err := loadMultiSigTx(file).Finalize()
+	blockchain.Handle(err)
+	if miner != "" && !wallet.ValidateAddress(miner) {
+		log.Panic("Address is not Valid")
+	}
+	chain := blockchain.ContinueBlockChain(nodeID)
+	defer chain.Database.Close()
+	publish(chain, tx, miner, tag)
+	fmt.Printf("Transaction %x\n", tx.ID)
+}
+
+func saveMultiSigTx(mtx *blockchain.MultiSigTx, file string) {
+	err := os.WriteFile(file, mtx.Serialize(), 0644)
+	blockchain.Handle(err)
+}
+
+func loadMultiSigTx(file string) *blockchain.MultiSigTx {
+	data, err := os.ReadFile(file)
+	blockchain.Handle(err)
+	mtx, err := blockchain.DeserializeMultiSigTx(data)
+	blockchain.Handle(err)
+	return mtx
+}
@@ -0,0 +1,102 @@
+package cli
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/leetcode-golang-classroom/golang-blockchain/blockchain"
+	"github.com/leetcode-golang-classroom/golang-blockchain/wallet"
+)
+
+// listFlag collects every value of a flag given more than once.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// filePayment is a payment in a JSON payments file.
+type filePayment struct {
+	Address string `json:"address"`
+	Amount  int    `json:"amount"`
+}
+
+// parsePayments pairs the repeated -to and -amount flags in order.
+func parsePayments(to, amounts []string) ([]blockchain.Payment, error) {
+	if len(to) != len(amounts) {
+		return nil, fmt.Errorf("%d recipients but %d amounts", len(to), len(amounts))
+	}
+	var payments []blockchain.Payment
+	for i, address := range to {
+		amount, err := strconv.Atoi(amounts[i])
+		if err != nil {
+			return nil, fmt.Errorf("Amount %q of %s is not a number", amounts[i], address)
+		}
+		payments = append(payments, blockchain.Payment{Address: address, Amount: amount})
+	}
+	return payments, nil
+}
+
+// readPayments reads recipients and amounts from a JSON file, a list of
+// {"address", "amount"} objects, or, for any other extension, from a CSV
+// file with one address,amount record per line. A first CSV line whose
+// amount is not a number is taken for a header. An invalid address fails
+// with the entry or line it is on.
+func readPayments(file string) ([]blockchain.Payment, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var payments []blockchain.Payment
+	if strings.EqualFold(filepath.Ext(file), ".json") {
+		var entries []filePayment
+		if err := json.NewDecoder(f).Decode(&entries); err != nil {
+			return nil, fmt.Errorf("Parsing %s: %w", file, err)
+		}
+		for i, e := range entries {
+			if !wallet.ValidateAddress(e.Address) {
+				return nil, fmt.Errorf("Parsing %s: entry %d: address %q is not valid", file, i+1, e.Address)
+			}
+			payments = append(payments, blockchain.Payment{Address: e.Address, Amount: e.Amount})
+		}
+		return payments, nil
+	}
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = 2
+	r.TrimLeadingSpace = true
+	for line := 1; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Parsing %s: %w", file, err)
+		}
+		amount, err := strconv.Atoi(record[1])
+		if err != nil {
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("Parsing %s: line %d: amount %q is not a number", file, line, record[1])
+		}
+		if !wallet.ValidateAddress(record[0]) {
+			return nil, fmt.Errorf("Parsing %s: line %d: address %q is not valid", file, line, record[0])
+		}
+		payments = append(payments, blockchain.Payment{Address: record[0], Amount: amount})
+	}
+	return payments, nil
+}
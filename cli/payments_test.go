@@ -0,0 +1,43 @@
+package cli
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/leetcode-golang-classroom/golang-blockchain/wallet"
+)
+
+func TestReadPayments(t *testing.T) {
+	address := string(wallet.MakeWallet().Address())
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    int
+		err     string
+	}{
+		{"json", "pay.json", `[{"address": "` + address + `", "amount": 5}]`, 1, ""},
+		{"json bad address", "pay.json", `[{"address": "` + address + `", "amount": 5}, {"address": "nope", "amount": 1}]`, 0, `entry 2: address "nope"`},
+		{"csv with header", "pay.csv", "address,amount\n" + address + ",5\n" + address + ",6\n", 2, ""},
+		{"csv bad address", "pay.csv", address + ",5\n" + address[:10] + ",6\n", 0, `line 2: address "` + address[:10] + `"`},
+		{"csv bad amount", "pay.csv", address + ",5\n" + address + ",x\n", 0, `line 2: amount "x"`},
+	}
+	for _, test := range tests {
+		file := filepath.Join(t.TempDir(), test.file)
+		if err := ioutil.WriteFile(file, []byte(test.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		payments, err := readPayments(file)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: readPayments() = %v, want an error with %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil || len(payments) != test.want {
+			t.Errorf("%s: readPayments() = %v, %v, want %d payments", test.name, payments, err, test.want)
+		}
+	}
+}
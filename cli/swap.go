@@ -0,0 +1,131 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+
+	"github.com/leetcode-golang-classroom/golang-blockchain/blockchain"
+	"github.com/leetcode-golang-classroom/golang-blockchain/script"
+	"github.com/leetcode-golang-classroom/golang-blockchain/wallet"
+)
+
+// initiateSwap locks amount from the wallet address from in a contract that
+// participant can redeem with the secret before lockTime, and from can
+// refund afterwards. The initiator of a swap leaves secretHash empty to get
+// a new secret; the participant passes the hash of the initiator's contract.
+func (cli *CommandLine) initiateSwap(from, participant, secretHash string, lockTime int64, amount int, fee blockchain.Fee, mineNow bool, tag, nodeID string) {
+	var secret, hash []byte
+	if secretHash == "" {
+		secret = make([]byte, script.SecretLength)
+		_, err := rand.Read(secret)
+		blockchain.Handle(err)
+		sum := sha256.Sum256(secret)
+		hash = sum[:]
+	} else {
+		var err error
+		hash, err = hex.DecodeString(secretHash)
+		blockchain.Handle(err)
+	}
+	contract, err := blockchain.NewHashTimeLock(hash, participant, from, lockTime)
+	blockchain.Handle(err)
+	address, err := blockchain.HTLCAddress(contract)
+	blockchain.Handle(err)
+
+	chain := blockchain.ContinueBlockChain(nodeID)
+	UTXOSet := blockchain.UTXOSet{BlockChain: chain}
+	defer chain.Database.Close()
+	w := localWallet(from, nodeID)
+	tx := blockchain.NewTransacton(w, address, amount, nil, fee, false, blockchain.BranchAndBound{}, &UTXOSet)
+	cli.publishSwap(chain, tx, w, from, mineNow, tag)
+
+	if secret != nil {
+		fmt.Printf("Secret:      %x\n", secret)
+	}
+	fmt.Printf("Secret hash: %x\n", hash)
+	fmt.Printf("Contract:    %x\n", []byte(contract))
+	fmt.Printf("Address:     %s\n", address)
+	fmt.Printf("Transaction: %x\n", tx.ID)
+}
+
+// redeemSwap spends a contract paying address with the secret.
+func (cli *CommandLine) redeemSwap(contractHex, secretHex, address string, fee blockchain.Fee, mineNow bool, tag, nodeID string) {
+	contract, err := hex.DecodeString(contractHex)
+	blockchain.Handle(err)
+	secret, err := hex.DecodeString(secretHex)
+	blockchain.Handle(err)
+	cli.spendSwap(contract, secret, address, fee, mineNow, tag, nodeID)
+}
+
+// refundSwap takes back a contract that timed out to its refund address.
+func (cli *CommandLine) refundSwap(contractHex, address string, fee blockchain.Fee, mineNow bool, tag, nodeID string) {
+	contract, err := hex.DecodeString(contractHex)
+	blockchain.Handle(err)
+	cli.spendSwap(contract, nil, address, fee, mineNow, tag, nodeID)
+}
+
+func (cli *CommandLine) spendSwap(contract, secret []byte, address string, fee blockchain.Fee, mineNow bool, tag, nodeID string) {
+	chain := blockchain.ContinueBlockChain(nodeID)
+	UTXOSet := blockchain.UTXOSet{BlockChain: chain}
+	defer chain.Database.Close()
+	w := localWallet(address, nodeID)
+	tx, err := blockchain.SpendHTLC(w, contract, secret, address, fee, &UTXOSet)
+	blockchain.Handle(err)
+	if err := chain.CheckFinal(tx); err != nil {
+		log.Panic(err)
+	}
+	cli.publishSwap(chain, tx, w, address, mineNow, tag)
+	fmt.Printf("Transaction: %x\n", tx.ID)
+}
+
+func (cli *CommandLine) publishSwap(chain *blockchain.BlockChain, tx *blockchain.Transaction, w *wallet.Wallet, address string, mineNow bool, tag string) {
+	miner := ""
+	if mineNow {
+		useSigner(chain, w)
+		miner = address
+	}
+	publish(chain, tx, miner, tag)
+}
+
+// auditSwap prints the terms of a contract and its state on this chain, so
+// that a participant can check the contract of the other side before
+// locking funds, and learn the secret once the other side redeemed.
+func (cli *CommandLine) auditSwap(contractHex, nodeID string) {
+	contract, err := hex.DecodeString(contractHex)
+	blockchain.Handle(err)
+	h, ok := script.ExtractHashTimeLock(contract)
+	if !ok {
+		log.Panic(blockchain.ErrNotHTLC)
+	}
+	address, err := blockchain.HTLCAddress(contract)
+	blockchain.Handle(err)
+	chain := blockchain.ContinueBlockChain(nodeID)
+	UTXOSet := blockchain.UTXOSet{BlockChain: chain}
+	defer chain.Database.Close()
+
+	locked := 0
+	for _, out := range UTXOSet.FindUnspentTransactions(blockchain.AddressScript(address)) {
+		locked += out.Value
+	}
+	fmt.Printf("Contract address:   %s\n", address)
+	// the contract commits to key hashes only and the address version
+	// depends on the key type, so the hashes are shown as they are
+	fmt.Printf("Recipient key hash: %x\n", h.RecipientHash)
+	fmt.Printf("Refund key hash:    %x\n", h.RefundHash)
+	fmt.Printf("Secret hash:        %x\n", h.SecretHash)
+	if h.LockTime < blockchain.LockTimeThreshold {
+		fmt.Printf("Refundable after:   height %d, current height %d\n", h.LockTime, chain.GetBestHeight())
+	} else {
+		fmt.Printf("Refundable after:   unix time %d\n", h.LockTime)
+	}
+	fmt.Printf("Locked value:       %d\n", locked)
+	if secret := chain.FindHTLCSecret(contract); secret != nil {
+		hash := sha256.Sum256(secret)
+		if bytes.Equal(hash[:], h.SecretHash) {
+			fmt.Printf("Redeemed, secret:   %x\n", secret)
+		}
+	}
+}
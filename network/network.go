@@ -2,8 +2,10 @@ package network
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"encoding/gob"
-	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -12,8 +14,10 @@ import (
 	"os"
 	"runtime"
 	"syscall"
+	"time"
 
 	"github.com/leetcode-golang-classroom/golang-blockchain/blockchain"
+	"github.com/leetcode-golang-classroom/golang-blockchain/wallet"
 	"github.com/vrecan/death/v3"
 )
 
@@ -21,14 +25,17 @@ const (
 	protocol      = "tcp"
 	version       = 1
 	commandLength = 12
+	magicLength   = 4
 )
 
 var (
 	nodeAddress     string
 	minerAddress    string
-	KnownNodes      = []string{"localhost:3000"}
+	MinerTag        string
+	networkMagic    = blockchain.DefaultChainParams().NetworkMagic
+	KnownNodes      = blockchain.DefaultChainParams().SeedPeers
 	blocksInTransit = [][]byte{}
-	memoryPool      = make(map[string]blockchain.Transaction)
+	memoryPool      *blockchain.Mempool
 )
 
 type Addr struct {
@@ -61,6 +68,19 @@ type Tx struct {
 	Transaction []byte
 }
 
+type GetTemplate struct {
+	AddrFrom string
+}
+
+// Template carries a serialized block template.
+type Template struct {
+	Template []byte
+}
+
+type SubmitResult struct {
+	Error string
+}
+
 type Version struct {
 	Version    int
 	BestHeight int
@@ -86,6 +106,19 @@ func BytesToCmd(bytes []byte) string {
 	return string(cmd)
 }
 
+// SetChainParams makes the node speak the network of params: messages carry
+// its magic and the seed peers become the initial known nodes.
+func SetChainParams(params *blockchain.ChainParams) {
+	networkMagic = params.NetworkMagic
+	KnownNodes = append([]string{}, params.SeedPeers...)
+}
+
+func magicBytes() []byte {
+	magic := make([]byte, magicLength)
+	binary.BigEndian.PutUint32(magic, networkMagic)
+	return magic
+}
+
 func ExtractCmd(request []byte) []byte {
 	return request[:commandLength]
 }
@@ -151,12 +184,99 @@ func SendData(addr string, data []byte) {
 		return
 	}
 	defer conn.Close()
-	_, err = io.Copy(conn, bytes.NewReader(data))
+	_, err = io.Copy(conn, io.MultiReader(bytes.NewReader(magicBytes()), bytes.NewReader(data)))
 	if err != nil {
 		log.Panic(err)
 	}
 }
 
+// reply writes the answer to a request back on the connection it came in.
+func reply(conn net.Conn, cmd string, data interface{}) {
+	request := append(CmdToBytes(cmd), GobEncode(data)...)
+	_, err := conn.Write(append(magicBytes(), request...))
+	if err != nil {
+		fmt.Printf("Cannot reply with %s: %s\n", cmd, err)
+	}
+}
+
+// Request sends data to addr and waits for the answer on the same
+// connection, for commands such as gettemplate that expect one. The answer
+// is returned without the network magic.
+func Request(addr string, data []byte) ([]byte, error) {
+	conn, err := net.Dial(protocol, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write(append(magicBytes(), data...)); err != nil {
+		return nil, err
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		if err := tcp.CloseWrite(); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < magicLength+commandLength || binary.BigEndian.Uint32(resp) != networkMagic {
+		return nil, errors.New("No answer from the node")
+	}
+	return resp[magicLength:], nil
+}
+
+// GetBlockTemplate asks the node at addr for a block template.
+func GetBlockTemplate(addr string) (*blockchain.BlockTemplate, error) {
+	request := append(CmdToBytes("gettemplate"), GobEncode(GetTemplate{nodeAddress})...)
+	resp, err := Request(addr, request)
+	if err != nil {
+		return nil, err
+	}
+	var payload Template
+	if err := gob.NewDecoder(bytes.NewReader(resp[commandLength:])).Decode(&payload); err != nil {
+		return nil, err
+	}
+	return blockchain.DeserializeBlockTemplate(payload.Template)
+}
+
+// GetPoolTransaction asks the node at addr for an unconfirmed transaction
+// of its memory pool.
+func GetPoolTransaction(addr string, txID []byte) (*blockchain.Transaction, error) {
+	request := append(CmdToBytes("getpooltx"), GobEncode(GetData{nodeAddress, "tx", txID})...)
+	resp, err := Request(addr, request)
+	if err != nil {
+		return nil, err
+	}
+	var payload Tx
+	if err := gob.NewDecoder(bytes.NewReader(resp[commandLength:])).Decode(&payload); err != nil {
+		return nil, err
+	}
+	if len(payload.Transaction) == 0 {
+		return nil, fmt.Errorf("Transaction %x is not in the memory pool of %s", txID, addr)
+	}
+	tx := blockchain.DeserializeTransaction(payload.Transaction)
+	return &tx, nil
+}
+
+// SubmitBlock hands a solved block to the node at addr and returns the
+// reason it was rejected, if any.
+func SubmitBlock(addr string, b *blockchain.Block) error {
+	request := append(CmdToBytes("submitblock"), GobEncode(Block{nodeAddress, b.Serialize()})...)
+	resp, err := Request(addr, request)
+	if err != nil {
+		return err
+	}
+	var result SubmitResult
+	if err := gob.NewDecoder(bytes.NewReader(resp[commandLength:])).Decode(&result); err != nil {
+		return err
+	}
+	if result.Error != "" {
+		return errors.New(result.Error)
+	}
+	return nil
+}
+
 func HandleAddr(request []byte) {
 	var buff bytes.Buffer
 	var payload Addr
@@ -189,18 +309,38 @@ func HandleBlock(request []byte, chain *blockchain.BlockChain) {
 	block := blockchain.Deserialize(blockData)
 
 	fmt.Println("Received a new block!")
-	chain.AddBlock(block)
-
-	fmt.Printf("Added block %x\n", block.Hash)
+	reorg, err := chain.AddBlock(block)
+	if err != nil {
+		fmt.Printf("Rejected block %x: %s\n", block.Hash, err)
+		if errors.Is(err, blockchain.ErrOrphanBlock) {
+			SendGetBlocks(payload.AddrFrom)
+		}
+	} else {
+		updateMemoryPool(reorg)
+		fmt.Printf("Added block %x\n", block.Hash)
+	}
 	if len(blocksInTransit) > 0 {
 		blockHash := blocksInTransit[0]
 		SendGetData(payload.AddrFrom, "block", blockHash)
 		blocksInTransit = blocksInTransit[1:]
-	} else {
-		UTXOSet := blockchain.UTXOSet{chain}
-		UTXOSet.Reindex()
 	}
+}
 
+// updateMemoryPool drops the transactions of the connected blocks, and those
+// conflicting with them, from the memory pool and returns the ones of
+// disconnected blocks to it.
+func updateMemoryPool(reorg *blockchain.Reorg) {
+	if len(reorg.Disconnected) > 0 {
+		fmt.Printf("Reorganized chain: %d blocks disconnected, %d connected\n", len(reorg.Disconnected), len(reorg.Connected))
+	}
+	for _, block := range reorg.Connected {
+		memoryPool.RemoveBlock(block)
+	}
+	for _, tx := range reorg.Resurrected() {
+		if _, err := memoryPool.Add(tx); err != nil {
+			fmt.Printf("Dropping transaction %x: %s\n", tx.ID, err)
+		}
+	}
 }
 
 func HandleGetBlocks(request []byte, chain *blockchain.BlockChain) {
@@ -213,6 +353,10 @@ func HandleGetBlocks(request []byte, chain *blockchain.BlockChain) {
 		log.Panic(err)
 	}
 	blocks := chain.GetBlockHashes()
+	// send the hashes genesis first so that every block arrives after its parent
+	for i, j := 0, len(blocks)-1; i < j; i, j = i+1, j-1 {
+		blocks[i], blocks[j] = blocks[j], blocks[i]
+	}
 	SendInv(payload.AddrFrom, "block", blocks)
 }
 
@@ -234,12 +378,84 @@ func HandleGetData(request []byte, chain *blockchain.BlockChain) {
 		SendBlock(payload.AddrFrom, &block)
 	}
 	if payload.Type == "tx" {
-		txID := hex.EncodeToString(payload.ID)
-		tx := memoryPool[txID]
-		SendTx(payload.AddrFrom, &tx)
+		if tx, ok := memoryPool.Get(payload.ID); ok {
+			SendTx(payload.AddrFrom, tx)
+		}
 	}
 }
 
+// HandleGetTemplate answers on conn with a block template built from the
+// memory pool.
+func HandleGetTemplate(conn net.Conn, request []byte, chain *blockchain.BlockChain) {
+	var buff bytes.Buffer
+	var payload GetTemplate
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	tmpl, err := chain.GetBlockTemplate(memoryPool.BlockCandidates())
+	if err != nil {
+		fmt.Printf("Cannot build a block template: %s\n", err)
+		return
+	}
+	fmt.Printf("Sending template for height %d to %s\n", tmpl.Height, payload.AddrFrom)
+	reply(conn, "template", Template{tmpl.Serialize()})
+}
+
+// HandleGetPoolTx answers on conn with the requested transaction of the
+// memory pool, or with an empty one when it is not there.
+func HandleGetPoolTx(conn net.Conn, request []byte) {
+	var buff bytes.Buffer
+	var payload GetData
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		log.Panic(err)
+	}
+	result := Tx{AddrFrom: nodeAddress}
+	if tx, ok := memoryPool.Get(payload.ID); ok {
+		result.Transaction = tx.Serialize()
+	}
+	reply(conn, "pooltx", result)
+}
+
+// HandleSubmitBlock adds a block solved by an external miner, announces it
+// to the known nodes and answers on conn with the outcome.
+func HandleSubmitBlock(conn net.Conn, request []byte, chain *blockchain.BlockChain) {
+	var buff bytes.Buffer
+	var payload Block
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		log.Panic(err)
+	}
+	block := blockchain.Deserialize(payload.Block)
+
+	var result SubmitResult
+	reorg, err := chain.SubmitBlock(block)
+	if errors.Is(err, blockchain.ErrStaleTip) {
+		fmt.Printf("Stored submitted block %x, tip unchanged\n", block.Hash)
+		result.Error = err.Error()
+	} else if err != nil {
+		fmt.Printf("Rejected submitted block %x: %s\n", block.Hash, err)
+		result.Error = err.Error()
+	} else {
+		updateMemoryPool(reorg)
+		fmt.Printf("Added submitted block %x\n", block.Hash)
+		for _, node := range KnownNodes {
+			if node != nodeAddress {
+				SendInv(node, "block", [][]byte{block.Hash})
+			}
+		}
+	}
+	reply(conn, "submitted", result)
+}
+
 func HandleVersion(request []byte, chain *blockchain.BlockChain) {
 	var buff bytes.Buffer
 	var payload Version
@@ -282,9 +498,16 @@ func HandleTx(request []byte, chain *blockchain.BlockChain) {
 	}
 	txData := payload.Transaction
 	tx := blockchain.DeserializeTransaction(txData)
-	memoryPool[hex.EncodeToString(tx.ID)] = tx
+	replaced, err := memoryPool.Add(&tx)
+	if err != nil {
+		fmt.Printf("Rejecting transaction %x: %s\n", tx.ID, err)
+		return
+	}
+	for _, old := range replaced {
+		fmt.Printf("Transaction %x replaced by %x\n", old.ID, tx.ID)
+	}
 
-	fmt.Printf("%s, %d", nodeAddress, len(memoryPool))
+	fmt.Printf("%s, %d", nodeAddress, memoryPool.Len())
 	if nodeAddress == KnownNodes[0] {
 		for _, node := range KnownNodes {
 			if node != nodeAddress && node != payload.AddrFrom {
@@ -292,7 +515,7 @@ func HandleTx(request []byte, chain *blockchain.BlockChain) {
 			}
 		}
 	} else {
-		if len(memoryPool) >= 2 && len(minerAddress) > 0 {
+		if memoryPool.Len() >= 2 && len(minerAddress) > 0 {
 			MineTx(chain)
 		}
 	}
@@ -323,44 +546,43 @@ func HandleInv(request []byte, chain *blockchain.BlockChain) {
 	}
 	if payload.Type == "tx" {
 		txID := payload.Items[0]
-		if memoryPool[hex.EncodeToString(txID)].ID == nil {
+		if _, ok := memoryPool.Get(txID); !ok {
 			SendGetData(payload.AddrFrom, "tx", txID)
 		}
 	}
 }
 
 func MineTx(chain *blockchain.BlockChain) {
-	var txs []*blockchain.Transaction
-	for id := range memoryPool {
-		fmt.Printf("tx: %s\n", memoryPool[id].ID)
-		tx := memoryPool[id]
-		if chain.VerifyTransaction(&tx) {
-			txs = append(txs, &tx)
-		}
+	tmpl, err := chain.GetBlockTemplate(memoryPool.BlockCandidates())
+	if err != nil {
+		log.Panic(err)
 	}
-	if len(txs) == 0 {
+	if len(tmpl.Transactions) == 0 {
 		fmt.Println("All Transactions are invalid")
 		return
 	}
-	cbTx := blockchain.CoinBaseTx(minerAddress, "")
-	txs = append(txs, cbTx)
+	cbTx := tmpl.Coinbase(minerAddress, MinerTag)
+	txs := append([]*blockchain.Transaction{cbTx}, tmpl.Transactions...)
 
-	newBlock := chain.MineBlock(txs)
-	UTXOSet := blockchain.UTXOSet{chain}
-	UTXOSet.Reindex()
+	newBlock, err := chain.MineBlock(context.Background(), txs)
+	if err != nil {
+		fmt.Printf("Mining aborted: %s\n", err)
+		return
+	}
 	fmt.Println("New Block mined")
-
-	for _, tx := range txs {
-		txID := hex.EncodeToString(tx.ID)
-		delete(memoryPool, txID)
+	if engine, ok := chain.Engine.(*blockchain.ProofOfWorkEngine); ok {
+		pow := engine.LastSeal()
+		fmt.Printf("%x found after %d hashes in %s (%.2f kH/s)\n", newBlock.Hash, pow.Hashes, pow.Elapsed.Round(time.Millisecond), pow.Hashrate()/1000)
 	}
+
+	memoryPool.RemoveBlock(newBlock)
 	for _, node := range KnownNodes {
 		if node != nodeAddress {
 			SendInv(node, "block", [][]byte{newBlock.Hash})
 		}
 	}
 
-	if len(memoryPool) > 0 {
+	if memoryPool.Len() > 0 {
 		MineTx(chain)
 	}
 }
@@ -392,6 +614,11 @@ func HandleConnection(conn net.Conn, chain *blockchain.BlockChain) {
 	if err != nil {
 		log.Panic(err)
 	}
+	if len(req) < magicLength+commandLength || binary.BigEndian.Uint32(req) != networkMagic {
+		fmt.Println("Ignoring message from another network")
+		return
+	}
+	req = req[magicLength:]
 	command := BytesToCmd(req[:commandLength])
 	fmt.Printf("Received %s command\n", command)
 
@@ -410,14 +637,20 @@ func HandleConnection(conn net.Conn, chain *blockchain.BlockChain) {
 		HandleTx(req, chain)
 	case "version":
 		HandleVersion(req, chain)
+	case "gettemplate":
+		HandleGetTemplate(conn, req, chain)
+	case "submitblock":
+		HandleSubmitBlock(conn, req, chain)
+	case "getpooltx":
+		HandleGetPoolTx(conn, req)
 	default:
 		fmt.Println("Unknown command")
 	}
 }
 
-func StartServer(nodeID, minerAddress string) {
+func StartServer(nodeID, minerAddr string) {
 	nodeAddress = fmt.Sprintf("localhost:%s", nodeID)
-	minerAddress = minerAddress
+	minerAddress = minerAddr
 	ln, err := net.Listen(protocol, nodeAddress)
 	if err != nil {
 		log.Panic(err)
@@ -426,6 +659,18 @@ func StartServer(nodeID, minerAddress string) {
 	chain := blockchain.ContinueBlockChain(nodeID)
 	defer chain.Database.Close()
 	go CloseDB(chain)
+	memoryPool = blockchain.NewMempool(chain)
+	if poa, ok := chain.Engine.(*blockchain.ProofOfAuthority); ok && len(minerAddress) > 0 {
+		wallets, err := wallet.CreateWallets(nodeID)
+		if err != nil {
+			log.Panic(err)
+		}
+		signer, ok := wallets.Wallets[minerAddress]
+		if !ok {
+			log.Panicf("Miner address %s is not in the wallet file of node %s", minerAddress, nodeID)
+		}
+		poa.Signer = signer
+	}
 	if nodeAddress != KnownNodes[0] {
 		SendVersion(KnownNodes[0], chain)
 	}
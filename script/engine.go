@@ -0,0 +1,361 @@
+package script
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/leetcode-golang-classroom/golang-blockchain/wallet"
+)
+
+const (
+	MaxScriptSize  = 10000
+	MaxElementSize = 520
+	MaxStackSize   = 1000
+	// MaxOps bounds the number of opcodes other than pushes per script.
+	MaxOps = 201
+	// MaxMultisigKeys bounds the number of keys of OP_CHECKMULTISIG.
+	MaxMultisigKeys = 20
+	// maxNumLength is the largest number arithmetic opcodes accept.
+	maxNumLength = 4
+	// lockTimeNumLength is longer so that lock times may go past 2^31.
+	lockTimeNumLength = 5
+)
+
+var (
+	ErrScriptTooLarge        = errors.New("Script is too large")
+	ErrElementTooLarge       = errors.New("Pushed data is too large")
+	ErrStackOverflow         = errors.New("Stack holds too many elements")
+	ErrTooManyOps            = errors.New("Script executes too many opcodes")
+	ErrNotPushOnly           = errors.New("Unlocking script may only push data")
+	ErrStackUnderflow        = errors.New("Opcode needs more stack elements")
+	ErrUnbalancedConditional = errors.New("OP_IF, OP_ELSE and OP_ENDIF do not match")
+	ErrVerifyFailed          = errors.New("Verify opcode failed")
+	ErrOpReturn              = errors.New("Script executed OP_RETURN")
+	ErrBadOpcode             = errors.New("Opcode is not known")
+	ErrEvalFalse             = errors.New("Script finished with a false result")
+	ErrNumberTooBig          = errors.New("Number is longer than allowed")
+	ErrBadKeyCount           = errors.New("OP_CHECKMULTISIG key count is out of range")
+	ErrBadSigCount           = errors.New("OP_CHECKMULTISIG signature count is out of range")
+	ErrNegativeLockTime      = errors.New("Lock time may not be negative")
+	ErrUnsatisfiedLockTime   = errors.New("Lock time requirement is not satisfied")
+)
+
+// SignatureChecker answers what scripts need to know about the spending
+// transaction, which the checker knows and the script does not: whether a
+// signature over it is valid for OP_CHECKSIG, and whether its lock time and
+// the sequence of the input satisfy OP_CHECKLOCKTIMEVERIFY and
+// OP_CHECKSEQUENCEVERIFY.
+type SignatureChecker interface {
+	CheckSig(signature, pubKey []byte) bool
+	CheckLockTime(lockTime int64) bool
+	CheckSequence(sequence int64) bool
+}
+
+// Verify runs the unlocking script of an input followed by the locking
+// script of the output it spends. The output may be spent when both run
+// without error and leave a true value on top of the stack. When the locking
+// script is PayToScriptHash, the last item pushed by the unlocking script is
+// the redeem script, which then runs on the rest of the pushed items and has
+// to succeed as well.
+func Verify(unlocking, locking Script, checker SignatureChecker) error {
+	if len(unlocking) > MaxScriptSize || len(locking) > MaxScriptSize {
+		return ErrScriptTooLarge
+	}
+	if !unlocking.IsPushOnly() {
+		return ErrNotPushOnly
+	}
+	vm := engine{checker: checker}
+	if err := vm.execute(unlocking); err != nil {
+		return err
+	}
+	pushed := append([][]byte{}, vm.stack...)
+	if err := vm.execute(locking); err != nil {
+		return err
+	}
+	if err := vm.checkResult(); err != nil {
+		return err
+	}
+	if ExtractScriptHash(locking) == nil {
+		return nil
+	}
+
+	redeem := Script(pushed[len(pushed)-1])
+	vm.stack = pushed[:len(pushed)-1]
+	if err := vm.execute(redeem); err != nil {
+		return fmt.Errorf("redeem script: %w", err)
+	}
+	return vm.checkResult()
+}
+
+func (vm *engine) checkResult() error {
+	if len(vm.stack) == 0 || !asBool(vm.stack[len(vm.stack)-1]) {
+		return ErrEvalFalse
+	}
+	return nil
+}
+
+type engine struct {
+	stack   [][]byte
+	checker SignatureChecker
+	// ops counts the opcodes other than pushes run by the current script.
+	ops int
+}
+
+func (vm *engine) push(data []byte) {
+	vm.stack = append(vm.stack, data)
+}
+
+func (vm *engine) pop() ([]byte, error) {
+	if len(vm.stack) == 0 {
+		return nil, ErrStackUnderflow
+	}
+	top := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	return top, nil
+}
+
+func (vm *engine) peek() ([]byte, error) {
+	if len(vm.stack) == 0 {
+		return nil, ErrStackUnderflow
+	}
+	return vm.stack[len(vm.stack)-1], nil
+}
+
+func (vm *engine) popBool() (bool, error) {
+	data, err := vm.pop()
+	return asBool(data), err
+}
+
+func (vm *engine) popInt() (int, error) {
+	data, err := vm.pop()
+	if err != nil {
+		return 0, err
+	}
+	n, err := decodeNum(data, maxNumLength)
+	return int(n), err
+}
+
+// checkMultisig pops <sig 1> ... <sig m> <m> <key 1> ... <key n> <n> and
+// reports whether every signature belongs to one of the keys. Signatures
+// have to be in the same order as their keys.
+func (vm *engine) checkMultisig() (bool, error) {
+	n, err := vm.popInt()
+	if err != nil {
+		return false, err
+	}
+	if n < 1 || n > MaxMultisigKeys {
+		return false, ErrBadKeyCount
+	}
+	if len(vm.stack) < n {
+		return false, ErrStackUnderflow
+	}
+	keys := vm.stack[len(vm.stack)-n:]
+	vm.stack = vm.stack[:len(vm.stack)-n]
+	m, err := vm.popInt()
+	if err != nil {
+		return false, err
+	}
+	if m < 0 || m > n {
+		return false, ErrBadSigCount
+	}
+	if len(vm.stack) < m {
+		return false, ErrStackUnderflow
+	}
+	signatures := vm.stack[len(vm.stack)-m:]
+	vm.stack = vm.stack[:len(vm.stack)-m]
+
+	k := 0
+	for _, signature := range signatures {
+		for k < len(keys) && !vm.checker.CheckSig(signature, keys[k]) {
+			k++
+		}
+		if k == len(keys) {
+			return false, nil
+		}
+		k++
+	}
+	return true, nil
+}
+
+func (vm *engine) execute(s Script) error {
+	instructions, err := s.parse()
+	if err != nil {
+		return err
+	}
+	// conditions holds one entry per open OP_IF, telling whether its
+	// current branch runs.
+	var conditions []bool
+	vm.ops = 0
+	for _, in := range instructions {
+		if len(in.data) > MaxElementSize {
+			return ErrElementTooLarge
+		}
+		if in.op > OP_16 {
+			if vm.ops++; vm.ops > MaxOps {
+				return ErrTooManyOps
+			}
+		}
+		executing := true
+		for _, c := range conditions {
+			executing = executing && c
+		}
+		if !executing && (in.op < OP_IF || in.op > OP_ENDIF) {
+			continue
+		}
+		if err := vm.step(in, &conditions, executing); err != nil {
+			return fmt.Errorf("%s: %w", OpcodeName(in.op), err)
+		}
+		if len(vm.stack) > MaxStackSize {
+			return ErrStackOverflow
+		}
+	}
+	if len(conditions) != 0 {
+		return ErrUnbalancedConditional
+	}
+	return nil
+}
+
+func (vm *engine) step(in instruction, conditions *[]bool, executing bool) error {
+	switch op := in.op; {
+	case op == OP_0:
+		vm.push(nil)
+	case op <= OP_PUSHDATA4:
+		vm.push(in.data)
+	case op == OP_1NEGATE || (op >= OP_1 && op <= OP_16):
+		vm.push(encodeNum(smallInt(op)))
+
+	case op == OP_NOP:
+	case op == OP_IF || op == OP_NOTIF:
+		branch := false
+		if executing {
+			v, err := vm.popBool()
+			if err != nil {
+				return err
+			}
+			branch = v == (op == OP_IF)
+		}
+		*conditions = append(*conditions, branch)
+	case op == OP_ELSE:
+		if len(*conditions) == 0 {
+			return ErrUnbalancedConditional
+		}
+		last := len(*conditions) - 1
+		(*conditions)[last] = !(*conditions)[last]
+	case op == OP_ENDIF:
+		if len(*conditions) == 0 {
+			return ErrUnbalancedConditional
+		}
+		*conditions = (*conditions)[:len(*conditions)-1]
+	case op == OP_VERIFY:
+		v, err := vm.popBool()
+		if err != nil {
+			return err
+		}
+		if !v {
+			return ErrVerifyFailed
+		}
+	case op == OP_RETURN:
+		return ErrOpReturn
+
+	case op == OP_DROP:
+		_, err := vm.pop()
+		return err
+	case op == OP_DUP:
+		top, err := vm.peek()
+		if err != nil {
+			return err
+		}
+		vm.push(top)
+	case op == OP_SWAP:
+		if len(vm.stack) < 2 {
+			return ErrStackUnderflow
+		}
+		n := len(vm.stack)
+		vm.stack[n-1], vm.stack[n-2] = vm.stack[n-2], vm.stack[n-1]
+	case op == OP_SIZE:
+		top, err := vm.peek()
+		if err != nil {
+			return err
+		}
+		vm.push(encodeNum(int64(len(top))))
+
+	case op == OP_EQUAL || op == OP_EQUALVERIFY:
+		a, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		b, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		vm.push(fromBool(bytes.Equal(a, b)))
+		if op == OP_EQUALVERIFY {
+			return vm.step(instruction{op: OP_VERIFY}, conditions, executing)
+		}
+
+	case op == OP_SHA256:
+		data, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(data)
+		vm.push(hash[:])
+	case op == OP_HASH160:
+		data, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		vm.push(wallet.PublicKeyHash(data))
+	case op == OP_CHECKSIG || op == OP_CHECKSIGVERIFY:
+		pubKey, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		signature, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		vm.push(fromBool(vm.checker.CheckSig(signature, pubKey)))
+		if op == OP_CHECKSIGVERIFY {
+			return vm.step(instruction{op: OP_VERIFY}, conditions, executing)
+		}
+	case op == OP_CHECKLOCKTIMEVERIFY || op == OP_CHECKSEQUENCEVERIFY:
+		// Both leave their argument on the stack, scripts usually follow
+		// them with OP_DROP.
+		top, err := vm.peek()
+		if err != nil {
+			return err
+		}
+		n, err := decodeNum(top, lockTimeNumLength)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return ErrNegativeLockTime
+		}
+		var ok bool
+		if op == OP_CHECKLOCKTIMEVERIFY {
+			ok = vm.checker.CheckLockTime(n)
+		} else {
+			ok = vm.checker.CheckSequence(n)
+		}
+		if !ok {
+			return ErrUnsatisfiedLockTime
+		}
+	case op == OP_CHECKMULTISIG || op == OP_CHECKMULTISIGVERIFY:
+		ok, err := vm.checkMultisig()
+		if err != nil {
+			return err
+		}
+		vm.push(fromBool(ok))
+		if op == OP_CHECKMULTISIGVERIFY {
+			return vm.step(instruction{op: OP_VERIFY}, conditions, executing)
+		}
+
+	default:
+		return ErrBadOpcode
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package script
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/leetcode-golang-classroom/golang-blockchain/wallet"
+)
+
+// testChecker accepts a signature that is "sig:" followed by the key, and
+// lock times and sequences up to its own.
+type testChecker struct {
+	lockTime int64
+	sequence int64
+}
+
+func (c testChecker) CheckSig(signature, pubKey []byte) bool {
+	return bytes.Equal(signature, testSig(pubKey))
+}
+
+func (c testChecker) CheckLockTime(lockTime int64) bool {
+	return lockTime <= c.lockTime
+}
+
+func (c testChecker) CheckSequence(sequence int64) bool {
+	return sequence <= c.sequence
+}
+
+func testSig(pubKey []byte) []byte {
+	return append([]byte("sig:"), pubKey...)
+}
+
+func pushes(items ...[]byte) Script {
+	b := NewBuilder()
+	for _, item := range items {
+		b.AddData(item)
+	}
+	return b.Script()
+}
+
+const (
+	op2 = OP_1 + 1
+	op3 = OP_1 + 2
+)
+
+func ops(opcodes ...byte) Script {
+	return Script(opcodes)
+}
+
+func TestVerify(t *testing.T) {
+	keys := [][]byte{[]byte("key one"), []byte("key two"), []byte("key three")}
+	redeem, err := MultiSig(2, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("secret")
+	secretHash := sha256.Sum256(secret)
+	manyOps := append(bytes.Repeat([]byte{OP_NOP}, MaxOps+1), OP_1)
+	lockAt := func(n int64, op byte) Script {
+		return NewBuilder().AddInt(n).AddOp(op).AddOp(OP_DROP).AddOp(OP_1).Script()
+	}
+
+	tests := []struct {
+		name      string
+		unlocking Script
+		locking   Script
+		want      error
+	}{
+		{"true", nil, ops(OP_1), nil},
+		{"false", nil, ops(OP_0), ErrEvalFalse},
+		{"empty stack", nil, nil, ErrEvalFalse},
+		{"equal", pushes([]byte("a"), []byte("a")), ops(OP_EQUAL), nil},
+		{"not equal", pushes([]byte("a"), []byte("b")), ops(OP_EQUAL), ErrEvalFalse},
+		{"equalverify", pushes([]byte("a"), []byte("b")), ops(OP_EQUALVERIFY, OP_1), ErrVerifyFailed},
+		{"if", ops(OP_1), ops(OP_IF, OP_1, OP_ELSE, OP_0, OP_ENDIF), nil},
+		{"else", ops(OP_0), ops(OP_IF, OP_1, OP_ELSE, OP_0, OP_ENDIF), ErrEvalFalse},
+		{"notif", ops(OP_0), ops(OP_NOTIF, OP_1, OP_ELSE, OP_0, OP_ENDIF), nil},
+		{"nested skipped if", ops(OP_0), ops(OP_IF, OP_IF, OP_RETURN, OP_ENDIF, OP_ENDIF, OP_1), nil},
+		{"unbalanced if", ops(OP_1), ops(OP_IF, OP_1), ErrUnbalancedConditional},
+		{"unbalanced endif", nil, ops(OP_ENDIF, OP_1), ErrUnbalancedConditional},
+		{"return", nil, ops(OP_RETURN), ErrOpReturn},
+		{"drop underflow", nil, ops(OP_DROP, OP_1), ErrStackUnderflow},
+		{"swap", ops(op2, op3), ops(OP_SWAP, op2, OP_EQUALVERIFY, op3, OP_EQUAL), nil},
+		{"dup", ops(op2), ops(OP_DUP, OP_EQUAL), nil},
+		{"size", pushes([]byte("abc")), ops(OP_SIZE, op3, OP_EQUALVERIFY), nil},
+		{"sha256", pushes(secret), append(append(ops(OP_SHA256), pushes(secretHash[:])...), OP_EQUAL), nil},
+		{"bad opcode", nil, ops(0xff), ErrBadOpcode},
+		{"unlocking not push only", ops(OP_1, OP_DUP), ops(OP_EQUAL), ErrNotPushOnly},
+		{"too many ops", nil, manyOps, ErrTooManyOps},
+		{"element too large", pushes(make([]byte, MaxElementSize+1)), ops(OP_1), ErrElementTooLarge},
+
+		{"p2pkh", PubKeyHashUnlock(testSig(keys[0]), keys[0]), PayToPubKeyHash(wallet.PublicKeyHash(keys[0])), nil},
+		{"p2pkh bad signature", PubKeyHashUnlock(testSig(keys[1]), keys[0]), PayToPubKeyHash(wallet.PublicKeyHash(keys[0])), ErrEvalFalse},
+		{"p2pkh wrong key", PubKeyHashUnlock(testSig(keys[1]), keys[1]), PayToPubKeyHash(wallet.PublicKeyHash(keys[0])), ErrVerifyFailed},
+
+		{"multisig", pushes(testSig(keys[0]), testSig(keys[2])), redeem, nil},
+		{"multisig out of order", pushes(testSig(keys[2]), testSig(keys[0])), redeem, ErrEvalFalse},
+		{"multisig same key twice", pushes(testSig(keys[1]), testSig(keys[1])), redeem, ErrEvalFalse},
+		{"multisig missing signature", pushes(testSig(keys[0])), redeem, ErrStackUnderflow},
+		{"multisig bad key count", ops(OP_0, OP_0), ops(OP_CHECKMULTISIG), ErrBadKeyCount},
+		{"multisig bad signature count", nil, NewBuilder().AddInt(2).AddData(keys[0]).AddInt(1).AddOp(OP_CHECKMULTISIG).Script(), ErrBadSigCount},
+
+		{"p2sh", ScriptHashUnlock([][]byte{testSig(keys[1]), testSig(keys[2])}, redeem), PayToScriptHash(redeem.Hash()), nil},
+		{"p2sh redeem fails", ScriptHashUnlock([][]byte{testSig(keys[1])}, redeem), PayToScriptHash(redeem.Hash()), ErrStackUnderflow},
+		{"p2sh false redeem", ScriptHashUnlock([][]byte{testSig(keys[2]), testSig(keys[1])}, redeem), PayToScriptHash(redeem.Hash()), ErrEvalFalse},
+		{"p2sh wrong script", ScriptHashUnlock(nil, ops(OP_1)), PayToScriptHash(redeem.Hash()), ErrEvalFalse},
+
+		{"cltv reached", nil, lockAt(100, OP_CHECKLOCKTIMEVERIFY), nil},
+		{"cltv not reached", nil, lockAt(101, OP_CHECKLOCKTIMEVERIFY), ErrUnsatisfiedLockTime},
+		{"cltv negative", nil, lockAt(-1, OP_CHECKLOCKTIMEVERIFY), ErrNegativeLockTime},
+		{"cltv past 2^31", nil, lockAt(1<<32, OP_CHECKLOCKTIMEVERIFY), ErrUnsatisfiedLockTime},
+		{"cltv empty stack", nil, ops(OP_CHECKLOCKTIMEVERIFY), ErrStackUnderflow},
+		{"csv reached", nil, lockAt(10, OP_CHECKSEQUENCEVERIFY), nil},
+		{"csv not reached", nil, lockAt(11, OP_CHECKSEQUENCEVERIFY), ErrUnsatisfiedLockTime},
+		{"csv negative", nil, lockAt(-1, OP_CHECKSEQUENCEVERIFY), ErrNegativeLockTime},
+	}
+	checker := testChecker{lockTime: 100, sequence: 10}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Verify(test.unlocking, test.locking, checker)
+			if !errors.Is(err, test.want) {
+				t.Errorf("Verify(%s, %s) = %v, want %v", test.unlocking, test.locking, err, test.want)
+			}
+		})
+	}
+}
+
+// recordingChecker remembers which lock checks a script asked for.
+type recordingChecker struct {
+	testChecker
+	calls *[]string
+}
+
+func (c recordingChecker) CheckLockTime(lockTime int64) bool {
+	*c.calls = append(*c.calls, "locktime")
+	return c.testChecker.CheckLockTime(lockTime)
+}
+
+func (c recordingChecker) CheckSequence(sequence int64) bool {
+	*c.calls = append(*c.calls, "sequence")
+	return c.testChecker.CheckSequence(sequence)
+}
+
+func TestLockTimeOpsCheckOnlyTheirLock(t *testing.T) {
+	tests := []struct {
+		op   byte
+		n    int64
+		want string
+	}{
+		{OP_CHECKLOCKTIMEVERIFY, 50, "locktime"},
+		{OP_CHECKSEQUENCEVERIFY, 5, "sequence"},
+	}
+	for _, test := range tests {
+		var calls []string
+		checker := recordingChecker{testChecker{lockTime: 100, sequence: 10}, &calls}
+		locking := NewBuilder().AddInt(test.n).AddOp(test.op).AddOp(OP_DROP).AddOp(OP_1).Script()
+		if err := Verify(nil, locking, checker); err != nil {
+			t.Errorf("%s: %v", locking, err)
+		}
+		if len(calls) != 1 || calls[0] != test.want {
+			t.Errorf("%s checked %v, want only %s", locking, calls, test.want)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package script
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// Script is a serialized program: a sequence of opcodes and data pushes.
+type Script []byte
+
+var ErrMalformedScript = errors.New("Script ends in the middle of a data push")
+
+// instruction is one parsed opcode together with the data it pushes.
+type instruction struct {
+	op   byte
+	data []byte
+}
+
+func (s Script) parse() ([]instruction, error) {
+	var instructions []instruction
+	for i := 0; i < len(s); {
+		op := s[i]
+		i++
+		var size int
+		switch {
+		case op >= OP_DATA_1 && op <= OP_DATA_75:
+			size = int(op)
+		case op == OP_PUSHDATA1:
+			if i+1 > len(s) {
+				return nil, ErrMalformedScript
+			}
+			size = int(s[i])
+			i++
+		case op == OP_PUSHDATA2:
+			if i+2 > len(s) {
+				return nil, ErrMalformedScript
+			}
+			size = int(binary.LittleEndian.Uint16(s[i:]))
+			i += 2
+		case op == OP_PUSHDATA4:
+			if i+4 > len(s) {
+				return nil, ErrMalformedScript
+			}
+			size = int(binary.LittleEndian.Uint32(s[i:]))
+			i += 4
+		}
+		if size < 0 || i+size > len(s) {
+			return nil, ErrMalformedScript
+		}
+		in := instruction{op: op}
+		if op >= OP_DATA_1 && op <= OP_PUSHDATA4 {
+			in.data = s[i : i+size]
+		}
+		i += size
+		instructions = append(instructions, in)
+	}
+	return instructions, nil
+}
+
+// IsPushOnly reports whether the script does nothing but push data.
+func (s Script) IsPushOnly() bool {
+	instructions, err := s.parse()
+	if err != nil {
+		return false
+	}
+	for _, in := range instructions {
+		if in.op > OP_16 {
+			return false
+		}
+	}
+	return true
+}
+
+// IsUnspendable reports whether no unlocking script can satisfy s, so that
+// outputs locked with it never have to be tracked as unspent.
+func (s Script) IsUnspendable() bool {
+	return (len(s) > 0 && s[0] == OP_RETURN) || len(s) > MaxScriptSize
+}
+
+// PushedData returns the data pushed by a push only script in order.
+func (s Script) PushedData() ([][]byte, error) {
+	instructions, err := s.parse()
+	if err != nil {
+		return nil, err
+	}
+	var pushes [][]byte
+	for _, in := range instructions {
+		switch {
+		case in.op > OP_16:
+			return nil, ErrNotPushOnly
+		case in.op == OP_0:
+			pushes = append(pushes, nil)
+		case in.op <= OP_PUSHDATA4:
+			pushes = append(pushes, in.data)
+		default:
+			pushes = append(pushes, encodeNum(smallInt(in.op)))
+		}
+	}
+	return pushes, nil
+}
+
+// String disassembles the script, showing pushed data as hex.
+func (s Script) String() string {
+	instructions, err := s.parse()
+	if err != nil {
+		return "[malformed] " + hex.EncodeToString(s)
+	}
+	var words []string
+	for _, in := range instructions {
+		if in.op >= OP_DATA_1 && in.op <= OP_PUSHDATA4 {
+			words = append(words, hex.EncodeToString(in.data))
+		} else {
+			words = append(words, OpcodeName(in.op))
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// Builder assembles scripts, choosing the smallest encoding for pushes.
+type Builder struct {
+	script Script
+}
+
+func NewBuilder() *Builder {
+	return &Builder{}
+}
+
+func (b *Builder) AddOp(op byte) *Builder {
+	b.script = append(b.script, op)
+	return b
+}
+
+func (b *Builder) AddData(data []byte) *Builder {
+	size := len(data)
+	switch {
+	case size == 0:
+		b.script = append(b.script, OP_0)
+	case size <= OP_DATA_75:
+		b.script = append(b.script, byte(size))
+	case size <= 0xff:
+		b.script = append(b.script, OP_PUSHDATA1, byte(size))
+	case size <= 0xffff:
+		b.script = append(b.script, OP_PUSHDATA2, 0, 0)
+		binary.LittleEndian.PutUint16(b.script[len(b.script)-2:], uint16(size))
+	default:
+		b.script = append(b.script, OP_PUSHDATA4, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(b.script[len(b.script)-4:], uint32(size))
+	}
+	b.script = append(b.script, data...)
+	return b
+}
+
+func (b *Builder) AddInt(n int64) *Builder {
+	switch {
+	case n == 0:
+		return b.AddOp(OP_0)
+	case n == -1:
+		return b.AddOp(OP_1NEGATE)
+	case n >= 1 && n <= 16:
+		return b.AddOp(byte(OP_1 - 1 + n))
+	}
+	return b.AddData(encodeNum(n))
+}
+
+func (b *Builder) Script() Script {
+	return append(Script{}, b.script...)
+}
+
+// smallInt returns the number pushed by OP_1NEGATE or OP_1 to OP_16.
+func smallInt(op byte) int64 {
+	if op == OP_1NEGATE {
+		return -1
+	}
+	return int64(op-OP_1) + 1
+}
+
+// encodeNum encodes n the way script numbers are kept on the stack: little
+// endian magnitude with the sign in the top bit of the last byte.
+func encodeNum(n int64) []byte {
+	if n == 0 {
+		return nil
+	}
+	negative := n < 0
+	if negative {
+		n = -n
+	}
+	var result []byte
+	for n > 0 {
+		result = append(result, byte(n&0xff))
+		n >>= 8
+	}
+	if result[len(result)-1]&0x80 != 0 {
+		extra := byte(0)
+		if negative {
+			extra = 0x80
+		}
+		result = append(result, extra)
+	} else if negative {
+		result[len(result)-1] |= 0x80
+	}
+	return result
+}
+
+// decodeNum is the inverse of encodeNum for numbers of at most maxLen bytes.
+func decodeNum(data []byte, maxLen int) (int64, error) {
+	if len(data) > maxLen {
+		return 0, ErrNumberTooBig
+	}
+	if len(data) == 0 {
+		return 0, nil
+	}
+	var n int64
+	for i, b := range data {
+		n |= int64(b) << uint(8*i)
+	}
+	if data[len(data)-1]&0x80 != 0 {
+		n &^= int64(0x80) << uint(8*(len(data)-1))
+		return -n, nil
+	}
+	return n, nil
+}
+
+// asBool interprets stack data as a boolean: anything but zero and negative
+// zero is true.
+func asBool(data []byte) bool {
+	for i, b := range data {
+		if b != 0 {
+			return !(i == len(data)-1 && b == 0x80)
+		}
+	}
+	return false
+}
+
+func fromBool(v bool) []byte {
+	if v {
+		return []byte{1}
+	}
+	return nil
+}
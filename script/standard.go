@@ -0,0 +1,231 @@
+package script
+
+import (
+	"bytes"
+
+	"github.com/leetcode-golang-classroom/golang-blockchain/wallet"
+)
+
+const pubKeyHashLength = 20
+
+// PayToPubKeyHash returns the standard locking script that can be spent by
+// the owner of the key hashing to pubKeyHash:
+//
+//	OP_DUP OP_HASH160 <pubKeyHash> OP_EQUALVERIFY OP_CHECKSIG
+func PayToPubKeyHash(pubKeyHash []byte) Script {
+	return NewBuilder().
+		AddOp(OP_DUP).
+		AddOp(OP_HASH160).
+		AddData(pubKeyHash).
+		AddOp(OP_EQUALVERIFY).
+		AddOp(OP_CHECKSIG).
+		Script()
+}
+
+// PubKeyHashUnlock returns the unlocking script for a PayToPubKeyHash
+// output: <signature> <pubKey>.
+func PubKeyHashUnlock(signature, pubKey []byte) Script {
+	return NewBuilder().AddData(signature).AddData(pubKey).Script()
+}
+
+// ExtractPubKeyHash returns the key hash of a PayToPubKeyHash script, or
+// nil when s is not one.
+func ExtractPubKeyHash(s Script) []byte {
+	if len(s) != pubKeyHashLength+5 ||
+		s[0] != OP_DUP || s[1] != OP_HASH160 || s[2] != pubKeyHashLength ||
+		s[len(s)-2] != OP_EQUALVERIFY || s[len(s)-1] != OP_CHECKSIG {
+		return nil
+	}
+	return append([]byte{}, s[3:3+pubKeyHashLength]...)
+}
+
+// MultiSig returns a script that is satisfied by signatures of any m of the
+// given keys:
+//
+//	<m> <pubKey 1> ... <pubKey n> <n> OP_CHECKMULTISIG
+func MultiSig(m int, pubKeys [][]byte) (Script, error) {
+	if len(pubKeys) < 1 || len(pubKeys) > MaxMultisigKeys {
+		return nil, ErrBadKeyCount
+	}
+	if m < 1 || m > len(pubKeys) {
+		return nil, ErrBadSigCount
+	}
+	b := NewBuilder().AddInt(int64(m))
+	for _, pubKey := range pubKeys {
+		b.AddData(pubKey)
+	}
+	return b.AddInt(int64(len(pubKeys))).AddOp(OP_CHECKMULTISIG).Script(), nil
+}
+
+// ExtractMultiSig returns the required number of signatures and the keys of
+// a MultiSig script, or ok false when s is not one.
+func ExtractMultiSig(s Script) (m int, pubKeys [][]byte, ok bool) {
+	instructions, err := s.parse()
+	if err != nil || len(instructions) < 4 {
+		return 0, nil, false
+	}
+	last := len(instructions) - 1
+	first, count := instructions[0].op, instructions[last-1].op
+	if instructions[last].op != OP_CHECKMULTISIG ||
+		first < OP_1 || first > OP_16 || count < OP_1 || count > OP_16 {
+		return 0, nil, false
+	}
+	m, n := int(smallInt(first)), int(smallInt(count))
+	if n != last-2 || m > n {
+		return 0, nil, false
+	}
+	for _, in := range instructions[1 : last-1] {
+		if in.op < OP_DATA_1 || in.op > OP_PUSHDATA4 {
+			return 0, nil, false
+		}
+		pubKeys = append(pubKeys, in.data)
+	}
+	return m, pubKeys, true
+}
+
+// PayToScriptHash returns the locking script of an output that is spent by
+// revealing a script hashing to scriptHash and satisfying it:
+//
+//	OP_HASH160 <scriptHash> OP_EQUAL
+func PayToScriptHash(scriptHash []byte) Script {
+	return NewBuilder().
+		AddOp(OP_HASH160).
+		AddData(scriptHash).
+		AddOp(OP_EQUAL).
+		Script()
+}
+
+// ScriptHashUnlock returns the unlocking script for a PayToScriptHash
+// output: the items the redeem script needs followed by the redeem script.
+func ScriptHashUnlock(items [][]byte, redeem Script) Script {
+	b := NewBuilder()
+	for _, item := range items {
+		b.AddData(item)
+	}
+	return b.AddData(redeem).Script()
+}
+
+// ExtractScriptHash returns the script hash of a PayToScriptHash script, or
+// nil when s is not one.
+func ExtractScriptHash(s Script) []byte {
+	if len(s) != pubKeyHashLength+3 ||
+		s[0] != OP_HASH160 || s[1] != pubKeyHashLength || s[len(s)-1] != OP_EQUAL {
+		return nil
+	}
+	return append([]byte{}, s[2:2+pubKeyHashLength]...)
+}
+
+// Hash returns the hash a PayToScriptHash output commits to.
+func (s Script) Hash() []byte {
+	return wallet.PublicKeyHash(s)
+}
+
+// SecretLength is the length of HTLC secrets. The contract checks it so that
+// a secret accepted by one chain is accepted by the other as well.
+const SecretLength = 32
+
+// HashTimeLock describes a hash time-locked contract: the recipient may
+// spend with the preimage of SecretHash, the refund key may spend once
+// LockTime has passed.
+type HashTimeLock struct {
+	SecretHash    []byte
+	RecipientHash []byte
+	RefundHash    []byte
+	LockTime      int64
+}
+
+// Script returns the contract script:
+//
+//	OP_IF
+//	    OP_SIZE <32> OP_EQUALVERIFY OP_SHA256 <secretHash> OP_EQUALVERIFY
+//	    OP_DUP OP_HASH160 <recipientHash>
+//	OP_ELSE
+//	    <lockTime> OP_CHECKLOCKTIMEVERIFY OP_DROP
+//	    OP_DUP OP_HASH160 <refundHash>
+//	OP_ENDIF
+//	OP_EQUALVERIFY OP_CHECKSIG
+func (h HashTimeLock) Script() Script {
+	return NewBuilder().
+		AddOp(OP_IF).
+		AddOp(OP_SIZE).AddInt(SecretLength).AddOp(OP_EQUALVERIFY).
+		AddOp(OP_SHA256).AddData(h.SecretHash).AddOp(OP_EQUALVERIFY).
+		AddOp(OP_DUP).AddOp(OP_HASH160).AddData(h.RecipientHash).
+		AddOp(OP_ELSE).
+		AddInt(h.LockTime).AddOp(OP_CHECKLOCKTIMEVERIFY).AddOp(OP_DROP).
+		AddOp(OP_DUP).AddOp(OP_HASH160).AddData(h.RefundHash).
+		AddOp(OP_ENDIF).
+		AddOp(OP_EQUALVERIFY).AddOp(OP_CHECKSIG).
+		Script()
+}
+
+// ExtractHashTimeLock returns the contract of an HTLC script, or ok false
+// when s is not one.
+func ExtractHashTimeLock(s Script) (h HashTimeLock, ok bool) {
+	instructions, err := s.parse()
+	if err != nil || len(instructions) != 20 {
+		return h, false
+	}
+	lock := instructions[11]
+	lockTime, err := decodeNum(lock.data, lockTimeNumLength)
+	if lock.op == OP_1NEGATE || (lock.op >= OP_1 && lock.op <= OP_16) {
+		lockTime = smallInt(lock.op)
+	}
+	if err != nil || lockTime < 0 {
+		return h, false
+	}
+	h = HashTimeLock{
+		SecretHash:    instructions[5].data,
+		RecipientHash: instructions[9].data,
+		RefundHash:    instructions[16].data,
+		LockTime:      lockTime,
+	}
+	if !bytes.Equal(h.Script(), s) {
+		return HashTimeLock{}, false
+	}
+	return h, true
+}
+
+// HashTimeLockRedeem returns the unlocking script with which the recipient
+// spends an HTLC paid to with PayToScriptHash.
+func HashTimeLockRedeem(signature, pubKey, secret []byte, contract Script) Script {
+	return ScriptHashUnlock([][]byte{signature, pubKey, secret, {1}}, contract)
+}
+
+// HashTimeLockRefund returns the unlocking script with which the sender
+// takes back an HTLC paid to with PayToScriptHash after its lock time.
+func HashTimeLockRefund(signature, pubKey []byte, contract Script) Script {
+	return ScriptHashUnlock([][]byte{signature, pubKey, nil}, contract)
+}
+
+// ExtractHashTimeLockSecret returns the secret revealed by an unlocking
+// script redeeming contract, or nil when it does not.
+func ExtractHashTimeLockSecret(unlocking, contract Script) []byte {
+	pushes, err := unlocking.PushedData()
+	if err != nil || len(pushes) != 5 || !bytes.Equal(pushes[4], contract) || !asBool(pushes[3]) {
+		return nil
+	}
+	return pushes[2]
+}
+
+// NullData returns a provably unspendable script carrying data:
+//
+//	OP_RETURN <data>
+func NullData(data []byte) Script {
+	return NewBuilder().AddOp(OP_RETURN).AddData(data).Script()
+}
+
+// ExtractNullData returns the data carried by a NullData script, or ok false
+// when s is not one.
+func ExtractNullData(s Script) (data []byte, ok bool) {
+	instructions, err := s.parse()
+	if err != nil || len(instructions) == 0 || len(instructions) > 2 || instructions[0].op != OP_RETURN {
+		return nil, false
+	}
+	if len(instructions) == 1 {
+		return nil, true
+	}
+	if instructions[1].op > OP_PUSHDATA4 {
+		return nil, false
+	}
+	return instructions[1].data, true
+}
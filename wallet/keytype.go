@@ -0,0 +1,118 @@
+package wallet
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+)
+
+// KeyType identifies the signature scheme of a key. It is recorded in the
+// first byte of the public key, and so committed to by its hash, and in the
+// version of the key's address.
+type KeyType byte
+
+const (
+	// KeyP256 is ECDSA over NIST P-256. Its public keys are compressed SEC1
+	// points, which start with 0x02 or 0x03.
+	KeyP256 KeyType = 0x02
+	// KeyEd25519 is Ed25519. Its public keys are 0xed followed by the 32
+	// byte key.
+	KeyEd25519 KeyType = 0xed
+)
+
+// Ed25519Version is the first byte of addresses of Ed25519 keys.
+var Ed25519Version = byte(0x0f)
+
+var keyTypeNames = map[KeyType]string{
+	KeyP256:    "p256",
+	KeyEd25519: "ed25519",
+}
+
+func (t KeyType) String() string {
+	if name, ok := keyTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("KeyType(%#x)", byte(t))
+}
+
+// ParseKeyType returns the key type called name, p256 or ed25519.
+func ParseKeyType(name string) (KeyType, error) {
+	for t, n := range keyTypeNames {
+		if n == name {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown key type %q", name)
+}
+
+// AddressVersion returns the version of the addresses of keys of type t.
+func (t KeyType) AddressVersion() byte {
+	if t == KeyEd25519 {
+		return Ed25519Version
+	}
+	return Version
+}
+
+// PublicKeyType returns the type of a serialized public key.
+func PublicKeyType(pubKey []byte) (KeyType, error) {
+	if len(pubKey) != PublicKeyLength {
+		return 0, ErrInvalidPublicKey
+	}
+	switch pubKey[0] {
+	case 0x02, 0x03:
+		return KeyP256, nil
+	case byte(KeyEd25519):
+		return KeyEd25519, nil
+	}
+	return 0, ErrInvalidPublicKey
+}
+
+// generateKey returns a new private key of type t.
+func generateKey(t KeyType) (crypto.Signer, error) {
+	switch t {
+	case KeyP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyEd25519:
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		return private, err
+	}
+	return nil, fmt.Errorf("Unknown key type %s", t)
+}
+
+// marshalPrivateKey returns the key type followed by the 32 byte P-256
+// scalar or Ed25519 seed.
+func marshalPrivateKey(priKey crypto.Signer) []byte {
+	switch k := priKey.(type) {
+	case *ecdsa.PrivateKey:
+		return append([]byte{byte(KeyP256)}, k.D.FillBytes(make([]byte, scalarLength))...)
+	case ed25519.PrivateKey:
+		return append([]byte{byte(KeyEd25519)}, k.Seed()...)
+	}
+	panic(fmt.Sprintf("unsupported private key %T", priKey))
+}
+
+func unmarshalPrivateKey(data []byte) (crypto.Signer, error) {
+	if len(data) != 1+scalarLength {
+		return nil, ErrWalletFileFormat
+	}
+	key := data[1:]
+	switch KeyType(data[0]) {
+	case KeyP256:
+		curve := elliptic.P256()
+		d := new(big.Int).SetBytes(key)
+		if d.Sign() == 0 || d.Cmp(curve.Params().N) >= 0 {
+			return nil, ErrWalletFileFormat
+		}
+		private := &ecdsa.PrivateKey{D: d}
+		private.PublicKey.Curve = curve
+		private.PublicKey.X, private.PublicKey.Y = curve.ScalarBaseMult(key)
+		return private, nil
+	case KeyEd25519:
+		return ed25519.NewKeyFromSeed(key), nil
+	}
+	return nil, ErrWalletFileFormat
+}
@@ -0,0 +1,186 @@
+package wallet
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+const (
+	// PublicKeyLength is the size of a public key of any type.
+	PublicKeyLength = 33
+	// SignatureLength is the size of a signature of any type. For P-256 it
+	// is r followed by s, each padded to 32 bytes.
+	SignatureLength = 64
+	scalarLength    = 32
+)
+
+var ErrInvalidPublicKey = errors.New("Public key is not a compressed P-256 or an Ed25519 key")
+
+// SerializePublicKey returns the encoding of pub, which starts with its
+// KeyType.
+func SerializePublicKey(pub crypto.PublicKey) []byte {
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		return elliptic.MarshalCompressed(k.Curve, k.X, k.Y)
+	case ed25519.PublicKey:
+		return append([]byte{byte(KeyEd25519)}, k...)
+	}
+	panic(fmt.Sprintf("unsupported public key %T", pub))
+}
+
+// ParsePublicKey decodes a public key written by SerializePublicKey. Any
+// other encoding, including the uncompressed X followed by Y of older
+// wallets, is rejected.
+func ParsePublicKey(data []byte) (crypto.PublicKey, error) {
+	keyType, err := PublicKeyType(data)
+	if err != nil {
+		return nil, err
+	}
+	if keyType == KeyEd25519 {
+		return ed25519.PublicKey(data[1:]), nil
+	}
+	curve := elliptic.P256()
+	x, y := elliptic.UnmarshalCompressed(curve, data)
+	if x == nil {
+		return nil, ErrInvalidPublicKey
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+// Sign signs hash with priKey. Both schemes are deterministic: P-256 uses a
+// nonce derived from the key and the hash as in RFC 6979, so the same key
+// and hash always give the same signature. P-256 signatures are given the
+// low s of the pair s and N-s that verify, see VerifySignature.
+func Sign(priKey crypto.Signer, hash []byte) []byte {
+	switch k := priKey.(type) {
+	case *ecdsa.PrivateKey:
+		return signP256(k, hash)
+	case ed25519.PrivateKey:
+		return ed25519.Sign(k, hash)
+	}
+	panic(fmt.Sprintf("unsupported private key %T", priKey))
+}
+
+func signP256(priKey *ecdsa.PrivateKey, hash []byte) []byte {
+	curve := priKey.Curve
+	n := curve.Params().N
+	e := hashToInt(hash, n)
+	nonces := newNonceGenerator(priKey.D, e, n)
+	for {
+		k := nonces.next()
+		x, _ := curve.ScalarBaseMult(k.FillBytes(make([]byte, scalarLength)))
+		r := new(big.Int).Mod(x, n)
+		if r.Sign() == 0 {
+			continue
+		}
+		s := new(big.Int).Mul(r, priKey.D)
+		s.Add(s, e)
+		s.Mul(s, new(big.Int).ModInverse(k, n))
+		s.Mod(s, n)
+		if s.Sign() == 0 {
+			continue
+		}
+		if isHighS(s, n) {
+			s.Sub(n, s)
+		}
+		signature := make([]byte, SignatureLength)
+		r.FillBytes(signature[:scalarLength])
+		s.FillBytes(signature[scalarLength:])
+		return signature
+	}
+}
+
+// VerifySignature checks a signature made by Sign against a public key,
+// using the scheme of the key. A P-256 signature with a high s is rejected:
+// anyone could otherwise replace s by N-s and change the ID of a
+// transaction without invalidating it.
+func VerifySignature(pubKey, hash, signature []byte) bool {
+	if len(signature) != SignatureLength {
+		return false
+	}
+	pub, err := ParsePublicKey(pubKey)
+	if err != nil {
+		return false
+	}
+	switch k := pub.(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, hash, signature)
+	case *ecdsa.PublicKey:
+		r := new(big.Int).SetBytes(signature[:scalarLength])
+		s := new(big.Int).SetBytes(signature[scalarLength:])
+		if isHighS(s, k.Curve.Params().N) {
+			return false
+		}
+		return ecdsa.Verify(k, hash, r, s)
+	}
+	return false
+}
+
+// isHighS reports whether s is above half the group order n.
+func isHighS(s, n *big.Int) bool {
+	return s.Cmp(new(big.Int).Rsh(n, 1)) > 0
+}
+
+// hashToInt keeps the leftmost bits of hash, as many as n has.
+func hashToInt(hash []byte, n *big.Int) *big.Int {
+	orderBits := n.BitLen()
+	orderBytes := (orderBits + 7) / 8
+	if len(hash) > orderBytes {
+		hash = hash[:orderBytes]
+	}
+	e := new(big.Int).SetBytes(hash)
+	if excess := len(hash)*8 - orderBits; excess > 0 {
+		e.Rsh(e, uint(excess))
+	}
+	return e
+}
+
+// nonceGenerator is the HMAC-SHA256 generator of RFC 6979 section 3.2.
+type nonceGenerator struct {
+	k, v []byte
+	n    *big.Int
+}
+
+func newNonceGenerator(d, e, n *big.Int) *nonceGenerator {
+	x := d.FillBytes(make([]byte, scalarLength))
+	h := new(big.Int).Mod(e, n).FillBytes(make([]byte, scalarLength))
+	g := &nonceGenerator{make([]byte, sha256.Size), make([]byte, sha256.Size), n}
+	for i := range g.v {
+		g.v[i] = 0x01
+	}
+	for _, sep := range []byte{0x00, 0x01} {
+		g.k = g.mac(g.v, []byte{sep}, x, h)
+		g.v = g.mac(g.v)
+	}
+	return g
+}
+
+func (g *nonceGenerator) mac(data ...[]byte) []byte {
+	m := hmac.New(sha256.New, g.k)
+	for _, d := range data {
+		m.Write(d)
+	}
+	return m.Sum(nil)
+}
+
+// next returns the next candidate nonce in [1, n-1].
+func (g *nonceGenerator) next() *big.Int {
+	for {
+		g.v = g.mac(g.v)
+		k := hashToInt(g.v, g.n)
+		// the state moves on either way, so a nonce rejected by the caller
+		// is never returned twice
+		g.k = g.mac(g.v, []byte{0x00})
+		g.v = g.mac(g.v)
+		if k.Sign() > 0 && k.Cmp(g.n) < 0 {
+			return k
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+	"testing"
+)
+
+func hexInt(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		panic("bad hex number " + s)
+	}
+	return n
+}
+
+// TestSignRFC6979 checks Sign against the P-256 SHA-256 vectors of RFC 6979
+// appendix A.2.5, and that VerifySignature takes only the low s form.
+func TestSignRFC6979(t *testing.T) {
+	curve := elliptic.P256()
+	priKey := &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: curve,
+			X:     hexInt("60FED4BA255A9D31C961EB74C6356D68C049B8923B61FA6CE669622E60F29FB6"),
+			Y:     hexInt("7903FE1008B8BC99A41AE9E95628BC64F2F1B20C2D7E9F5177A3C294D4462299"),
+		},
+		D: hexInt("C9AFA9D845BA75166B5C215767B1D6934E50C3DB36E89B127B8A622B120F6721"),
+	}
+	pubKey := SerializePublicKey(&priKey.PublicKey)
+
+	tests := []struct {
+		message string
+		r, s    string
+	}{
+		{
+			"sample",
+			"EFD48B2AACB6A8FD1140DD9CD45E81D69D2C877B56AAF991C34D0EA84EAF3716",
+			"F7CB1C942D657C41D436C7A1B6E29F65F3E900DBB9AFF4064DC4AB2F843ACDA8",
+		},
+		{
+			"test",
+			"F1ABB023518351CD71D881567B1EA663ED3EFCF6C5132B354F28D3B0B7D38367",
+			"019F4113742A2B14BD25926B49C649155F267E60D3814B4C0CC84250E46F0083",
+		},
+	}
+	n := curve.Params().N
+	for _, test := range tests {
+		t.Run(test.message, func(t *testing.T) {
+			hash := sha256.Sum256([]byte(test.message))
+			// Sign gives the low s of the pair s and N-s
+			r, s := hexInt(test.r), hexInt(test.s)
+			lowS := new(big.Int).Set(s)
+			if highS := new(big.Int).Sub(n, s); highS.Cmp(s) > 0 {
+				s = highS
+			} else {
+				lowS = highS
+			}
+			want := make([]byte, SignatureLength)
+			r.FillBytes(want[:32])
+			lowS.FillBytes(want[32:])
+			signature := Sign(priKey, hash[:])
+			if got := hex.EncodeToString(signature); got != hex.EncodeToString(want) {
+				t.Errorf("Sign(%q) = %s, want %s", test.message, got, hex.EncodeToString(want))
+			}
+			if !VerifySignature(pubKey, hash[:], signature) {
+				t.Errorf("signature of %q does not verify", test.message)
+			}
+			other := sha256.Sum256([]byte(test.message + "!"))
+			if VerifySignature(pubKey, other[:], signature) {
+				t.Errorf("signature of %q verifies another hash", test.message)
+			}
+			highSig := make([]byte, SignatureLength)
+			r.FillBytes(highSig[:32])
+			s.FillBytes(highSig[32:])
+			if !ecdsa.Verify(&priKey.PublicKey, hash[:], r, s) {
+				t.Fatalf("high s signature of %q is not valid ECDSA", test.message)
+			}
+			if VerifySignature(pubKey, hash[:], highSig) {
+				t.Errorf("high s signature of %q verifies", test.message)
+			}
+		})
+	}
+}
+
+// TestSignEd25519 checks Sign against the first Ed25519 vector of RFC 8032
+// section 7.1, which signs the empty message, and that signatures only
+// verify under the key and scheme that made them.
+func TestSignEd25519(t *testing.T) {
+	seed, _ := hex.DecodeString("9d61b19deffd5a60ba844af492ec2cc44449c5697b326919703bac031cae7f60")
+	priKey := ed25519.NewKeyFromSeed(seed)
+	pubKey := SerializePublicKey(priKey.Public())
+	want := "e5564300c360ac729086e2cc806e828a84877f1eb8e5d974d873e065224901555fb8821590a33bacc61e39701cf9b46bd25bf5f0595bbe24655141438e7a100b"
+	if got := hex.EncodeToString(pubKey[1:]); got != "d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a" {
+		t.Fatalf("public key %s", got)
+	}
+	if keyType, err := PublicKeyType(pubKey); err != nil || keyType != KeyEd25519 {
+		t.Errorf("PublicKeyType() = %v, %v, want %v", keyType, err, KeyEd25519)
+	}
+
+	signature := Sign(priKey, nil)
+	if got := hex.EncodeToString(signature); got != want {
+		t.Errorf("Sign() = %s, want %s", got, want)
+	}
+	if !VerifySignature(pubKey, nil, signature) {
+		t.Error("signature does not verify")
+	}
+	hash := sha256.Sum256([]byte("sample"))
+	if VerifySignature(pubKey, hash[:], signature) {
+		t.Error("signature verifies another hash")
+	}
+	tampered := append([]byte{}, signature...)
+	tampered[0] ^= 1
+	if VerifySignature(pubKey, nil, tampered) {
+		t.Error("tampered signature verifies")
+	}
+	if VerifySignature(pubKey, nil, signature[:SignatureLength-1]) {
+		t.Error("short signature verifies")
+	}
+
+	p256 := MakeWalletOfType(KeyP256)
+	if VerifySignature(p256.PublicKey, nil, signature) {
+		t.Error("Ed25519 signature verifies under a P-256 key")
+	}
+	if VerifySignature(pubKey, hash[:], Sign(p256.PrivateKey, hash[:])) {
+		t.Error("P-256 signature verifies under an Ed25519 key")
+	}
+
+	w := MakeWalletOfType(KeyEd25519)
+	if w.KeyType() != KeyEd25519 || Base58Decode(w.Address())[0] != Ed25519Version {
+		t.Errorf("Ed25519 wallet of type %v has address %s", w.KeyType(), w.Address())
+	}
+	if !VerifySignature(w.PublicKey, hash[:], Sign(w.PrivateKey, hash[:])) {
+		t.Error("signature of a new Ed25519 wallet does not verify")
+	}
+}
@@ -2,49 +2,72 @@ package wallet
 
 import (
 	"bytes"
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
+	"crypto"
 	"crypto/sha256"
 	"log"
 
+	"github.com/mr-tron/base58"
 	"golang.org/x/crypto/ripemd160"
 )
 
-const (
-	checksumLength = 4
-	version        = byte(0x00)
-)
+const checksumLength = 4
+
+// Version is the first byte of every address. Chains may choose their own so
+// that addresses of one chain are rejected by another.
+var Version = byte(0x00)
+
+// ScriptVersion is the first byte of addresses that pay to the hash of a
+// script, such as a multisig script, rather than to the hash of one key.
+var ScriptVersion = byte(0x05)
 
 type Wallet struct {
-	PrivateKey ecdsa.PrivateKey
+	PrivateKey crypto.Signer
 	PublicKey  []byte
 }
 
+// KeyType returns the signature scheme of the wallet key.
+func (w Wallet) KeyType() KeyType {
+	keyType, err := PublicKeyType(w.PublicKey)
+	if err != nil {
+		log.Panic(err)
+	}
+	return keyType
+}
+
 func (w Wallet) Address() []byte {
 	pubHash := PublicKeyHash(w.PublicKey)
+	return EncodeAddress(w.KeyType().AddressVersion(), pubHash)
+}
+
+// ScriptAddress returns the address paying to the script with the given hash.
+func ScriptAddress(scriptHash []byte) []byte {
+	return EncodeAddress(ScriptVersion, scriptHash)
+}
 
-	versionedHash := append([]byte{version}, pubHash...)
+func EncodeAddress(version byte, hash []byte) []byte {
+	versionedHash := append([]byte{version}, hash...)
 	checksum := Checksum(versionedHash)
 
 	fullHash := append(versionedHash, checksum...)
 	address := Base58Encode(fullHash)
 	return address
 }
-func NewKeyPair() (ecdsa.PrivateKey, []byte) {
-	curve := elliptic.P256()
-
-	private, err := ecdsa.GenerateKey(curve, rand.Reader)
+func NewKeyPair(keyType KeyType) (crypto.Signer, []byte) {
+	private, err := generateKey(keyType)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	pub := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
-	return *private, pub
+	return private, SerializePublicKey(private.Public())
 }
 
+// MakeWallet returns a wallet with a new P-256 key.
 func MakeWallet() *Wallet {
-	private, public := NewKeyPair()
+	return MakeWalletOfType(KeyP256)
+}
+
+func MakeWalletOfType(keyType KeyType) *Wallet {
+	private, public := NewKeyPair(keyType)
 	wallet := Wallet{private, public}
 	return &wallet
 }
@@ -69,12 +92,35 @@ func Checksum(payload []byte) []byte {
 	return secondHash[:checksumLength]
 }
 
+// ValidateAddress reports whether address is the base58 encoding of a known
+// version, a 20 byte hash and a matching checksum.
 func ValidateAddress(address string) bool {
-	pubKeyHash := Base58Decode([]byte(address))
+	return ValidateAddressVersion(address, Version, Ed25519Version, ScriptVersion)
+}
+
+// ValidateAddressVersion is ValidateAddress for the given address versions,
+// for checking addresses of a chain whose versions are not in use yet.
+func ValidateAddressVersion(address string, versions ...byte) bool {
+	pubKeyHash, err := base58.Decode(address)
+	if err != nil || len(pubKeyHash) != 1+ripemd160.Size+checksumLength {
+		return false
+	}
 	actualChecksum := pubKeyHash[len(pubKeyHash)-checksumLength:]
 	version := pubKeyHash[0]
 	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-checksumLength]
 	targetChecksum := Checksum(append([]byte{version}, pubKeyHash...))
+	if !bytes.Equal(actualChecksum, targetChecksum) {
+		return false
+	}
+	for _, v := range versions {
+		if version == v {
+			return true
+		}
+	}
+	return false
+}
 
-	return bytes.Equal(actualChecksum, targetChecksum)
+// IsScriptAddress reports whether a valid address pays to a script hash.
+func IsScriptAddress(address string) bool {
+	return Base58Decode([]byte(address))[0] == ScriptVersion
 }
@@ -0,0 +1,42 @@
+package wallet
+
+import (
+	"testing"
+
+	"github.com/mr-tron/base58"
+)
+
+func TestValidateAddress(t *testing.T) {
+	p256 := string(MakeWalletOfType(KeyP256).Address())
+	raw, err := base58.Decode(p256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	badChecksum := append([]byte{}, raw...)
+	badChecksum[len(badChecksum)-1] ^= 1
+	hash := raw[1 : len(raw)-checksumLength]
+
+	tests := []struct {
+		name    string
+		address string
+		ok      bool
+	}{
+		{"p256", p256, true},
+		{"ed25519", string(MakeWalletOfType(KeyEd25519).Address()), true},
+		{"script", string(ScriptAddress(hash)), true},
+		{"empty", "", false},
+		{"short", p256[:10], false},
+		{"long", p256 + "2", false},
+		{"not base58", "0OIl" + p256[4:], false},
+		{"bad checksum", base58.Encode(badChecksum), false},
+		{"unknown version", string(EncodeAddress(0x30, hash)), false},
+	}
+	for _, test := range tests {
+		if ok := ValidateAddress(test.address); ok != test.ok {
+			t.Errorf("%s: ValidateAddress(%q) = %v, want %v", test.name, test.address, ok, test.ok)
+		}
+	}
+	if !ValidateAddressVersion(string(EncodeAddress(0x30, hash)), 0x30) {
+		t.Error("ValidateAddressVersion rejects an address of its own version")
+	}
+}
@@ -2,8 +2,8 @@ package wallet
 
 import (
 	"bytes"
-	"crypto/elliptic"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -12,21 +12,39 @@ import (
 
 const walletFile = "./tmp/wallets_%s.data"
 
+// walletFileVersion is bumped whenever the layout of the wallet file or the
+// derivation of addresses from keys changes. Version 1 files only held
+// P-256 scalars and are still read.
+const walletFileVersion = 2
+
+var ErrWalletFileFormat = errors.New("Wallet file has an unsupported format, create a new wallet")
+
 type Wallets struct {
 	Wallets map[string]*Wallet
 }
 
+// walletFileContent is what the wallet file stores: the type and private
+// scalar or seed of every key, from which the public key and the address
+// are derived again.
+type walletFileContent struct {
+	Version int
+	Keys    [][]byte
+}
+
 func (ws *Wallets) SaveFile(nodeId string) {
 	var content bytes.Buffer
 	walletFile := fmt.Sprintf(walletFile, nodeId)
-	gob.Register(elliptic.P256())
 
+	file := walletFileContent{Version: walletFileVersion}
+	for _, w := range ws.Wallets {
+		file.Keys = append(file.Keys, marshalPrivateKey(w.PrivateKey))
+	}
 	encoder := gob.NewEncoder(&content)
-	err := encoder.Encode(ws)
+	err := encoder.Encode(file)
 	if err != nil {
 		log.Panic(err)
 	}
-	err = ioutil.WriteFile(walletFile, content.Bytes(), 0644)
+	err = ioutil.WriteFile(walletFile, content.Bytes(), 0600)
 	if err != nil {
 		log.Panic(err)
 	}
@@ -38,18 +56,28 @@ func (ws *Wallets) LoadFile(nodeId string) error {
 		return err
 	}
 
-	var wallets Wallets
 	fileContent, err := ioutil.ReadFile(walletFile)
 	if err != nil {
 		return err
 	}
-	gob.Register(elliptic.P256())
+	var file walletFileContent
 	decoder := gob.NewDecoder(bytes.NewReader(fileContent))
-	err = decoder.Decode(&wallets)
-	if err != nil {
-		return err
+	if err := decoder.Decode(&file); err != nil || file.Version < 1 || file.Version > walletFileVersion {
+		return ErrWalletFileFormat
+	}
+	wallets := make(map[string]*Wallet)
+	for _, key := range file.Keys {
+		if file.Version == 1 {
+			key = append([]byte{byte(KeyP256)}, key...)
+		}
+		priKey, err := unmarshalPrivateKey(key)
+		if err != nil {
+			return err
+		}
+		w := &Wallet{priKey, SerializePublicKey(priKey.Public())}
+		wallets[string(w.Address())] = w
 	}
-	ws.Wallets = wallets.Wallets
+	ws.Wallets = wallets
 	return nil
 }
 
@@ -74,8 +102,8 @@ func (ws *Wallets) GetAllAddresses() []string {
 	return addresses
 }
 
-func (ws *Wallets) AddWallet() string {
-	wallet := MakeWallet()
+func (ws *Wallets) AddWallet(keyType KeyType) string {
+	wallet := MakeWalletOfType(keyType)
 	address := string(wallet.Address())
 
 	ws.Wallets[address] = wallet